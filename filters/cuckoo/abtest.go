@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Arm is one filter configuration evaluated by an ABTest
+type Arm struct {
+	Name   string
+	Filter Lookuper
+	Memory uint64 // bytes used by the filter, e.g. from EstimateMemory
+
+	lookups        atomic.Uint64
+	hits           atomic.Uint64
+	falsePositives atomic.Uint64 // hits not confirmed by the exact set
+	latency        latencyHistogram
+}
+
+// ABTest runs two filter configurations side by side on the same traffic
+// (e.g., 8-bit vs. 16-bit fingerprints). Both filters must be built from the
+// same list as the exact set, which tells false positives apart from true hits.
+// It is safe for concurrent use: the statistics are atomic counters.
+type ABTest struct {
+	arms  [2]*Arm
+	exact ExactSet
+}
+
+// NewABTest compares the arms a and b, confirming their hits against exact
+func NewABTest(a, b *Arm, exact ExactSet) *ABTest {
+	return &ABTest{arms: [2]*Arm{a, b}, exact: exact}
+}
+
+// Observe looks the item up in both arms and records the outcome.
+// The exact set is consulted at most once per item, once both arms were
+// looked up.
+// It returns an error if the exact set fails; the lookups and hits of both
+// arms are still recorded, but not whether the hits were false positives.
+func (t *ABTest) Observe(item string) error {
+	var hits [2]bool
+	for i, arm := range t.arms {
+		start := time.Now()
+		hits[i] = arm.Filter.lookup(item)
+		arm.latency.observe(time.Since(start))
+
+		arm.lookups.Add(1)
+		if hits[i] {
+			arm.hits.Add(1)
+		}
+	}
+	if !hits[0] && !hits[1] {
+		return nil
+	}
+	member, err := t.exact.Contains(item)
+	if err != nil {
+		return err
+	}
+	for i, arm := range t.arms {
+		if hits[i] && !member {
+			arm.falsePositives.Add(1)
+		}
+	}
+	return nil
+}
+
+// ArmReport summarizes one arm of an ABTest
+type ArmReport struct {
+	Name           string
+	Lookups        uint64
+	Hits           uint64
+	FalsePositives uint64
+	FPRate         float64 // false positives per lookup
+	Memory         uint64
+	P50, P99       time.Duration // lookup latency quantiles (upper bounds)
+}
+
+// ABReport compares the two arms of an ABTest
+type ABReport [2]ArmReport
+
+// Report returns the statistics gathered so far
+func (t *ABTest) Report() ABReport {
+	var r ABReport
+	for i, arm := range t.arms {
+		// loaded in this order, false positives never exceed hits, nor hits lookups
+		fps := arm.falsePositives.Load()
+		hits := arm.hits.Load()
+		r[i] = ArmReport{
+			Name:           arm.Name,
+			Lookups:        arm.lookups.Load(),
+			Hits:           hits,
+			FalsePositives: fps,
+			Memory:         arm.Memory,
+			P50:            arm.latency.quantile(0.5),
+			P99:            arm.latency.quantile(0.99),
+		}
+		if r[i].Lookups > 0 {
+			r[i].FPRate = float64(fps) / float64(r[i].Lookups)
+		}
+	}
+	return r
+}
+
+// String formats the report as a table, one line per arm
+func (r ABReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-16s %10s %10s %10s %10s %12s %10s %10s\n",
+		"arm", "lookups", "hits", "fp", "fp rate", "memory", "p50", "p99")
+	for _, a := range r {
+		fmt.Fprintf(&sb, "%-16s %10d %10d %10d %10.6f %12d %10s %10s\n",
+			a.Name, a.Lookups, a.Hits, a.FalsePositives, a.FPRate, a.Memory, a.P50, a.P99)
+	}
+	return sb.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingSet counts the queries of an exact set
+type countingSet struct {
+	ExactSet
+	queries atomic.Uint64
+}
+
+func (s *countingSet) Contains(item string) (bool, error) {
+	s.queries.Add(1)
+	return s.ExactSet.Contains(item)
+}
+
+func TestABTest(t *testing.T) {
+	small := NewCuckooFilter(1000, 0.2) // one byte fingerprints
+	large := NewCuckooFilter(1000, 1e-6)
+	members := make(MapSet)
+	for i := 0; i < 500; i++ {
+		item := strconv.Itoa(i)
+		members.Add(item)
+		for _, f := range []*Cuckoo{small, large} {
+			if err := f.insert(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	exact := &countingSet{ExactSet: members}
+	ab := NewABTest(&Arm{Name: "8-bit", Filter: small, Memory: 1}, &Arm{Name: "wide", Filter: large, Memory: 2}, exact)
+
+	// members and non-members, observed concurrently
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w * 5000; i < (w+1)*5000; i++ {
+				if err := ab.Observe(strconv.Itoa(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	r := ab.Report()
+	for _, a := range r {
+		if a.Lookups != 20000 {
+			t.Errorf("%s: %d lookups, want 20000", a.Name, a.Lookups)
+		}
+		if a.Hits-a.FalsePositives != 500 {
+			t.Errorf("%s: %d hits, %d false positives, want 500 true hits", a.Name, a.Hits, a.FalsePositives)
+		}
+		if a.P50 == 0 {
+			t.Errorf("%s: no latency recorded", a.Name)
+		}
+	}
+	if r[0].FalsePositives == 0 || r[0].FalsePositives <= r[1].FalsePositives {
+		t.Errorf("false positives: %d for 8-bit fingerprints, %d for wide ones", r[0].FalsePositives, r[1].FalsePositives)
+	}
+	// one query per item hit by either arm
+	if q := exact.queries.Load(); q < r[0].Hits || q > r[0].Hits+r[1].Hits {
+		t.Errorf("%d exact set queries for %d and %d hits", q, r[0].Hits, r[1].Hits)
+	}
+	if s := r.String(); !strings.Contains(s, "8-bit") || !strings.Contains(s, "wide") {
+		t.Errorf("report lacks the arms:\n%s", s)
+	}
+
+	// an exact set error still records the lookups of both arms
+	failing := NewABTest(&Arm{Name: "a", Filter: small}, &Arm{Name: "b", Filter: large}, failingSet{})
+	if err := failing.Observe("1"); !errors.Is(err, errUnreachable) {
+		t.Fatalf("Observe = %v, want the exact set error", err)
+	}
+	for _, a := range failing.Report() {
+		if a.Lookups != 1 || a.Hits != 1 || a.FalsePositives != 0 {
+			t.Errorf("%s after an exact set error: %+v", a.Name, a)
+		}
+	}
+}
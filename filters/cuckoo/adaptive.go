@@ -0,0 +1,227 @@
+//go:build experimental
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+// Adaptive cuckoo filter based on https://arxiv.org/abs/1704.06818
+// (M. Mitzenmacher, S. Pontarelli, P. Reviriego, "Adaptive Cuckoo Filters").
+//
+// Once the exact-confirmation layer has identified a false positive, a plain
+// cuckoo filter keeps returning it, so a hot negative (e.g., a popular exchange
+// address colliding with a blocklisted one) is a false positive on every lookup.
+// Each slot of the adaptive filter also stores a selector choosing which of
+// several hash functions produced its fingerprint. When a false positive is
+// reported, the colliding slot switches to the next selector and re-encodes
+// its fingerprint, which then most likely no longer matches the negative.
+//
+// Re-encoding needs the item that owns the slot. As in the paper, the
+// filter is split in two: the compact part holds the fingerprints, in a slab
+// (see slab.go), and the 2-bit selectors, packed four to a byte; the remote
+// representation holds the item of every slot, in a table mirroring the
+// slots. Lookups only read the compact part. The remote one is only read to
+// relocate an entry, to delete an item, and to re-encode a slot after a
+// false positive, so it can be kept where memory is cheap and slow.
+
+// number of fingerprint selectors per slot (2 bits)
+const selectors = 4
+
+// Adaptive is an adaptive cuckoo filter
+type Adaptive struct {
+	slots  []byte // compact part: m*b fingerprints of f bytes, all zeros when empty
+	sels   []byte // compact part: the selector of every slot, 2 bits each
+	remote adaptiveRemote
+	m      uint // number of buckets
+	mask   uint // m - 1, used to reduce hashes to bucket indices
+	b      uint // number of entries per bucket
+	f      uint // fingerprint length in b_size-bit units
+}
+
+// adaptiveRemote is the remote representation of an adaptive filter: the
+// item owning each slot, "" for an empty slot
+type adaptiveRemote []string
+
+var _ DeletableFilter = (*Adaptive)(nil)
+
+// NewAdaptiveFilter creates an adaptive cuckoo filter with the same sizing
+// as NewCuckooFilter
+// n: number of items - filter capacity
+// e: false positive rate (e.g., 0.01)
+func NewAdaptiveFilter(n uint, e float64) *Adaptive {
+	m, f := cuckooParams(n, e)
+	return &Adaptive{
+		slots:  make([]byte, m*b*f),
+		sels:   make([]byte, (m*b+3)/4),
+		remote: make(adaptiveRemote, m*b),
+		m:      m, mask: m - 1, b: b, f: f,
+	}
+}
+
+// entry returns the fingerprint of slot k, in the filter's storage
+func (a *Adaptive) entry(k uint) fingerprint {
+	return fingerprint(a.slots[k*a.f : (k+1)*a.f : (k+1)*a.f])
+}
+
+// sel returns the selector of slot k
+func (a *Adaptive) sel(k uint) uint8 {
+	return a.sels[k/4] >> (2 * (k % 4)) & 3
+}
+
+// setSel sets the selector of slot k
+func (a *Adaptive) setSel(k uint, sel uint8) {
+	shift := 2 * (k % 4)
+	a.sels[k/4] = a.sels[k/4]&^(3<<shift) | sel<<shift
+}
+
+// indices returns the two candidate buckets of an item.
+// Unlike the cuckoo filter, the alternate bucket is not derived from the
+// fingerprint (which changes with the selector) but from the item itself,
+// which is always known when relocating.
+func (a *Adaptive) indices(item string) (uint, uint) {
+	h := hash([]byte(item))
+	i1 := uint(hashIndex(h)) & a.mask
+	i2 := uint(hashIndex(hash(h))) & a.mask
+	return i1, i2
+}
+
+// fingerprintOf returns the fingerprint of an item for a selector.
+// Selector 0 is the plain cuckoo fingerprint; other selectors hash the
+// item prefixed with the selector.
+func (a *Adaptive) fingerprintOf(item string, sel uint8) fingerprint {
+	data := []byte(item)
+	if sel != 0 {
+		data = append([]byte{sel}, data...)
+	}
+	return nonZero(fingerprint(hash(data)[0:a.f]))
+}
+
+// store writes an entry (fingerprint, selector and item) to slot k
+func (a *Adaptive) store(k uint, f fingerprint, sel uint8, item string) {
+	copy(a.entry(k), f)
+	a.setSel(k, sel)
+	a.remote[k] = item
+}
+
+// insert adds an item, relocating existing entries like the cuckoo filter
+func (a *Adaptive) insert(item string) error {
+	i1, i2 := a.indices(item)
+	f, sel := a.fingerprintOf(item, 0), uint8(0)
+
+	if a.place(i1, f, sel, item) || a.place(i2, f, sel, item) {
+		return nil
+	}
+
+	i := i1
+	for r := 0; r < retries; r++ {
+		// swap with a random entry of the bucket
+		k := i*a.b + uint(rand.Intn(int(a.b)))
+		evicted := adaptiveEntry{f: append(fingerprint(nil), a.entry(k)...), sel: a.sel(k), item: a.remote[k]}
+		a.store(k, f, sel, item)
+		f, sel, item = evicted.f, evicted.sel, evicted.item
+		// the evicted entry moves to its other bucket
+		if j1, j2 := a.indices(item); i == j1 {
+			i = j2
+		} else {
+			i = j1
+		}
+		if a.place(i, f, sel, item) {
+			return nil
+		}
+	}
+	return ErrFull
+}
+
+// adaptiveEntry is an entry evicted during a relocation
+type adaptiveEntry struct {
+	f    fingerprint
+	sel  uint8
+	item string
+}
+
+// place stores the entry in an empty slot of bucket i, if there is one
+func (a *Adaptive) place(i uint, f fingerprint, sel uint8, item string) bool {
+	for k := i * a.b; k < (i+1)*a.b; k++ {
+		if isEmpty(a.entry(k)) {
+			a.store(k, f, sel, item)
+			return true
+		}
+	}
+	return false
+}
+
+// matches calls fn for every slot of the item's buckets whose fingerprint
+// matches the item under the slot's selector, until fn returns false.
+// It only reads the compact part of the filter.
+func (a *Adaptive) matches(item string, fn func(k uint) bool) {
+	// fingerprints of the item are computed once per selector
+	var fps [selectors]fingerprint
+
+	i1, i2 := a.indices(item)
+	for _, i := range []uint{i1, i2} {
+		for k := i * a.b; k < (i+1)*a.b; k++ {
+			e := a.entry(k)
+			if isEmpty(e) {
+				continue
+			}
+			sel := a.sel(k)
+			if fps[sel] == nil {
+				fps[sel] = a.fingerprintOf(item, sel)
+			}
+			if bytes.Equal(e, fps[sel]) && !fn(k) {
+				return
+			}
+		}
+		if i1 == i2 {
+			break
+		}
+	}
+}
+
+// lookup needle in the adaptive cuckoo filter
+func (a *Adaptive) lookup(needle string) bool {
+	found := false
+	a.matches(needle, func(uint) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// delete removes the item from the filter.
+// The remote representation tells which matching slot is the item's own, so
+// the slot of another item with the same fingerprint is never cleared.
+func (a *Adaptive) delete(needle string) {
+	a.matches(needle, func(k uint) bool {
+		if a.remote[k] != needle {
+			return true
+		}
+		clear(a.entry(k))
+		a.setSel(k, 0)
+		a.remote[k] = ""
+		return false
+	})
+}
+
+// reportFalsePositive adapts the filter after the item was confirmed not to
+// be a member: every slot matching it switches to its next selector, and its
+// fingerprint is computed again from the item of the remote representation.
+// It returns true if a slot was re-encoded.
+func (a *Adaptive) reportFalsePositive(item string) bool {
+	adapted := false
+	a.matches(item, func(k uint) bool {
+		owner := a.remote[k]
+		if owner == item {
+			// not a false positive: the item is in the filter
+			return true
+		}
+		sel := (a.sel(k) + 1) % selectors
+		copy(a.entry(k), a.fingerprintOf(owner, sel))
+		a.setSel(k, sel)
+		adapted = true
+		return true
+	})
+	return adapted
+}
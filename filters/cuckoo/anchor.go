@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Anchoring publishes a commitment to the screening list on a blockchain, so
+// anyone can later check which list was enforced at a given time.
+// The commitment is the root of a Merkle tree whose leaves are the stored
+// fingerprints in Range order; the chain only ever sees the 32-byte root.
+// Leaves and inner nodes are hashed with distinct prefixes (as in RFC 6962)
+// so a leaf cannot be passed off as an inner node.
+
+// MerkleRoot returns the commitment to the fingerprints of the snapshot.
+// Equal filters (see Cuckoo.Equal) have the same root.
+func (fz *Frozen) MerkleRoot() [32]byte {
+	var level [][32]byte
+	var idx [8]byte
+	fz.Range(func(bucketIdx uint, fp []byte) bool {
+		binary.BigEndian.PutUint64(idx[:], uint64(bucketIdx))
+		h := sha256.New()
+		h.Write([]byte{0})
+		h.Write(idx[:])
+		h.Write(fp)
+		var leaf [32]byte
+		h.Sum(leaf[:0])
+		level = append(level, leaf)
+		return true
+	})
+	if len(level) == 0 {
+		return sha256.Sum256(nil)
+	}
+
+	for len(level) > 1 {
+		next := level[:0]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				// an odd node is promoted to the next level unchanged
+				next = append(next, level[i])
+				break
+			}
+			var node [65]byte
+			node[0] = 1
+			copy(node[1:], level[i][:])
+			copy(node[33:], level[i+1][:])
+			next = append(next, sha256.Sum256(node[:]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// Anchor is a commitment published on chain
+type Anchor struct {
+	Root [32]byte
+	TxID string // transaction carrying the root
+	Time time.Time
+}
+
+// AnchorSigner publishes the root on chain, as calldata or in a raw
+// transaction, through the wallet's RPC signer, and returns the transaction id
+type AnchorSigner func(ctx context.Context, root [32]byte) (txID string, err error)
+
+// AnchorReader returns the root carried by an anchoring transaction
+type AnchorReader func(ctx context.Context, txID string) ([32]byte, error)
+
+// Anchorer periodically publishes the root of the current screening snapshot.
+// It is safe for concurrent use.
+type Anchorer struct {
+	snapshot func() *Frozen // returns the snapshot currently served
+	sign     AnchorSigner
+	interval time.Duration
+
+	mu      sync.Mutex
+	anchors []Anchor // oldest first
+}
+
+// NewAnchorer returns an anchorer publishing the root of snapshot() with sign
+// every interval (see Run)
+func NewAnchorer(snapshot func() *Frozen, sign AnchorSigner, interval time.Duration) *Anchorer {
+	return &Anchorer{snapshot: snapshot, sign: sign, interval: interval}
+}
+
+// Publish anchors the current snapshot now.
+// Nothing is published if its root is the one anchored last: an unchanged
+// list does not cost a transaction.
+func (a *Anchorer) Publish(ctx context.Context) (Anchor, error) {
+	root := a.snapshot().MerkleRoot()
+
+	a.mu.Lock()
+	if n := len(a.anchors); n > 0 && a.anchors[n-1].Root == root {
+		last := a.anchors[n-1]
+		a.mu.Unlock()
+		return last, nil
+	}
+	a.mu.Unlock()
+
+	txID, err := a.sign(ctx, root)
+	if err != nil {
+		return Anchor{}, fmt.Errorf("anchoring root %x: %w", root, err)
+	}
+	anchor := Anchor{Root: root, TxID: txID, Time: time.Now()}
+
+	a.mu.Lock()
+	a.anchors = append(a.anchors, anchor)
+	a.mu.Unlock()
+	return anchor, nil
+}
+
+// Run publishes the snapshot every interval until ctx is done.
+// Failures are logged and retried at the next interval.
+func (a *Anchorer) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.Publish(ctx); err != nil {
+				log.Printf("anchor: %v", err)
+			}
+		}
+	}
+}
+
+// Anchors returns the anchors published so far, oldest first
+func (a *Anchorer) Anchors() []Anchor {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Anchor(nil), a.anchors...)
+}
+
+// VerifyAnchor checks that a historical snapshot is the one committed to by
+// the anchoring transaction txID, reading the on-chain root with read
+func VerifyAnchor(ctx context.Context, fz *Frozen, txID string, read AnchorReader) error {
+	onChain, err := read(ctx, txID)
+	if err != nil {
+		return fmt.Errorf("reading anchor %s: %w", txID, err)
+	}
+	if root := fz.MerkleRoot(); root != onChain {
+		return fmt.Errorf("snapshot root %x does not match anchor %s root %x", root, txID, onChain)
+	}
+	return nil
+}
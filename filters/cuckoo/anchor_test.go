@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	if c.Freeze().MerkleRoot() != sha256.Sum256(nil) {
+		t.Error("root of an empty filter")
+	}
+
+	// the root of one fingerprint is its leaf hash
+	c.insert("a")
+	var leaf [32]byte
+	c.Range(func(i uint, fp []byte) bool {
+		var idx [8]byte
+		binary.BigEndian.PutUint64(idx[:], uint64(i))
+		leaf = sha256.Sum256(append(append([]byte{0}, idx[:]...), fp...))
+		return true
+	})
+	if c.Freeze().MerkleRoot() != leaf {
+		t.Error("root of one fingerprint is not its leaf")
+	}
+
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	root := c.Freeze().MerkleRoot()
+	if c.Clone().Freeze().MerkleRoot() != root {
+		t.Error("equal filters have different roots")
+	}
+	c.insert("one more")
+	if c.Freeze().MerkleRoot() == root {
+		t.Error("root unchanged by an insert")
+	}
+}
+
+func TestAnchorer(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	c.insert("sanctioned")
+	var mu sync.Mutex
+	current := c.Freeze()
+	snapshot := func() *Frozen {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	}
+	onChain := make(map[string][32]byte)
+	var signs atomic.Int32
+	errSign := errors.New("rpc down")
+	failing := false
+	sign := func(ctx context.Context, root [32]byte) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return "", errSign
+		}
+		txID := "tx" + strconv.Itoa(int(signs.Add(1)))
+		onChain[txID] = root
+		return txID, nil
+	}
+	read := func(ctx context.Context, txID string) ([32]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		root, ok := onChain[txID]
+		if !ok {
+			return root, errors.New("no such transaction")
+		}
+		return root, nil
+	}
+
+	ctx := context.Background()
+	a := NewAnchorer(snapshot, sign, time.Hour)
+	first, err := a.Publish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unchanged list is not published again
+	if again, err := a.Publish(ctx); err != nil || again.TxID != first.TxID || signs.Load() != 1 {
+		t.Errorf("unchanged list published as %s (%v), %d transactions", again.TxID, err, signs.Load())
+	}
+
+	old := current
+	updated := c.Clone()
+	updated.insert("newly sanctioned")
+	mu.Lock()
+	current, failing = updated.Freeze(), true
+	mu.Unlock()
+	if _, err := a.Publish(ctx); !errors.Is(err, errSign) {
+		t.Errorf("Publish = %v, want the signer error", err)
+	}
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	second, err := a.Publish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchors := a.Anchors(); len(anchors) != 2 || anchors[0] != first || anchors[1] != second {
+		t.Errorf("anchors %+v", anchors)
+	}
+
+	// the snapshot of each anchor, and no other, verifies against it
+	if err := VerifyAnchor(ctx, old, first.TxID, read); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyAnchor(ctx, current, second.TxID, read); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyAnchor(ctx, current, first.TxID, read); err == nil {
+		t.Error("updated snapshot verified against the first anchor")
+	}
+	if err := VerifyAnchor(ctx, current, "tx404", read); err == nil {
+		t.Error("unknown anchor verified")
+	}
+}
+
+func TestAnchorerRun(t *testing.T) {
+	c := NewCuckooFilter(100, 0.01)
+	published := make(chan [32]byte, 1)
+	sign := func(ctx context.Context, root [32]byte) (string, error) {
+		select {
+		case published <- root:
+		default:
+		}
+		return "tx", nil
+	}
+	a := NewAnchorer(c.Freeze, sign, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	select {
+	case root := <-published:
+		if root != c.Freeze().MerkleRoot() {
+			t.Error("published root is not the snapshot's")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("nothing published")
+	}
+	cancel()
+	<-done
+}
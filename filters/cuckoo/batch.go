@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// batchLookuper is implemented by filters with a faster batch lookup than
+// one lookup per item
+type batchLookuper interface {
+	lookupBatch(items []string) []bool
+}
+
+// lookupBatch looks up every item in the filter and returns the results in
+// the same order, using the filter's batch implementation when it has one
+func lookupBatch(filter Lookuper, items []string) []bool {
+	if bl, ok := filter.(batchLookuper); ok {
+		return bl.lookupBatch(items)
+	}
+	found := make([]bool, len(items))
+	for i, item := range items {
+		found[i] = filter.lookup(item)
+	}
+	return found
+}
+
+// batchProbe is the hashed form of one item of a batch
+type batchProbe struct {
+	i1, i2 uint
+	f      fingerprint
+}
+
+// batchProbes recycles the probes of batches, which are as long as the batch
+var batchProbes = sync.Pool{New: func() any { return new([]batchProbe) }}
+
+// lookupBatch looks up a batch of items in two passes.
+// A lookup of a large filter mostly waits for its two buckets to come from
+// memory, and one item at a time leaves the CPU idle during each miss: the
+// hashing of the next item depends on nothing, but the CPU cannot look that
+// far ahead. The first pass only hashes: it computes the buckets and
+// fingerprints of every item. The second pass only compares fingerprints, so
+// the loads of consecutive items are independent and their cache misses
+// overlap (Go has no explicit prefetch instruction).
+// The probes are not sorted by bucket: in a filter much larger than the
+// caches, the buckets of a batch are too far apart to share cache lines, and
+// sorting cost more than it saved (see BenchmarkLookupBatch).
+// Large batches run both passes in parallel (see parallel).
+func (c *Cuckoo) lookupBatch(items []string) []bool {
+	if c.latency != nil {
+		// the batch is recorded as one lookup per item
+		start := time.Now()
+		defer func() {
+			per := time.Since(start) / time.Duration(max(len(items), 1))
+			c.latency[OpLookup].observeN(per, uint64(len(items)))
+		}()
+	}
+
+	pooled := batchProbes.Get().(*[]batchProbe)
+	defer batchProbes.Put(pooled)
+	if cap(*pooled) < len(items) {
+		*pooled = make([]batchProbe, len(items))
+	}
+	probes := (*pooled)[:len(items)]
+
+	c.parallel("lookup", len(items), func(lo, hi int) {
+		for pos := lo; pos < hi; pos++ {
+			i1, i2, f := c.hashes(items[pos])
+			probes[pos] = batchProbe{i1: i1, i2: i2, f: f}
+		}
+	})
+
+	found := make([]bool, len(items))
+	c.parallel("lookup", len(probes), func(lo, hi int) {
+		for pos := lo; pos < hi; pos++ {
+			p := &probes[pos]
+			found[pos] = c.contains(p.i1, p.i2, p.f)
+			p.f = nil // the pool must not keep the hashes alive
+		}
+	})
+	return found
+}
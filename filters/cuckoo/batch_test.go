@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	benchFilterItems = 1 << 24 // 64 MB of buckets, far larger than the caches
+	benchBatch       = 1 << 16
+)
+
+var (
+	benchOnce  sync.Once
+	benchItems []string
+	benchSlots []byte
+)
+
+// benchFilter returns a large filter, a quarter full, with the given options
+// and a batch of items, half of them inserted
+func benchFilter(b *testing.B, opts ...Option) (*Cuckoo, []string) {
+	b.Helper()
+	benchOnce.Do(func() {
+		c := NewCuckooFilter(benchFilterItems, 0.01)
+		for i := 0; i < benchFilterItems/4; i++ {
+			if err := c.insert(strconv.Itoa(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		benchSlots = c.slots
+		for i := 0; i < benchBatch; i++ {
+			benchItems = append(benchItems, strconv.Itoa(i*8))
+		}
+	})
+	c := NewCuckooFilter(benchFilterItems, 0.01, opts...)
+	copy(c.slots, benchSlots)
+	return c, benchItems
+}
+
+func TestLookupBatch(t *testing.T) {
+	c := NewCuckooFilter(10000, 0.01, WithBatchParallelism(100, 4))
+	var items []string
+	for i := 0; i < 5000; i++ {
+		if i%2 == 0 {
+			if err := c.insert(strconv.Itoa(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		items = append(items, strconv.Itoa(i))
+	}
+	found := lookupBatch(c, items)
+	for i, item := range items {
+		if found[i] != c.lookup(item) {
+			t.Fatalf("batch lookup of %q: %v, single lookup: %v", item, found[i], !found[i])
+		}
+	}
+}
+
+// BenchmarkLookupNaive is the baseline: one lookup per item.
+// The filter is far larger than the caches, so each lookup misses; the batch
+// lookups must beat it, on one goroutine already.
+func BenchmarkLookupNaive(b *testing.B) {
+	c, items := benchFilter(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, item := range items {
+			c.lookup(item)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(items)), "ns/item")
+}
+
+// BenchmarkLookupBatchSerial is the two-pass batch lookup on one goroutine
+func BenchmarkLookupBatchSerial(b *testing.B) {
+	c, items := benchFilter(b, WithBatchParallelism(benchBatch+1, 1))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c.lookupBatch(items)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(items)), "ns/item")
+}
+
+// BenchmarkLookupBatch is the two-pass batch lookup with its default workers
+func BenchmarkLookupBatch(b *testing.B) {
+	c, items := benchFilter(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c.lookupBatch(items)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(items)), "ns/item")
+}
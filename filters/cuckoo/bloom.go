@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// Blocked (register-blocked) Bloom filter based on
+// https://algo2.iti.kit.edu/documents/cacheefficientbloomfilters-jea.pdf
+// (F. Putze, P. Sanders, J. Singler, "Cache-, Hash- and Space-Efficient Bloom Filters").
+//
+// The bits are split into blocks of one cache line (512 bits). An item first
+// selects a block, then sets or checks its k bits inside that block only, so a
+// lookup touches a single cache line instead of k random ones: lookups are
+// several times faster, which makes it the right default for the latency-critical
+// screening check on the signing path.
+// The price is memory: items are not spread evenly over the blocks, so a
+// blocked filter needs more bits per item than a standard Bloom filter for
+// the same false positive rate (about 4% more at 0.01, 30% more at 0.00001,
+// where the 10 probes cap adds to it). NewBlockedBloomFilter sizes the
+// filter for the rate of the blocked filter, not that of a standard one.
+// Items cannot be removed.
+//
+// An item is hashed once, with bloomHash, a fast non-cryptographic hash
+// giving two 64-bit halves h1 and h2, and all k probes are derived from them
+// (Kirsch and Mitzenmacher: two hash values are enough for the k positions
+// of a Bloom filter). The first half selects the block, from its high bits.
+// The k probes are 9-bit slices (one position in the block each) of h2, then,
+// after 7 probes, of h1 remixed with mix64; the remix makes the positions
+// independent of the block.
+// The positions are not h1 + j*h2, the textbook form of double hashing:
+// taken mod 512 inside a block, they would only depend on h1 and h2 mod 512,
+// 2^18 sequences, and an item sharing the sequence of an item of its block
+// is always a false positive, which puts a floor of about
+// (items per block) / 2^18 on the rate.
+// bloomHash is several times cheaper than the SHA1 of the cuckoo filter (see
+// BenchmarkBloomHash), so the filter does one multiply-mix per 8 bytes of the
+// item instead of a cryptographic hash. It is not keyed: someone choosing
+// items can compute their probes, and craft false positives, which a screening
+// filter confirms against the exact set anyway; it cannot cause a false
+// negative.
+// Measured over 2M lookups of a 200k-item filter:
+//
+//	target  0.1     0.01     0.001    0.0001   0.00001
+//	actual  0.0976  0.00976  0.00099  0.000085 0.000007
+
+const (
+	bloomBlockWords = 8                    // 64-bit words per block
+	bloomBlockBits  = bloomBlockWords * 64 // 512 bits, one cache line
+	bloomMaxK       = 10                   // more probes only fill the block faster
+	bloomProbeBits  = 9                    // bits of a position in a block
+)
+
+// BlockedBloom is a blocked Bloom filter
+type BlockedBloom struct {
+	blocks [][bloomBlockWords]uint64
+	k      uint // number of probes per item
+}
+
+var _ Filter = (*BlockedBloom)(nil)
+
+// NewBlockedBloomFilter creates a blocked Bloom filter for n items and a false
+// positive rate e, with the parameters of blockedBloomParams
+func NewBlockedBloomFilter(n uint, e float64) *BlockedBloom {
+	blocks, k := blockedBloomParams(n, e)
+	return &BlockedBloom{
+		blocks: make([][bloomBlockWords]uint64, blocks),
+		k:      k,
+	}
+}
+
+// blockedBloomParams returns the number of blocks and of probes of a blocked
+// Bloom filter for n items and a false positive rate e: k = -log2(e) probes
+// (at most 10), and the fewest bits per item for which blockedFPRate is at
+// most e, starting from the m = -n ln(e) / ln(2)^2 bits of a standard Bloom
+// filter
+func blockedBloomParams(n uint, e float64) (blocks, k uint) {
+	bitsPerItem := -math.Log(e) / (math.Ln2 * math.Ln2)
+	k = uint(math.Round(bitsPerItem * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > bloomMaxK {
+		k = bloomMaxK
+	}
+	for blockedFPRate(bitsPerItem, k) > e {
+		bitsPerItem *= 1.02
+	}
+
+	m := uint(math.Ceil(float64(n) * bitsPerItem))
+	blocks = (m + bloomBlockBits - 1) / bloomBlockBits
+	if blocks == 0 {
+		blocks = 1
+	}
+	return blocks, k
+}
+
+// blockedFPRate returns the false positive rate of a blocked Bloom filter
+// with bitsPerItem bits per item and k probes: the rate of a standard Bloom
+// filter of one block, averaged over the Poisson distribution of the number
+// of items in a block (Putze et al., section 3)
+func blockedFPRate(bitsPerItem float64, k uint) float64 {
+	lambda := bloomBlockBits / bitsPerItem
+	p := math.Exp(-lambda) // probability of i items in the block
+	rate := 0.0
+	for i := 0; i < int(4*lambda)+64; i++ {
+		if i > 0 {
+			p *= lambda / float64(i)
+		}
+		rate += p * math.Pow(1-math.Pow(1-1.0/bloomBlockBits, float64(k)*float64(i)), float64(k))
+	}
+	return rate
+}
+
+// probes returns the block of an item and calls fn with the position of each of
+// its k bits in the block. The block is selected by the high bits of h1
+// (fastrange); the positions are successive 9-bit slices of h2 and of h1
+// remixed, so the two do not depend on the same bits of the hash.
+func (bf *BlockedBloom) probes(item string, fn func(blk *[bloomBlockWords]uint64, bit uint) bool) {
+	h1, h2 := bloomHash(item)
+
+	hi, _ := bits.Mul64(h1, uint64(len(bf.blocks)))
+	blk := &bf.blocks[hi]
+
+	pos, left := h2, 64
+	for j := uint(0); j < bf.k; j++ {
+		if left < bloomProbeBits {
+			pos, left = mix64(h1+uint64(j)), 64
+		}
+		if !fn(blk, uint(pos%bloomBlockBits)) {
+			return
+		}
+		pos >>= bloomProbeBits
+		left -= bloomProbeBits
+	}
+}
+
+// seeds of the two lanes of bloomHash (the first 128 bits of the fractional
+// part of the golden ratio)
+const (
+	bloomSeed1 = 0x9e3779b97f4a7c15
+	bloomSeed2 = 0xf39cc0605cedc834
+)
+
+// bloomHash returns two 64-bit hashes of an item. The item is read 16 bytes
+// at a time, in two lanes of 8 bytes mixed with mix64; the length of the
+// item seeds the first lane, so zero bytes at the end change the hash, and
+// the lanes are mixed together at the end, so each half depends on every
+// byte of the item.
+func bloomHash(item string) (h1, h2 uint64) {
+	a, b := bloomSeed1^uint64(len(item)), uint64(bloomSeed2)
+	for len(item) >= 16 {
+		a = mix64(a ^ binary.LittleEndian.Uint64([]byte(item[0:8])))
+		b = mix64(b ^ binary.LittleEndian.Uint64([]byte(item[8:16])))
+		item = item[16:]
+	}
+	var tail [16]byte
+	copy(tail[:], item)
+	a = mix64(a ^ binary.LittleEndian.Uint64(tail[0:8]))
+	b = mix64(b ^ binary.LittleEndian.Uint64(tail[8:16]))
+	return mix64(a ^ bits.RotateLeft64(b, 32)), mix64(b + a*bloomSeed1)
+}
+
+// mix64 is the finalizer of SplitMix64: every bit of the result depends on
+// every bit of x
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// insert sets the k bits of the item in its block
+func (bf *BlockedBloom) insert(item string) error {
+	bf.probes(item, func(blk *[bloomBlockWords]uint64, bit uint) bool {
+		blk[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	return nil
+}
+
+// lookup returns true if all k bits of the item are set in its block
+func (bf *BlockedBloom) lookup(item string) bool {
+	found := true
+	bf.probes(item, func(blk *[bloomBlockWords]uint64, bit uint) bool {
+		found = blk[bit/64]&(1<<(bit%64)) != 0
+		return found
+	})
+	return found
+}
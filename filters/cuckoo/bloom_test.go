@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestBlockedBloomFPRate checks the measured false positive rate against the
+// target, allowing for three standard deviations of sampling noise
+func TestBlockedBloomFPRate(t *testing.T) {
+	const items, lookups = 100000, 1000000
+	for _, e := range []float64{0.01, 0.0001, 0.00001} {
+		bf := NewBlockedBloomFilter(items, e)
+		for i := 0; i < items; i++ {
+			bf.insert(fmt.Sprintf("in-%d", i))
+		}
+		for i := 0; i < items; i++ {
+			if !bf.lookup(fmt.Sprintf("in-%d", i)) {
+				t.Fatalf("target %v: inserted item %d not found", e, i)
+			}
+		}
+		fp := 0
+		for i := 0; i < lookups; i++ {
+			if bf.lookup(fmt.Sprintf("out-%d", i)) {
+				fp++
+			}
+		}
+		limit := e*lookups + 3*math.Sqrt(e*lookups)
+		if float64(fp) > limit {
+			t.Errorf("target %v: %d false positives in %d lookups, at most %.0f expected", e, fp, lookups, limit)
+		}
+	}
+}
+
+// TestBloomHash checks that items differing in one byte, or in trailing
+// zero bytes, hash apart
+func TestBloomHash(t *testing.T) {
+	seen := make(map[[2]uint64]string)
+	add := func(item string) {
+		h1, h2 := bloomHash(item)
+		if other, ok := seen[[2]uint64{h1, h2}]; ok {
+			t.Errorf("%q and %q hash alike", item, other)
+		}
+		seen[[2]uint64{h1, h2}] = item
+	}
+	base := "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+	for n := 0; n <= len(base); n++ {
+		add(base[:n])
+		add(base[:n] + "\x00")
+	}
+	for i := range base {
+		add(base[:i] + "?" + base[i+1:])
+	}
+}
+
+// BenchmarkBloomHash compares the hash of the blocked Bloom filter with the
+// SHA1 of the cuckoo filter, for a 42-byte address
+func BenchmarkBloomHash(b *testing.B) {
+	const item = "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+	b.Run("bloomHash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bloomHash(item)
+		}
+	})
+	b.Run("sha1", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			hash([]byte(item))
+		}
+	})
+}
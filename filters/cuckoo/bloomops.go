@@ -0,0 +1,61 @@
+package main
+
+import "math/bits"
+
+// Bitwise operations on blocked Bloom filters. They work in place on the
+// words of the receiver, without allocating, and need compatible filters
+// (see BlockedBloom.Compatible): the same item sets the same bits in both.
+
+// Union adds the items of other to bf: bf becomes the filter of A ∪ B, the
+// same bits as if every item had been inserted in it
+func (bf *BlockedBloom) Union(other *BlockedBloom) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i := range bf.blocks {
+		for j := range bf.blocks[i] {
+			bf.blocks[i][j] |= other.blocks[i][j]
+		}
+	}
+	return nil
+}
+
+// Intersect keeps in bf the bits also set in other. Every item of A ∩ B is
+// still found, but the result is not the filter of A ∩ B: a bit set by an
+// item of A only and, separately, by an item of B only stays set, so the
+// false positive rate is above that of a filter built from the intersection
+// (at most that of either filter), and ApproximateCount overestimates it;
+// use EstimateIntersection to count the common items.
+func (bf *BlockedBloom) Intersect(other *BlockedBloom) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i := range bf.blocks {
+		for j := range bf.blocks[i] {
+			bf.blocks[i][j] &= other.blocks[i][j]
+		}
+	}
+	return nil
+}
+
+// BitCount returns the number of bits set in the filter
+func (bf *BlockedBloom) BitCount() uint64 {
+	var n uint64
+	for i := range bf.blocks {
+		for _, w := range bf.blocks[i] {
+			n += uint64(bits.OnesCount64(w))
+		}
+	}
+	return n
+}
+
+// ApproximateCount returns the estimated number of distinct items inserted,
+// from the fill ratio of each block (see overlap.go for the formula and its
+// error). Items inserted twice are counted once.
+func (bf *BlockedBloom) ApproximateCount() float64 {
+	var n float64
+	for i := range bf.blocks {
+		n += blockItems(blockOnes(&bf.blocks[i]), bf.k)
+	}
+	return n
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestBloomUnionIntersect(t *testing.T) {
+	const n = 20000
+	a, b := NewBlockedBloomFilter(2*n, 0.01), NewBlockedBloomFilter(2*n, 0.01)
+	for i := 0; i < n; i++ {
+		a.insert("a" + strconv.Itoa(i))
+		b.insert("b" + strconv.Itoa(i))
+		if i < n/2 {
+			a.insert("both" + strconv.Itoa(i))
+			b.insert("both" + strconv.Itoa(i))
+		}
+	}
+	if got := a.ApproximateCount(); math.Abs(got-1.5*n) > 0.01*1.5*n {
+		t.Errorf("ApproximateCount = %.0f, want %d", got, 3*n/2)
+	}
+	// a duplicate insert sets no bit
+	before := a.BitCount()
+	a.insert("a0")
+	if a.BitCount() != before {
+		t.Error("duplicate insert changed the bits")
+	}
+
+	// the union has the bits of a filter of every item
+	union := NewBlockedBloomFilter(2*n, 0.01)
+	for _, f := range []*BlockedBloom{a, b} {
+		if err := union.Union(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	direct := NewBlockedBloomFilter(2*n, 0.01)
+	for i := 0; i < n; i++ {
+		for _, p := range []string{"a", "b", "both"} {
+			if p != "both" || i < n/2 {
+				direct.insert(p + strconv.Itoa(i))
+			}
+		}
+	}
+	if union.BitCount() != direct.BitCount() {
+		t.Errorf("union of %d bits, filter of all the items %d", union.BitCount(), direct.BitCount())
+	}
+	for i := range union.blocks {
+		if union.blocks[i] != direct.blocks[i] {
+			t.Fatalf("block %d of the union differs", i)
+		}
+	}
+
+	// the intersection finds every common item, and overestimates their number
+	inter := NewBlockedBloomFilter(2*n, 0.01)
+	if err := inter.Union(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := inter.Intersect(b); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n/2; i++ {
+		if !inter.lookup("both" + strconv.Itoa(i)) {
+			t.Fatalf("common item %d lost", i)
+		}
+	}
+	if inter.BitCount() > min(a.BitCount(), b.BitCount()) {
+		t.Error("intersection has more bits than its operands")
+	}
+	if c := inter.ApproximateCount(); c < n/2 {
+		t.Errorf("ApproximateCount of the intersection = %.0f, below the %d common items", c, n/2)
+	}
+
+	other := NewBlockedBloomFilter(n, 0.01)
+	for _, op := range []func(*BlockedBloom) error{a.Union, a.Intersect} {
+		if err := op(other); !errors.Is(err, ErrIncompatibleParams) {
+			t.Errorf("operation on incompatible filters = %v", err)
+		}
+	}
+	if empty := NewBlockedBloomFilter(n, 0.01); empty.BitCount() != 0 || empty.ApproximateCount() != 0 {
+		t.Error("empty filter has bits or items")
+	}
+}
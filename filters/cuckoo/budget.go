@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryBudget caps the memory of all the filters of a process.
+// The screening service runs next to the signer: a filter sized by mistake
+// for a billion items must fail to build with an error, not take the
+// machine into the OOM killer along with the signer.
+// Every filter reserves its bytes under a name before it is allocated and
+// releases them when dropped; reservations beyond the cap are refused.
+// It is safe for concurrent use.
+type MemoryBudget struct {
+	mu      sync.Mutex
+	limit   uint64
+	used    uint64
+	filters map[string]uint64 // reserved bytes by filter name
+}
+
+// NewMemoryBudget returns a budget of limit bytes
+func NewMemoryBudget(limit uint64) *MemoryBudget {
+	return &MemoryBudget{limit: limit, filters: make(map[string]uint64)}
+}
+
+// BudgetError is returned when a reservation would exceed the budget
+type BudgetError struct {
+	Filter    string
+	Requested uint64 // bytes the filter would use
+	Used      uint64 // bytes reserved by the other filters
+	Limit     uint64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("memory budget exceeded: filter %q needs %d bytes, but %d of %d are reserved by other filters (%d free); "+
+		"raise the budget, lower its capacity or raise its false positive rate, or release another filter",
+		e.Filter, e.Requested, e.Used, e.Limit, e.Limit-e.Used)
+}
+
+// Reserve sets the reservation of the named filter to bytes, which creates,
+// grows or shrinks it. It returns a *BudgetError, and leaves the previous
+// reservation in place, if the total would exceed the limit.
+func (mb *MemoryBudget) Reserve(name string, bytes uint64) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	others := mb.used - mb.filters[name]
+	if bytes > mb.limit-others {
+		return &BudgetError{Filter: name, Requested: bytes, Used: others, Limit: mb.limit}
+	}
+	mb.filters[name] = bytes
+	mb.used = others + bytes
+	return nil
+}
+
+// Release drops the reservation of the named filter
+func (mb *MemoryBudget) Release(name string) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.used -= mb.filters[name]
+	delete(mb.filters, name)
+}
+
+// BudgetEntry is the reservation of one filter
+type BudgetEntry struct {
+	Filter string
+	Bytes  uint64
+}
+
+// Usage returns the reservations, largest first, and the total reserved
+func (mb *MemoryBudget) Usage() ([]BudgetEntry, uint64) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	entries := make([]BudgetEntry, 0, len(mb.filters))
+	for name, bytes := range mb.filters {
+		entries = append(entries, BudgetEntry{Filter: name, Bytes: bytes})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].Filter < entries[j].Filter
+	})
+	return entries, mb.used
+}
+
+// MemoryUsage returns the bytes used by the filter's fingerprints
+// (buckets and victim stash)
+func (c *Cuckoo) MemoryUsage() uint64 {
+	return uint64(len(c.slots)) + stashSize*uint64(c.f)
+}
+
+// NewCuckooFilterIn creates a cuckoo filter as NewCuckooFilter, after
+// reserving its memory in the budget under name. Nothing is allocated if the
+// budget refuses it. The caller releases the reservation when the filter is dropped.
+func NewCuckooFilterIn(mb *MemoryBudget, name string, n uint, e float64, opts ...Option) (*Cuckoo, error) {
+	m, f := cuckooParams(n, e)
+	if err := mb.Reserve(name, uint64(m)*uint64(b)*uint64(f)+stashSize*uint64(f)); err != nil {
+		return nil, err
+	}
+	return NewCuckooFilter(n, e, opts...), nil
+}
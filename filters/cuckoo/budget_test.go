@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	mb := NewMemoryBudget(1000)
+	if err := mb.Reserve("ofac", 600); err != nil {
+		t.Fatal(err)
+	}
+	var be *BudgetError
+	if err := mb.Reserve("dust", 500); !errors.As(err, &be) {
+		t.Fatalf("Reserve over the limit = %v", err)
+	}
+	if be.Filter != "dust" || be.Requested != 500 || be.Used != 600 || be.Limit != 1000 {
+		t.Errorf("BudgetError = %+v", be)
+	}
+	// a refused resize keeps the previous reservation
+	if err := mb.Reserve("ofac", 1001); err == nil {
+		t.Error("reservation above the limit accepted")
+	}
+	if err := mb.Reserve("ofac", 1000); err != nil {
+		t.Errorf("resize up to the limit: %v", err)
+	}
+	if err := mb.Reserve("ofac", 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.Reserve("dust", 500); err != nil {
+		t.Fatal(err)
+	}
+	entries, used := mb.Usage()
+	if used != 800 || len(entries) != 2 || entries[0] != (BudgetEntry{"dust", 500}) || entries[1] != (BudgetEntry{"ofac", 300}) {
+		t.Errorf("Usage = %v, %d", entries, used)
+	}
+	mb.Release("dust")
+	mb.Release("unknown")
+	if _, used := mb.Usage(); used != 300 {
+		t.Errorf("%d bytes used after a release", used)
+	}
+}
+
+func TestMemoryBudgetConcurrent(t *testing.T) {
+	mb := NewMemoryBudget(100)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if mb.Reserve(strconv.Itoa(i), 10) == nil {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if _, used := mb.Usage(); granted != 10 || used != 100 {
+		t.Errorf("%d reservations granted, %d bytes used", granted, used)
+	}
+}
+
+func TestNewCuckooFilterIn(t *testing.T) {
+	c := NewCuckooFilter(10000, 0.001)
+	mb := NewMemoryBudget(c.MemoryUsage())
+	got, err := NewCuckooFilterIn(mb, "ofac", 10000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := mb.Usage(); entries[0].Bytes != got.MemoryUsage() {
+		t.Errorf("reserved %d bytes for a filter of %d", entries[0].Bytes, got.MemoryUsage())
+	}
+	if _, err := NewCuckooFilterIn(mb, "sdn", 10, 0.01); err == nil {
+		t.Error("filter built beyond the budget")
+	}
+	if _, err := NewCuckooFilterIn(NewMemoryBudget(1<<20), "huge", 1e9, 0.0001); err == nil {
+		t.Error("filter of a billion items built in 1 MiB")
+	}
+}
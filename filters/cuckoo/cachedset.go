@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Caching remote exact sets.
+// Confirming a filter hit against a remote list (a database, a vendor API)
+// costs a round trip, and hits come in bursts: the same deposit address is
+// screened by every service that sees the transaction. CachedSet wraps such
+// an ExactSet: concurrent lookups of one item share a single remote call
+// (singleflight), and answers are reused for a short TTL. Errors are never
+// cached, so callers failing closed retry the remote on the next lookup.
+// The TTL bounds how long a list change stays unseen: keep it short.
+// If the remote set panics, the panic goes up to the caller that made the call,
+// and the lookups waiting for it fail with ErrRemotePanic.
+
+// ErrRemotePanic is returned to the lookups that shared a remote call whose
+// exact set panicked
+var ErrRemotePanic = errors.New("exact set lookup panicked")
+
+// CachedSet is an ExactSet caching the answers of another.
+// It is safe for concurrent use.
+type CachedSet struct {
+	exact ExactSet
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu       sync.Mutex
+	cache    map[string]cachedAnswer
+	inflight map[string]*remoteCall
+	hits     uint64 // lookups answered from the cache
+	shared   uint64 // lookups that waited for another's remote call
+	misses   uint64 // remote calls
+}
+
+type cachedAnswer struct {
+	ok      bool
+	expires time.Time
+}
+
+// remoteCall is a remote lookup in progress
+type remoteCall struct {
+	done chan struct{}
+	ok   bool
+	err  error
+}
+
+// NewCachedSet returns exact with answers cached for ttl
+func NewCachedSet(exact ExactSet, ttl time.Duration) *CachedSet {
+	return &CachedSet{
+		exact:    exact,
+		ttl:      ttl,
+		now:      time.Now,
+		cache:    make(map[string]cachedAnswer),
+		inflight: make(map[string]*remoteCall),
+	}
+}
+
+// Contains answers from the cache, or joins or makes the remote call
+func (s *CachedSet) Contains(item string) (bool, error) {
+	s.mu.Lock()
+	now := s.now()
+	if a, ok := s.cache[item]; ok {
+		if now.Before(a.expires) {
+			s.hits++
+			s.mu.Unlock()
+			return a.ok, nil
+		}
+		delete(s.cache, item)
+	}
+	if call, ok := s.inflight[item]; ok {
+		s.shared++
+		s.mu.Unlock()
+		<-call.done
+		return call.ok, call.err
+	}
+	call := &remoteCall{done: make(chan struct{})}
+	s.inflight[item] = call
+	s.misses++
+	s.mu.Unlock()
+
+	s.remote(item, call)
+	return call.ok, call.err
+}
+
+// remote makes the remote call, then caches its answer and releases the
+// lookups waiting for it, even if the exact set panics
+func (s *CachedSet) remote(item string, call *remoteCall) {
+	call.err = ErrRemotePanic // replaced unless Contains panics
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, item)
+		if call.err == nil {
+			s.cache[item] = cachedAnswer{ok: call.ok, expires: s.now().Add(s.ttl)}
+		}
+		s.mu.Unlock()
+		close(call.done)
+	}()
+	call.ok, call.err = s.exact.Contains(item)
+}
+
+// Purge drops the expired answers. Expired answers are also dropped when
+// looked up; call Purge periodically to bound the memory of items that are
+// not looked up again.
+func (s *CachedSet) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for item, a := range s.cache {
+		if !now.Before(a.expires) {
+			delete(s.cache, item)
+		}
+	}
+}
+
+// Stats returns the lookups answered from the cache, those that shared
+// another's remote call, and the remote calls made
+func (s *CachedSet) Stats() (hits, shared, misses uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits, s.shared, s.misses
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSet answers once released, and counts its calls
+type blockingSet struct {
+	ExactSet
+	mu      sync.Mutex
+	calls   int
+	release chan struct{}
+	panics  bool
+}
+
+func (s *blockingSet) Contains(item string) (bool, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	if s.release != nil {
+		<-s.release
+	}
+	if s.panics {
+		panic("backend bug")
+	}
+	return s.ExactSet.Contains(item)
+}
+
+func TestCachedSet(t *testing.T) {
+	clock := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	remote := &blockingSet{ExactSet: MapSet{"bc1qsanctioned": {}}}
+	s := NewCachedSet(remote, time.Minute)
+	s.now = func() time.Time { return clock }
+
+	for i := 0; i < 3; i++ {
+		if ok, err := s.Contains("bc1qsanctioned"); !ok || err != nil {
+			t.Fatalf("Contains = %v, %v", ok, err)
+		}
+		if ok, err := s.Contains("bc1qclean"); ok || err != nil {
+			t.Fatalf("Contains = %v, %v", ok, err)
+		}
+	}
+	if hits, shared, misses := s.Stats(); hits != 4 || shared != 0 || misses != 2 || remote.calls != 2 {
+		t.Errorf("Stats = %d, %d, %d with %d remote calls", hits, shared, misses, remote.calls)
+	}
+
+	// expired answers are asked again, or purged
+	clock = clock.Add(time.Minute)
+	s.Contains("bc1qsanctioned")
+	if remote.calls != 3 {
+		t.Errorf("%d remote calls, want an expired answer asked again", remote.calls)
+	}
+	clock = clock.Add(time.Minute)
+	s.Purge()
+	if len(s.cache) != 0 {
+		t.Errorf("%d answers left after a purge", len(s.cache))
+	}
+
+	// errors are not cached
+	failing := NewCachedSet(failingSet{}, time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := failing.Contains("a"); !errors.Is(err, errUnreachable) {
+			t.Errorf("Contains = %v", err)
+		}
+	}
+	if _, _, misses := failing.Stats(); misses != 2 {
+		t.Errorf("%d remote calls for two lookups of a failing set", misses)
+	}
+}
+
+func TestCachedSetCoalesces(t *testing.T) {
+	remote := &blockingSet{ExactSet: MapSet{"a": {}}, release: make(chan struct{})}
+	s := NewCachedSet(remote, time.Minute)
+	const lookups = 10
+	var wg sync.WaitGroup
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := s.Contains("a"); !ok || err != nil {
+				t.Errorf("Contains = %v, %v", ok, err)
+			}
+		}()
+	}
+	// wait for every lookup to be in flight or waiting for it
+	for {
+		if _, shared, misses := s.Stats(); shared+misses == lookups {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(remote.release)
+	wg.Wait()
+	if _, _, misses := s.Stats(); misses != 1 || remote.calls != 1 {
+		t.Errorf("%d remote calls for %d concurrent lookups", remote.calls, lookups)
+	}
+}
+
+func TestCachedSetPanic(t *testing.T) {
+	remote := &blockingSet{ExactSet: MapSet{}, release: make(chan struct{}), panics: true}
+	s := NewCachedSet(remote, time.Minute)
+
+	panicked := make(chan any, 1)
+	go func() {
+		defer func() { panicked <- recover() }()
+		s.Contains("a")
+	}()
+	for {
+		if _, _, misses := s.Stats(); misses == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := s.Contains("a")
+		waiter <- err
+	}()
+	for {
+		if _, shared, _ := s.Stats(); shared == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(remote.release)
+
+	if r := <-panicked; r == nil {
+		t.Error("panic of the exact set not propagated to its caller")
+	}
+	select {
+	case err := <-waiter:
+		if !errors.Is(err, ErrRemotePanic) {
+			t.Errorf("waiting lookup = %v, want ErrRemotePanic", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiting lookup hangs after a panic")
+	}
+	// the next lookup makes a new call
+	remote.panics = false
+	if _, err := s.Contains("a"); err != nil {
+		t.Errorf("lookup after a panic: %v", err)
+	}
+}
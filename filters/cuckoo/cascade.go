@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Filter cascade based on CRLite
+// (https://obj.umiacs.umd.edu/papers_for_stories/crlite_oakland17.pdf,
+// J. Larisch et al., "CRLite: A Scalable System for Pushing All TLS Revocations to All Browsers").
+//
+// A filter always has false positives, which is unacceptable for the
+// allowlist: a false positive is an incorrectly permitted withdrawal.
+// When the whole universe of items that can be queried is known (every
+// address the wallet may pay: the allowed ones R and the others S), a cascade
+// of filters answers exactly for all of them:
+//   - level 1 holds R; the items of S it matches are its false positives
+//   - level 2 holds those false positives; the items of R it matches are its
+//     false positives
+//   - and so on, alternating, until a level has no false positive
+//
+// An item is in R if the number of consecutive levels it matches, starting
+// from level 1, is odd. Items outside R and S get the usual false positive
+// rate of level 1.
+// Each level hashes its items with a different salt, so the false positives
+// of one level are independent of the previous ones.
+
+// maxCascadeLevels stops the construction if levels keep having false positives
+const maxCascadeLevels = 64
+
+// Cascade is an exact membership structure over a known universe
+type Cascade struct {
+	levels []*BlockedBloom
+}
+
+var _ Lookuper = (*Cascade)(nil)
+
+// NewCascade builds a cascade encoding included exactly against excluded.
+// e is the false positive rate of the first level; deeper levels use 0.5,
+// which minimizes the total size (CRLite, section 4).
+// It returns an error if an item is in both sets.
+func NewCascade(included, excluded []string, e float64) (*Cascade, error) {
+	in := make(map[string]struct{}, len(included))
+	for _, item := range included {
+		in[item] = struct{}{}
+	}
+	for _, item := range excluded {
+		if _, ok := in[item]; ok {
+			return nil, fmt.Errorf("item %q is both included and excluded", item)
+		}
+	}
+
+	c := &Cascade{}
+	insert, check := included, excluded
+	for len(insert) > 0 {
+		if len(c.levels) == maxCascadeLevels {
+			return nil, fmt.Errorf("cascade does not converge after %d levels", maxCascadeLevels)
+		}
+		level := len(c.levels)
+		rate := e
+		if level > 0 {
+			rate = 0.5
+		}
+
+		bf := NewBlockedBloomFilter(uint(math.Max(float64(len(insert)), 1)), rate)
+		for _, item := range insert {
+			bf.insert(cascadeKey(level, item))
+		}
+		c.levels = append(c.levels, bf)
+
+		// the false positives of this level are inserted in the next one,
+		// and checked against the items of this level
+		var fps []string
+		for _, item := range check {
+			if bf.lookup(cascadeKey(level, item)) {
+				fps = append(fps, item)
+			}
+		}
+		insert, check = fps, insert
+	}
+	return c, nil
+}
+
+// cascadeKey salts an item with the level, so every level hashes it differently
+func cascadeKey(level int, item string) string {
+	return strconv.Itoa(level) + ":" + item
+}
+
+// lookup returns true if the item is in the included set.
+// The answer is exact for every included and excluded item.
+func (c *Cascade) lookup(item string) bool {
+	matched := 0
+	for level, bf := range c.levels {
+		if !bf.lookup(cascadeKey(level, item)) {
+			break
+		}
+		matched++
+	}
+	return matched%2 == 1
+}
+
+// Levels returns the number of filters of the cascade
+func (c *Cascade) Levels() int {
+	return len(c.levels)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCascadeExact(t *testing.T) {
+	var included, excluded []string
+	for i := 0; i < 2000; i++ {
+		included = append(included, "allowed-"+strconv.Itoa(i))
+	}
+	for i := 0; i < 50000; i++ {
+		excluded = append(excluded, "other-"+strconv.Itoa(i))
+	}
+	c, err := NewCascade(included, excluded, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a rate of 0.01 over 50k excluded items leaves false positives to encode
+	if c.Levels() < 2 {
+		t.Errorf("%d levels", c.Levels())
+	}
+	for _, item := range included {
+		if !c.lookup(item) {
+			t.Fatalf("included %s not found", item)
+		}
+	}
+	for _, item := range excluded {
+		if c.lookup(item) {
+			t.Fatalf("excluded %s found", item)
+		}
+	}
+	// outside the universe, the rate of the first level
+	fp := 0
+	for i := 0; i < 100000; i++ {
+		if c.lookup("unknown-" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if fp > 1500 {
+		t.Errorf("%d false positives in 100000 items outside the universe", fp)
+	}
+}
+
+func TestCascadeEdges(t *testing.T) {
+	if _, err := NewCascade([]string{"a", "b"}, []string{"c", "b"}, 0.01); err == nil {
+		t.Error("item both included and excluded accepted")
+	}
+	empty, err := NewCascade(nil, []string{"a"}, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Levels() != 0 || empty.lookup("a") {
+		t.Errorf("cascade of nothing: %d levels", empty.Levels())
+	}
+	// nothing excluded: one level, no false positive to encode
+	one, err := NewCascade([]string{"a"}, nil, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.Levels() != 1 || !one.lookup("a") {
+		t.Errorf("cascade of one item: %d levels", one.Levels())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// Change output verification.
+// A transaction sent to the MPC nodes for co-signing claims some of its
+// outputs as change, going back to the wallet. If a claim is false, the
+// funds of the "change" leave the wallet without being screened or shown as
+// a payment. Each node checks the claims itself: the change scripts of every
+// wallet are derived ahead (up to a lookahead past the last used index) into
+// a filter per wallet, which rejects false claims without any derivation;
+// a hit is confirmed by deriving the script at the claimed index (the
+// derivation path of the output, as carried by a PSBT) and comparing it.
+
+// ChangeDeriver derives the change scripts of the wallets, e.g. from their
+// account xpubs. Derivation needs secp256k1, so the signer provides it.
+type ChangeDeriver interface {
+	// ChangeScript returns the scriptPubKey of the change address at index
+	ChangeScript(walletID string, index uint32) ([]byte, error)
+}
+
+// ChangeClaim is an output a transaction claims as change
+type ChangeClaim struct {
+	Script []byte
+	Index  uint32 // derivation index of the change address
+}
+
+// ChangeVerifier checks change claims against the derived change scripts.
+// It is safe for concurrent use.
+type ChangeVerifier struct {
+	mu      sync.RWMutex
+	deriver ChangeDeriver
+	wallets map[string]*changeFilter
+}
+
+type changeFilter struct {
+	filter  *Cuckoo
+	derived uint32 // change scripts [0, derived) are in the filter
+}
+
+// NewChangeVerifier returns a verifier deriving change scripts with deriver
+func NewChangeVerifier(deriver ChangeDeriver) *ChangeVerifier {
+	return &ChangeVerifier{deriver: deriver, wallets: make(map[string]*changeFilter)}
+}
+
+// Derive adds the change scripts of the wallet up to index upto (excluded)
+// to its filter. capacity sizes the filter of a new wallet; derive with a
+// lookahead beyond the last used index, as for gap limits.
+func (v *ChangeVerifier) Derive(walletID string, upto uint32, capacity uint) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	w, ok := v.wallets[walletID]
+	if !ok {
+		w = &changeFilter{filter: NewCuckooFilter(capacity, 0.0001)}
+		v.wallets[walletID] = w
+	}
+	for ; w.derived < upto; w.derived++ {
+		script, err := v.deriver.ChangeScript(walletID, w.derived)
+		if err != nil {
+			return fmt.Errorf("wallet %s change %d: %w", walletID, w.derived, err)
+		}
+		if err := w.filter.insert(string(script)); err != nil {
+			return fmt.Errorf("wallet %s change %d: %w", walletID, w.derived, err)
+		}
+	}
+	return nil
+}
+
+// IsOurChange returns true if the script is the change script of the wallet
+// at the claimed index. Unknown wallets and scripts missed by the filter are
+// rejected without derivation; filter hits are confirmed by derivation.
+func (v *ChangeVerifier) IsOurChange(walletID string, claim ChangeClaim) (bool, error) {
+	v.mu.RLock()
+	w, ok := v.wallets[walletID]
+	hit := ok && claim.Index < w.derived && w.filter.lookup(string(claim.Script))
+	v.mu.RUnlock()
+	if !hit {
+		return false, nil
+	}
+
+	script, err := v.deriver.ChangeScript(walletID, claim.Index)
+	if err != nil {
+		return false, fmt.Errorf("wallet %s change %d: %w", walletID, claim.Index, err)
+	}
+	return bytes.Equal(script, claim.Script), nil
+}
+
+// Vars returns the policy variables of the change claims of a transaction
+// (see Policy.Decide): falseChange is the number of claims that are not the
+// wallet's change, e.g.
+//
+//	block if falseChange > 0
+//
+// A derivation error is returned rather than counting the claim either way.
+func (v *ChangeVerifier) Vars(walletID string, claims []ChangeClaim) (map[string]float64, error) {
+	var falseChange float64
+	for _, c := range claims {
+		ok, err := v.IsOurChange(walletID, c)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			falseChange++
+		}
+	}
+	return map[string]float64{"falseChange": falseChange}, nil
+}
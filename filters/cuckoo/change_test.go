@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// testDeriver derives fake change scripts and counts the derivations
+type testDeriver struct {
+	mu    sync.Mutex
+	calls int
+	fail  bool
+}
+
+var errDerivation = errors.New("derivation failed")
+
+func (d *testDeriver) ChangeScript(walletID string, index uint32) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	if d.fail {
+		return nil, errDerivation
+	}
+	return []byte(fmt.Sprintf("%s/1/%d", walletID, index)), nil
+}
+
+func TestChangeVerifier(t *testing.T) {
+	d := &testDeriver{}
+	v := NewChangeVerifier(d)
+	if err := v.Derive("w1", 20, 100); err != nil {
+		t.Fatal(err)
+	}
+	// deriving again only adds the new indices
+	if err := v.Derive("w1", 30, 100); err != nil || d.calls != 30 {
+		t.Fatalf("Derive = %v after %d derivations", err, d.calls)
+	}
+
+	d.calls = 0
+	for _, tc := range []struct {
+		name   string
+		wallet string
+		claim  ChangeClaim
+		want   bool
+		derive bool // a filter hit, confirmed by derivation
+	}{
+		{"change", "w1", ChangeClaim{Script: []byte("w1/1/7"), Index: 7}, true, true},
+		{"wrong index", "w1", ChangeClaim{Script: []byte("w1/1/7"), Index: 8}, false, true},
+		{"not derived yet", "w1", ChangeClaim{Script: []byte("w1/1/40"), Index: 40}, false, false},
+		{"payment", "w1", ChangeClaim{Script: []byte("attacker"), Index: 3}, false, false},
+		{"unknown wallet", "w2", ChangeClaim{Script: []byte("w2/1/1"), Index: 1}, false, false},
+	} {
+		before := d.calls
+		got, err := v.IsOurChange(tc.wallet, tc.claim)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: IsOurChange = %v, %v", tc.name, got, err)
+		}
+		if derived := d.calls > before; derived != tc.derive {
+			t.Errorf("%s: derived %v", tc.name, derived)
+		}
+	}
+
+	vars, err := v.Vars("w1", []ChangeClaim{{Script: []byte("w1/1/0"), Index: 0}, {Script: []byte("attacker"), Index: 1}})
+	if err != nil || vars["falseChange"] != 1 {
+		t.Errorf("Vars = %v, %v", vars, err)
+	}
+
+	d.fail = true
+	if _, err := v.IsOurChange("w1", ChangeClaim{Script: []byte("w1/1/7"), Index: 7}); !errors.Is(err, errDerivation) {
+		t.Errorf("IsOurChange with a failing deriver = %v", err)
+	}
+	if _, err := v.Vars("w1", []ChangeClaim{{Script: []byte("w1/1/7"), Index: 7}}); !errors.Is(err, errDerivation) {
+		t.Errorf("Vars with a failing deriver = %v", err)
+	}
+	if err := v.Derive("w1", 31, 100); !errors.Is(err, errDerivation) {
+		t.Errorf("Derive with a failing deriver = %v", err)
+	}
+}
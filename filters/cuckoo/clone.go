@@ -0,0 +1,27 @@
+package main
+
+// Clone returns an independent deep copy of the filter: its buckets, victim
+// stash, counters and settings. Modifying one filter never affects the other,
+// so a clone can snapshot the state before a risky bulk mutation (and be
+// swapped back in if it fails) or seed a shadow filter (see Screener.SetShadow).
+func (c *Cuckoo) Clone() *Cuckoo {
+	clone := *c
+
+	// the buckets are a single slab, copied in one go
+	clone.allocSlots(uint(len(c.slots)))
+	copy(clone.slots, c.slots)
+
+	// stashed fingerprints are few, copy them into a single allocation
+	storage := make([]byte, 0, len(c.victims)*int(c.f))
+	clone.victims = make([]stashEntry, len(c.victims))
+	for k, e := range c.victims {
+		storage = append(storage, e.f...)
+		f := storage[len(storage)-len(e.f) : len(storage) : len(storage)]
+		clone.victims[k] = stashEntry{i: e.i, f: f}
+	}
+
+	if c.latency != nil {
+		clone.latency = c.latency.clone()
+	}
+	return &clone
+}
@@ -0,0 +1,87 @@
+package main
+
+import "fmt"
+
+// Coin selection taint pre-filter.
+// Screening at withdrawal time checks where funds go; the coins the wallet
+// spends also carry where they came from. Spending an output funded by a
+// sanctioned address mixes it with clean funds, and taints the recipient.
+// FilterSpendableUTXOs screens the origin addresses of every output before
+// coin selection and applies the policy to each: outputs whose decision is
+// "block" are dropped, outputs with another decision than "allow" (e.g.,
+// "review") are down-ranked, so coin selection only uses them when the clean
+// outputs do not cover the amount.
+
+// UTXO is a spendable output of the wallet
+type UTXO struct {
+	Outpoint Outpoint
+	Value    uint64
+	Origins  []string // addresses the output was funded from
+}
+
+// TaintedUTXO is an output dropped or down-ranked by the taint filter
+type TaintedUTXO struct {
+	UTXO    UTXO
+	Action  string // decision of the policy
+	Origin  string // origin address that caused it
+	Verdict Verdict
+}
+
+// BlockAction is the policy decision that drops an output from coin selection
+const BlockAction = "block"
+
+// TaintFilter screens the origins of outputs before coin selection
+type TaintFilter struct {
+	screener *Screener
+	policy   *Policy
+}
+
+// NewTaintFilter returns a filter deciding with policy on the verdicts of screener
+func NewTaintFilter(screener *Screener, policy *Policy) *TaintFilter {
+	return &TaintFilter{screener: screener, policy: policy}
+}
+
+// FilterSpendableUTXOs returns the outputs coin selection may spend, the clean
+// ones first and then the down-ranked ones, each group in the input order,
+// along with the outputs that were dropped or down-ranked and why.
+// An output takes the most severe decision of its origins: block, then the
+// first other decision than allow. A screening error of an origin (see
+// Verdict.Err) or an error of the policy is returned rather than letting an
+// output through.
+func (t *TaintFilter) FilterSpendableUTXOs(utxos []UTXO) ([]UTXO, []TaintedUTXO, error) {
+	var clean, downRanked []UTXO
+	var tainted []TaintedUTXO
+	for _, u := range utxos {
+		var worst *TaintedUTXO
+		for _, origin := range u.Origins {
+			v := t.screener.Check(origin)
+			if err := v.Err(); err != nil {
+				return nil, nil, fmt.Errorf("utxo origin %s: %w", origin, err)
+			}
+			action, err := t.policy.Decide(v, nil)
+			if err != nil {
+				return nil, nil, fmt.Errorf("utxo origin %s: %w", origin, err)
+			}
+			if action == DefaultAction {
+				continue
+			}
+			if worst == nil || (action == BlockAction && worst.Action != BlockAction) {
+				worst = &TaintedUTXO{UTXO: u, Action: action, Origin: origin, Verdict: v}
+			}
+			if action == BlockAction {
+				break
+			}
+		}
+
+		switch {
+		case worst == nil:
+			clean = append(clean, u)
+		case worst.Action == BlockAction:
+			tainted = append(tainted, *worst)
+		default:
+			downRanked = append(downRanked, u)
+			tainted = append(tainted, *worst)
+		}
+	}
+	return append(clean, downRanked...), tainted, nil
+}
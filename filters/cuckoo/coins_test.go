@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func taintFilter(t *testing.T, exact ExactSet) *TaintFilter {
+	t.Helper()
+	f := NewCuckooFilter(100, 0.01)
+	f.insert("sanctioned-addr")
+	s := NewScreener()
+	s.Add("sanctioned", f, 0.01, exact)
+	p, err := ParsePolicy("block if sanctioned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTaintFilter(s, p)
+}
+
+func TestFilterSpendableUTXOs(t *testing.T) {
+	tf := taintFilter(t, MapSet{"sanctioned-addr": {}})
+	clean := UTXO{Value: 1, Origins: []string{"clean-addr"}}
+	dirty := UTXO{Value: 2, Origins: []string{"clean-addr", "sanctioned-addr"}}
+	spendable, tainted, err := tf.FilterSpendableUTXOs([]UTXO{dirty, clean})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spendable) != 1 || spendable[0].Value != 1 {
+		t.Errorf("spendable %v, want the clean output", spendable)
+	}
+	if len(tainted) != 1 || tainted[0].Action != BlockAction || tainted[0].Origin != "sanctioned-addr" {
+		t.Errorf("tainted %v, want the dirty output blocked", tainted)
+	}
+}
+
+func TestFilterSpendableUTXOsScreeningError(t *testing.T) {
+	tf := taintFilter(t, failingSet{})
+	spendable, _, err := tf.FilterSpendableUTXOs([]UTXO{{Value: 2, Origins: []string{"sanctioned-addr"}}})
+	if !errors.Is(err, errUnreachable) {
+		t.Errorf("got %v, want the exact set error", err)
+	}
+	if len(spendable) != 0 {
+		t.Errorf("spendable %v despite the screening error", spendable)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+)
+
+// ParamMismatchError describes the first parameter that differs between two
+// cuckoo filters, or two blocked Bloom filters (see BlockedBloom.Compatible). Merge, diff and replication code paths can only combine
+// filters whose bucket layout is identical, so they check Compatible first
+// and report this error to the caller.
+type ParamMismatchError struct {
+	Param string // name of the mismatching parameter (m, b, f, k, hash, seed or key)
+	Got   uint   // value in the filter Compatible was called on (unset for key)
+	Want  uint   // value in the other filter (unset for key)
+}
+
+func (e *ParamMismatchError) Error() string {
+	if e.Param == "key" {
+		// keys are secret, never print them
+		return "incompatible filters: key mismatch"
+	}
+	return fmt.Sprintf("incompatible filters: %s mismatch (got %d, want %d)", e.Param, e.Got, e.Want)
+}
+
+// Compatible checks that two filters share the same layout:
+// - m: number of buckets
+// - b: number of entries per bucket
+// - f: fingerprint length
+// - key: the fingerprint key set with WithKey, if any
+// Two compatible filters map every item to the same buckets and fingerprint,
+// so their contents can be compared or combined bucket by bucket.
+// It returns nil when the filters are compatible, or a *ParamMismatchError
+// describing the first parameter that differs.
+func (c *Cuckoo) Compatible(other *Cuckoo) error {
+	if c.m != other.m {
+		return &ParamMismatchError{Param: "m", Got: c.m, Want: other.m}
+	}
+	if c.b != other.b {
+		return &ParamMismatchError{Param: "b", Got: c.b, Want: other.b}
+	}
+	if c.f != other.f {
+		return &ParamMismatchError{Param: "f", Got: c.f, Want: other.f}
+	}
+	if c.hashFamily() != other.hashFamily() {
+		return &ParamMismatchError{Param: "hash", Got: uint(c.hashFamily().ID), Want: uint(other.hashFamily().ID)}
+	}
+	if c.seed != other.seed {
+		return &ParamMismatchError{Param: "seed", Got: uint(c.seed), Want: uint(other.seed)}
+	}
+	if (c.key == nil) != (other.key == nil) || !hmac.Equal(c.key, other.key) {
+		return &ParamMismatchError{Param: "key"}
+	}
+	return nil
+}
+
+// Equal reports whether two filters are compatible and hold exactly the same
+// fingerprints in the same slots (byte-level comparison of every bucket
+// and of the victim stash).
+func (c *Cuckoo) Equal(other *Cuckoo) bool {
+	if c.Compatible(other) != nil {
+		return false
+	}
+	// compatible filters have slabs of the same size, laid out the same way
+	if !bytes.Equal(c.slots, other.slots) {
+		return false
+	}
+	if len(c.victims) != len(other.victims) {
+		return false
+	}
+	for k, e := range c.victims {
+		if e.i != other.victims[k].i || !bytes.Equal(e.f, other.victims[k].f) {
+			return false
+		}
+	}
+	return true
+}
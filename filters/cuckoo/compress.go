@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Snapshot compression.
+// The buckets of a lightly loaded filter are mostly empty (zero) entries,
+// which compress to almost nothing; the fingerprints themselves are random
+// and do not compress. A compressed snapshot is
+//
+//	magic  4 bytes  "CKZ" + codec id
+//	the snapshot (see snapshot.go), compressed with the codec
+//
+// ReadCuckoo detects the magic and decompresses transparently, so readers
+// take both forms. Peers negotiate the codec in their Hello, and fall back to
+// no compression with peers that do not support one.
+// Only codecs of the standard library are available: flate (DEFLATE).
+// A preset dictionary is not used: it only helps with data that repeats
+// across snapshots, which random fingerprints never do.
+
+// Snapshot codecs, in order of preference
+const (
+	CodecFlate = "flate"
+	CodecNone  = "none"
+)
+
+// SnapshotCodecs lists the supported codecs, preferred first
+var SnapshotCodecs = []string{CodecFlate, CodecNone}
+
+const compressedMagic = "CKZ"
+
+// codecIDs are the codec ids of the magic
+var codecIDs = map[string]byte{CodecFlate: 1}
+
+// NegotiateCodec returns the preferred codec among those a peer reads,
+// or CodecNone
+func NegotiateCodec(peer []string) string {
+	for _, codec := range SnapshotCodecs {
+		for _, p := range peer {
+			if p == codec {
+				return codec
+			}
+		}
+	}
+	return CodecNone
+}
+
+// WriteCompressed writes the filter in the given snapshot version,
+// compressed with codec
+func (c *Cuckoo) WriteCompressed(w io.Writer, version uint32, codec string) (int64, error) {
+	if codec == CodecNone {
+		return c.WriteVersion(w, version)
+	}
+	id, ok := codecIDs[codec]
+	if !ok {
+		return 0, fmt.Errorf("unknown snapshot codec %q", codec)
+	}
+
+	cw := &countingWriter{w: w}
+	cw.Write(append([]byte(compressedMagic), id))
+	zw, err := flate.NewWriter(cw, flate.DefaultCompression)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.WriteVersion(zw, version); err != nil {
+		return cw.n, err
+	}
+	if err := zw.Close(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+// decompress returns the reader of the snapshot in br: br itself, or a
+// decompressing reader if the snapshot is compressed
+func decompress(br *bufio.Reader) (io.Reader, error) {
+	magic, err := br.Peek(len(compressedMagic) + 1)
+	if err != nil || string(magic[:len(compressedMagic)]) != compressedMagic {
+		// not compressed; a short input is reported by the snapshot decoder
+		return br, nil
+	}
+	switch magic[len(compressedMagic)] {
+	case codecIDs[CodecFlate]:
+		br.Discard(len(magic))
+		return bufio.NewReader(flate.NewReader(br)), nil
+	}
+	return nil, &ErrCorruptSnapshot{Offset: int64(len(compressedMagic)), Reason: fmt.Sprintf("unknown codec id %d", magic[len(compressedMagic)])}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "crypto/subtle"
+
+// WithConstantTimeLookup makes lookups take the same time whether the item
+// is found or not, and wherever its fingerprint is stored.
+// By default a lookup returns at the first matching slot and compares
+// fingerprints with bytes.Equal, which stops at the first differing byte.
+// In a service co-located with the signer, that timing difference tells an
+// observer whether the address about to be paid is on a watch-list.
+// In constant-time mode every slot of both buckets and every stash entry is
+// compared, fingerprints are compared with crypto/subtle, and the results are
+// combined without branching. Inserts and deletes are not affected.
+// It is about twice as slow as a regular lookup of an item in its first bucket.
+func WithConstantTimeLookup() Option {
+	return func(c *Cuckoo) {
+		c.constantTime = true
+	}
+}
+
+// containsConstantTime is contains without data-dependent branches
+// (see WithConstantTimeLookup). Empty entries are all zeros, a fingerprint
+// that is never stored (see nonZero), so they are compared like the others.
+func (c *Cuckoo) containsConstantTime(i1, i2 uint, f fingerprint) bool {
+	found := 0
+	for j := uint(0); j < c.b; j++ {
+		found |= subtle.ConstantTimeCompare(c.entry(i1, j), f)
+		found |= subtle.ConstantTimeCompare(c.entry(i2, j), f)
+	}
+	for _, e := range c.victims {
+		sameBucket := equalIndex(e.i, i1) | equalIndex(e.i, i2)
+		found |= sameBucket & subtle.ConstantTimeCompare(e.f, f)
+	}
+	return found == 1
+}
+
+// equalIndex returns 1 if a == b and 0 otherwise, without branching
+func equalIndex(a, b uint) int {
+	x := uint64(a ^ b)
+	// x | -x has its top bit set for any x != 0
+	return int(((x | -x) >> 63) ^ 1)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestConstantTimeLookup checks that constant-time lookups answer as the
+// regular ones, for items in their buckets, in the victim stash, deleted or
+// never inserted
+func TestConstantTimeLookup(t *testing.T) {
+	c := NewCuckooFilter(200, 0.1, WithConstantTimeLookup())
+	var inserted []string
+	for i := 0; len(c.victims) == 0; i++ {
+		item := strconv.Itoa(i)
+		if err := c.insert(item); err != nil {
+			break
+		}
+		inserted = append(inserted, item)
+	}
+	if len(c.victims) == 0 {
+		t.Fatal("no fingerprint in the victim stash")
+	}
+	items := append([]string(nil), inserted...)
+	for i := 0; i < 10000; i++ {
+		items = append(items, "other-"+strconv.Itoa(i))
+	}
+	compare := func() {
+		t.Helper()
+		for _, item := range items {
+			c.constantTime = true
+			got := c.lookup(item)
+			c.constantTime = false
+			if want := c.lookup(item); got != want {
+				t.Fatalf("lookup(%s) = %v in constant time, %v otherwise", item, got, want)
+			}
+		}
+	}
+	compare()
+	for _, item := range inserted[:len(inserted)/4] {
+		c.delete(item)
+	}
+	compare()
+}
+
+func TestEqualIndex(t *testing.T) {
+	for _, tc := range []struct {
+		a, b uint
+		want int
+	}{{0, 0, 1}, {7, 7, 1}, {0, 1, 0}, {1 << 63, 0, 0}, {^uint(0), ^uint(0), 1}, {^uint(0), 0, 0}} {
+		if got := equalIndex(tc.a, tc.b); got != tc.want {
+			t.Errorf("equalIndex(%d, %d) = %d", tc.a, tc.b, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Count-min sketch
+// (http://dimacs.rutgers.edu/~graham/pubs/papers/cm-full.pdf,
+// G. Cormode, S. Muthukrishnan, "An Improved Data Stream Summary: The Count-Min Sketch and its Applications").
+//
+// A sketch counts the occurrences of items in depth rows of width counters:
+// an item adds to one counter per row, and its count is the minimum of its
+// counters. Collisions only add, so a count is never below the true count,
+// and exceeds it by at most eps * (total of all counts) with probability
+// 1 - delta, for width = e/eps and depth = ln(1/delta).
+// The memory does not depend on the number of distinct items, so a sketch
+// can count per-address events over addresses it was never told about.
+// The counters of the rows come from two 64-bit values with double hashing,
+// as in the blocked Bloom filter.
+
+// CountMinSketch counts item occurrences, or sums item values, approximately
+type CountMinSketch struct {
+	width  uint
+	depth  uint
+	counts []uint64 // depth rows of width counters
+}
+
+// NewCountMinSketch returns a sketch overestimating counts by at most
+// eps * (total count) with probability 1 - delta
+func NewCountMinSketch(eps, delta float64) *CountMinSketch {
+	width, depth := sketchSize(eps, delta)
+	return &CountMinSketch{width: width, depth: depth, counts: make([]uint64, width*depth)}
+}
+
+// sketchSize returns the width and depth of a sketch for eps and delta
+func sketchSize(eps, delta float64) (width, depth uint) {
+	width = uint(math.Ceil(math.E / eps))
+	depth = uint(math.Ceil(math.Log(1 / delta)))
+	return width, max(depth, 1)
+}
+
+// cells calls fn with the index of the counter of the item in every row
+func (s *CountMinSketch) cells(item string, fn func(i uint)) {
+	sketchCells(item, s.width, s.depth, fn)
+}
+
+// sketchCells calls fn with the index of the counter of the item in every
+// row of a sketch of depth rows of width counters
+func sketchCells(item string, width, depth uint, fn func(i uint)) {
+	h := hash([]byte(item))
+	h1 := binary.BigEndian.Uint64(h[0:8])
+	h2 := binary.BigEndian.Uint64(h[8:16])
+	for row := uint(0); row < depth; row++ {
+		fn(row*width + uint((h1+uint64(row)*h2)%uint64(width)))
+	}
+}
+
+// Add adds n occurrences (or n units of value) of the item and returns its
+// new count. Counters saturate at the maximum uint64 instead of wrapping.
+func (s *CountMinSketch) Add(item string, n uint64) uint64 {
+	count := uint64(math.MaxUint64)
+	s.cells(item, func(i uint) {
+		s.counts[i] = satAdd(s.counts[i], n)
+		count = min(count, s.counts[i])
+	})
+	return count
+}
+
+// subtract takes back n occurrences of the item added before. A saturated
+// counter stays saturated: it no longer knows what was added to it.
+func (s *CountMinSketch) subtract(item string, n uint64) {
+	s.cells(item, func(i uint) {
+		if s.counts[i] != math.MaxUint64 {
+			s.counts[i] -= min(n, s.counts[i])
+		}
+	})
+}
+
+// satAdd returns a + b, or the maximum uint64 if the sum overflows
+func satAdd(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+// Count returns the count of the item, never below its true count
+func (s *CountMinSketch) Count(item string) uint64 {
+	count := uint64(math.MaxUint64)
+	s.cells(item, func(i uint) {
+		count = min(count, s.counts[i])
+	})
+	return count
+}
+
+// Reset sets every count to zero
+func (s *CountMinSketch) Reset() {
+	clear(s.counts)
+}
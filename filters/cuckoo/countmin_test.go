@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	const eps = 0.001
+	s := NewCountMinSketch(eps, 0.01)
+	if s.width != 2719 || s.depth != 5 {
+		t.Errorf("sketch of %d x %d counters", s.depth, s.width)
+	}
+	// a skewed stream: item i occurs i%100+1 times
+	var total uint64
+	for i := 0; i < 10000; i++ {
+		n := uint64(i%100 + 1)
+		s.Add(strconv.Itoa(i), n)
+		total += n
+	}
+	over := 0
+	for i := 0; i < 10000; i++ {
+		want := uint64(i%100 + 1)
+		got := s.Count(strconv.Itoa(i))
+		if got < want {
+			t.Fatalf("count of %d is %d, below its true count %d", i, got, want)
+		}
+		if float64(got-want) > eps*float64(total) {
+			over++
+		}
+	}
+	// the bound holds with probability 1 - delta
+	if over > 100 {
+		t.Errorf("%d counts over the error bound", over)
+	}
+
+	s.subtract("7", 8)
+	if got := s.Count("7"); got > uint64(eps*float64(total)) {
+		t.Errorf("count of 7 after subtracting it: %d", got)
+	}
+	s.Reset()
+	if s.Count("1") != 0 {
+		t.Error("count after Reset")
+	}
+}
+
+func TestCountMinSaturation(t *testing.T) {
+	s := NewCountMinSketch(0.1, 0.1)
+	s.Add("a", math.MaxUint64-1)
+	if got := s.Add("a", 5); got != math.MaxUint64 {
+		t.Errorf("overflowing count = %d", got)
+	}
+	s.subtract("a", 5)
+	if s.Count("a") != math.MaxUint64 {
+		t.Error("saturated counter changed by subtract")
+	}
+	if satAdd(1, 2) != 3 || satAdd(math.MaxUint64, 1) != math.MaxUint64 {
+		t.Error("satAdd")
+	}
+}
@@ -6,20 +6,18 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
-	"errors"
+	"flag"
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"time"
 )
 
 // Define the types
 type fingerprint []byte
-type bucket []fingerprint
-
-var hasher = sha1.New()
 
 // how many times do we try to move items around during insertion
 const retries = 500
@@ -37,11 +35,30 @@ var b uint = 4 // number of entries or fingerprints per bucket
 
 // Cuckoo Data structure based on https://www.pdl.cmu.edu/PDL-FTP/FS/cuckoo-conext2014.pdf
 type Cuckoo struct {
-	buckets []bucket
-	m       uint // number of buckets
-	b       uint // number of entries per bucket in bits
-	f       uint // fingerprint length in bits
-	n       uint // number of items - filter capacity
+	slots []byte // m buckets of b fingerprints of f bytes, see slab.go
+	m     uint   // number of buckets
+	mask  uint   // m - 1, used to reduce hashes to bucket indices
+	b     uint   // number of entries per bucket in bits
+	f     uint   // fingerprint length in bits
+	n     uint   // number of items - filter capacity
+
+	count   uint    // number of stored fingerprints, including the victim stash
+	maxLoad float64 // maximum load factor accepted by insert, 0 for no limit
+
+	allowEmpty bool // accept the empty key (WithEmptyKeys)
+	idempotent bool // do not store an item twice (WithIdempotentInsert)
+
+	constantTime bool        // lookups without timing side channels (WithConstantTimeLookup)
+	key          []byte      // HMAC key of the fingerprints, nil for plain SHA1 (WithKey)
+	family       *HashFamily // hash of plain filters, nil for SHA1 (WithHashFamily)
+	seed         uint64      // hashed before the items, 0 for none
+	offHeap      bool        // buckets allocated with mmap (WithOffHeap)
+
+	batchThreshold int // minimum items per batch worker, 0 for the default (WithBatchParallelism)
+	batchWorkers   int // maximum batch workers, 0 for GOMAXPROCS
+
+	latency *latencyStats // per-operation latency histograms, nil unless enabled
+	victims []stashEntry  // victim stash for fingerprints that could not be placed
 }
 
 // fingerprintLength follows the formula f >= log2(2b/r) bits
@@ -92,45 +109,63 @@ func nextPower(i uint) uint {
 	return i
 }
 
-// NewCuckooFilter creates a new cuckoo filter according to the parameters suggested by the authors
-// "because it achieves the best or close-to-best space efficiency for the false positive
-// rates that most practical applications 'A. Broder, M. Mitzenmacher, and A. Broder. Network
-// Applications of Bloom Filters' may be interested in":
-// n: number of items - filter capacity
-// e: false positive rate (e.g., 0.01)
-// returns a pointer to the cuckoo filter
-func NewCuckooFilter(n uint, e float64) *Cuckoo {
-	//b := uint(4) // number of entries or fingerprints per bucket
+// Target occupancy of a filter holding the n items it was sized for.
+// With b = 4 entries per bucket, insertions start failing at ~95% occupancy
+// (https://www.pdl.cmu.edu/PDL-FTP/FS/cuckoo-conext2014.pdf, Table 2), so
+// n items are given enough slots to stay below that with some margin.
+// Rounding m up to a power of two can lower the occupancy down to ~45%.
+const targetLoad = 0.9
+
+// cuckooParams returns the number of buckets (m) and the fingerprint length (f)
+// used by NewCuckooFilter for n items and a false positive rate e.
+// n is the number of items expected in the filter, so the filter has at least
+// n / targetLoad slots of b entries, whatever the fingerprint length.
+func cuckooParams(n uint, e float64) (uint, uint) {
 	// following https://www.pdl.cmu.edu/PDL-FTP/FS/cuckoo-conext2014.pdf optimum recommendations
 	f := fingerprintLength(b, e)
 	// following https://www.pdl.cmu.edu/PDL-FTP/FS/cuckoo-conext2014.pdf
-	// to calculate the number of buckets
-	m := nextPower(n / f * b_size)
+	// to calculate the number of buckets: enough buckets of b entries
+	// for n items at the target occupancy
+	m := nextPower(uint(math.Ceil(float64(n) / (float64(b) * targetLoad))))
 
 	// Set a minimum number of buckets
 	// to at least 1 bucket
 	if m == 0 {
 		m = 1
 	}
+	return m, f
+}
 
-	// Make an array of buckets of len m
-	// if m = 4, then buckets = [bucket, bucket, bucket, bucket]
-	buckets := make([]bucket, m)
-
-	// Initialize each bucket within the array of buckets
-	for i := uint(0); i < m; i++ {
-		buckets[i] = make(bucket, b) // make a bucket of len b
+// NewCuckooFilter creates a new cuckoo filter according to the parameters suggested by the authors
+// "because it achieves the best or close-to-best space efficiency for the false positive
+// rates that most practical applications 'A. Broder, M. Mitzenmacher, and A. Broder. Network
+// Applications of Bloom Filters' may be interested in":
+// n: number of items - filter capacity
+// e: false positive rate (e.g., 0.01)
+// opts: optional settings (e.g., WithMaxLoadFactor)
+// returns a pointer to the cuckoo filter
+func NewCuckooFilter(n uint, e float64, opts ...Option) *Cuckoo {
+	//b := uint(4) // number of entries or fingerprints per bucket
+	m, f := cuckooParams(n, e)
+
+	// create the Cuckoo filter with the parameters
+	c := &Cuckoo{
+		m:    m,
+		mask: m - 1,
+		b:    b,
+		f:    f,
+		n:    n,
 	}
 
-	// return the created Cuckoo filter with the parameters
-	return &Cuckoo{
-		buckets: buckets,
-		m:       m,
-		b:       b,
-		f:       f,
-		n:       n,
+	// apply the optional settings
+	for _, opt := range opts {
+		opt(c)
 	}
 
+	// a single zeroed allocation for the m buckets of b entries,
+	// once the options have chosen where it lives
+	c.allocSlots(m * b * f)
+	return c
 }
 
 // The hashes function would have the inputs:
@@ -141,49 +176,82 @@ func NewCuckooFilter(n uint, e float64) *Cuckoo {
 // the function hashes returns h1, h2 and the fingerprint
 func (c *Cuckoo) hashes(data string) (uint, uint, fingerprint) {
 	// Compute the hash of the data string input
-	h := hash([]byte(data))
+	// (keyed with the tenant key, if any)
+	h := c.hashItem([]byte(data))
 
 	// Get the fingerprint of the hash of the data string
 	// using the f value set in the cuckoo filter struct for the fingerprint length in bits
 	// by slicing the hash from 0 to f
 	f := h[0:c.f]
 
-	// Convert a portion of the first hash value to an unsigned integer using BigEndian
-	i1 := uint(binary.BigEndian.Uint32(h))
+	// Convert the last 64 bits of the hash to an unsigned integer using BigEndian
+	// and reduce it to a bucket index
+	i1 := c.reduce(hashIndex(h))
+
+	// An all-zero fingerprint is reserved for empty slots
+	f = nonZero(f)
 
-	// XOR (the ^ operator) the first hash value with the second hash value
-	// which returns a bit set to 1 for each position
-	//where the corresponding bits of the operands are different.
-	// E.g. 1010 ^ 1100 = 0110
-	// This is used to generate a second hash value different from the first hash value
-	i2 := i1 ^ uint(binary.BigEndian.Uint32(hash(f)))
+	// The second bucket is derived from the first bucket and the fingerprint
+	i2 := c.altIndex(i1, fingerprint(f))
 
 	// i1 and 12 represent the two possible buckets for the item
 	// while f represents the fingerprint of the item to insert, which is a slice of the hash of the item
 	return i1, i2, fingerprint(f)
 }
 
-func hash(data []byte) []byte {
-	// Compute the fingerprint of the item
-	hasher.Write([]byte(data))
+// nonZero maps an all-zero fingerprint to the fingerprint 0...01.
+// Once fingerprints are packed into integers or a flat byte array, an all-zero
+// value is indistinguishable from an empty slot: such an item would be lost on
+// insertion, never found on lookup, and could be "deleted" from an empty slot.
+// Remapping costs one extra collision between the zero and one fingerprints,
+// which only slightly raises the false positive rate of those two values.
+func nonZero(f fingerprint) fingerprint {
+	for _, x := range f {
+		if x != 0 {
+			return f
+		}
+	}
+	f[len(f)-1] = 1
+	return f
+}
 
-	// Get the SHA1 hash
-	hash := hasher.Sum(nil)
+// hashIndex returns the 64-bit value used to derive bucket indices from a hash.
+// It is read from the end of the hash because the fingerprint is sliced from
+// the start: if both came from the same bytes, items sharing a bucket would
+// also tend to share a fingerprint.
+func hashIndex(h []byte) uint64 {
+	return binary.BigEndian.Uint64(h[len(h)-8:])
+}
 
-	// Reset the hasher for the next use
-	hasher.Reset()
+// reduce maps a 64-bit hash value to a bucket index in [0, m).
+// m is always a power of two (see nextPower), so keeping the low bits of the
+// hash with the precomputed mask (h & (m-1)) is exactly h % m without a division,
+// and every bucket receives the same share of hash values (no modulo bias).
+// The full 64-bit hash is used, so filters with billions of buckets can be addressed.
+func (c *Cuckoo) reduce(h uint64) uint {
+	return uint(h) & c.mask
+}
 
-	return hash
+// altIndex returns the alternate bucket of a fingerprint stored in bucket i.
+// XOR (the ^ operator) the bucket index with the reduced hash of the fingerprint
+// which returns a bit set to 1 for each position
+// where the corresponding bits of the operands are different.
+// E.g. 1010 ^ 1100 = 0110
+// Because m is a power of two, XOR of two indices below m is again below m,
+// and altIndex(altIndex(i, f), f) == i, so an item can always move back
+// to the bucket it came from using only its fingerprint.
+func (c *Cuckoo) altIndex(i uint, f fingerprint) uint {
+	return i ^ c.reduce(hashIndex(hash(f)))
 }
 
-// nextIndex returns the next index for entry, or an error if the bucket is full
-func (b bucket) nextIndex() (int, error) {
-	for i, f := range b {
-		if f == nil {
-			return i, nil
-		}
-	}
-	return -1, errors.New("bucket full")
+// hash returns the SHA1 hash of data.
+// sha1.Sum keeps no state between calls (unlike a shared hash.Hash that is
+// written, summed and reset), so concurrent lookups can hash safely.
+func hash(data []byte) []byte {
+	// Compute the SHA1 hash of the item
+	hash := sha1.Sum(data)
+
+	return hash[:]
 }
 
 // Insert adds an item to the cuckoo filter
@@ -208,36 +276,49 @@ func (b bucket) nextIndex() (int, error) {
 //	    if success -> done
 //
 // The input is a string corresponding to the item to insert in the cuckoo filter
-func (c *Cuckoo) insert(input string) {
+// It returns ErrOverCapacity, without inserting, if the item would take the
+// filter above the maximum load factor set with WithMaxLoadFactor
+// and ErrFull if the filter has no room left for it (see ErrFull)
+func (c *Cuckoo) insert(input string) error {
+	if c.latency != nil {
+		defer c.observe(OpInsert, time.Now())
+	}
+
+	// Refuse empty keys unless allowed with WithEmptyKeys
+	if input == "" && !c.allowEmpty {
+		return ErrEmptyKey
+	}
 
 	// Get the two possible buckets (i1, i2) for the item and the fingerprint (f) to insert
 	// i1 and i2 only indicate the bucket index in the array of buckets for two possible buckets
 	i1, i2, f := c.hashes(input)
 
-	// first try bucket one to find an empty slot by calling the nextIndex function
-	// pick a bucket from the array of buckets using the modulo operator with l1
-	// b1 is a bucket of type []fingerprint
-	b1 := c.buckets[i1%c.m]
-
-	// Get i and err from the nextIndex function ("i, err := b1.nextIndex();")
-	// validating that there is an empty slot in the bucket ("err == nil")
-	// by checking if the error is nil
-	if i, err := b1.nextIndex(); err == nil {
-		// if there is an empty slot, insert the fingerprint
-		b1[i] = f
-		// No return value here because we are modifiying the "buckets"
+	return c.add(i1, i2, f)
+}
+
+// add stores the fingerprint f of an item whose candidate buckets are i1 and i2
+func (c *Cuckoo) add(i1, i2 uint, f fingerprint) error {
+	// With WithIdempotentInsert, an item already in the filter is not stored twice
+	if c.idempotent && c.contains(i1, i2, f) {
+		return nil
+	}
+
+	// Refuse the item if the filter is already at its maximum load factor
+	if c.maxLoad > 0 && float64(c.count+1) > c.maxLoad*float64(c.m*c.b) {
+		return ErrOverCapacity
+	}
+	c.count++
+
+	// first try bucket one to find an empty slot
+	if c.place(i1, f) {
+		// No error to return because we are modifiying the slots
 		// within the Cuckoo struct
-		return
+		return nil
 	}
 
 	// then try bucket two to find an empty slot if bucket one is full
-	b2 := c.buckets[i2%c.m]
-	if i, err := b2.nextIndex(); err == nil {
-		b2[i] = f
-
-		// No return value here because we are modifiying the "buckets"
-		//within the Cuckoo struct
-		return
+	if c.place(i2, f) {
+		return nil
 	}
 
 	// else we need to start relocating/shuffling items
@@ -246,71 +327,158 @@ func (c *Cuckoo) insert(input string) {
 	// Using the retries constant, try to relocate/shuffle items around to make space
 	//for a maximum of retries times
 	for r := 0; r < retries; r++ {
-		index := i % c.m
-		entryIndex := rand.Intn(int(c.b))
-		// swap
-		f, c.buckets[index][entryIndex] = c.buckets[index][entryIndex], f
-		i = i ^ uint(binary.BigEndian.Uint32(hash(f)))
-		b := c.buckets[i%c.m]
-		if idx, err := b.nextIndex(); err == nil {
-			b[idx] = f
-			return
+		entryIndex := uint(rand.Intn(int(c.b)))
+		// swap, byte by byte: f is the hash of this insert, not filter storage
+		e := c.entry(i, entryIndex)
+		for k := range f {
+			f[k], e[k] = e[k], f[k]
+		}
+		i = c.altIndex(i, f)
+		if c.place(i, f) {
+			return nil
 		}
 	}
-	panic("cuckoo filter full")
-}
 
-func (b bucket) contains(f fingerprint) (int, bool) {
-	for i, x := range b {
-		if bytes.Equal(x, f) {
-			return i, true
-		}
+	// f is the fingerprint left without a slot (either the new item's or one
+	// that was evicted), keep it in the victim stash so it is not lost
+	if c.stash(i, f) {
+		return nil
 	}
-	return -1, false
+	// f is lost
+	c.count--
+	return ErrFull
 }
 
 // lookup needle in the cuckoo filter
 func (c *Cuckoo) lookup(needle string) bool {
+	if c.latency != nil {
+		defer c.observe(OpLookup, time.Now())
+	}
 
 	// Get the two possible buckets (i1, i2) for the item and the fingerprint (f) to lookup
 	i1, i2, f := c.hashes(needle)
 
+	return c.contains(i1, i2, f)
+}
+
+// contains returns true if the fingerprint is in bucket i1, bucket i2 or the victim stash
+func (c *Cuckoo) contains(i1, i2 uint, f fingerprint) bool {
+	if c.constantTime {
+		return c.containsConstantTime(i1, i2, f)
+	}
+
 	// Check if the fingerprint is in the first bucket
-	_, b1 := c.buckets[i1%c.m].contains(f)
+	_, b1 := c.find(i1, f)
 
 	// Check if the fingerprint is in the second bucket
-	_, b2 := c.buckets[i2%c.m].contains(f)
+	_, b2 := c.find(i2, f)
+
+	// Check if the fingerprint is in the victim stash
+	_, s := c.stashed(i1, i2, f)
 
-	// Return true if the fingerprint is in either bucket
-	return b1 || b2
+	// Return true if the fingerprint is in either bucket or in the stash
+	return b1 || b2 || s
 }
 
 // delete the fingerprint from the cuckoo filter
 func (c *Cuckoo) delete(needle string) {
+	if c.latency != nil {
+		defer c.observe(OpDelete, time.Now())
+	}
 
 	// Get the two possible buckets (i1, i2) for the item and the fingerprint (f) to delete
 	i1, i2, f := c.hashes(needle)
 
-	// try to remove from bucket 1
-	b1 := c.buckets[i1%c.m]
-
-	// if the fingerprint is in the first bucket, set it to nil
-	if ind, ok := b1.contains(f); ok {
-		b1[ind] = nil
+	// if the fingerprint is in the first bucket, zero it
+	// and use the free slot for a stashed fingerprint
+	if j, ok := c.find(i1, f); ok {
+		clear(c.entry(i1, j))
+		c.count--
+		c.drainStash()
 		return
 	}
 
-	// try to remove from bucket 2
-	b2 := c.buckets[i2%c.m]
-
-	// if the fingerprint is in the second bucket, set it to nil
-	if ind, ok := b2.contains(f); ok {
-		b2[ind] = nil
+	// if the fingerprint is in the second bucket, zero it
+	if j, ok := c.find(i2, f); ok {
+		clear(c.entry(i2, j))
+		c.count--
+		c.drainStash()
 		return
 	}
+
+	// otherwise it may be in the victim stash
+	if k, ok := c.stashed(i1, i2, f); ok {
+		c.unstash(k)
+		c.count--
+	}
 }
 
 func main() {
+	vectors := flag.Bool("vectors", false, "write the cross-language test vectors as JSON to stdout and exit")
+	diagnose := flag.String("diagnose", "", "test the hash on the items of `file`, one per line, and exit")
+	fpRate := flag.Float64("fp", 0.01, "false positive rate of the filter tested by -diagnose")
+	migrate := flag.String("migrate", "", "rewrite the snapshot `file` in place in the version set by -to, and exit")
+	to := flag.Uint("to", SnapshotVersion, "snapshot version written by -migrate")
+	gc := flag.String("gc", "", "remove the snapshots of the page store in `dir` that -keep and -keep-days do not keep, and exit")
+	keep := flag.Int("keep", 7, "newest snapshots of each filter kept by -gc, at least 1")
+	keepDays := flag.Int("keep-days", 0, "snapshots younger than this many days are kept by -gc")
+	dryRun := flag.Bool("dry-run", false, "make -gc only report what it would remove")
+	exportMembers := flag.String("export-members", "", "write the members of the list `file`, one per line, to stdout in the format set by -format, and exit")
+	importMembers := flag.String("import-members", "", "read the member export `file` in the format set by -format, print its members one per line, and exit")
+	format := flag.String("format", "jsonl", "member format of -export-members and -import-members: csv or jsonl")
+	validate := flag.String("config-validate", "", "check the service configuration `file` with the environment overrides, print the effective configuration and exit")
+	flag.Parse()
+	if *vectors {
+		if err := writeVectors(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *migrate != "" {
+		if err := migrateSnapshot(*migrate, uint32(*to)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *validate != "" {
+		if err := validateConfig(*validate, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *gc != "" {
+		policy := RetentionPolicy{KeepLast: *keep, KeepFor: time.Duration(*keepDays) * 24 * time.Hour}
+		if err := gcStore(*gc, policy, *dryRun); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *exportMembers != "" {
+		if err := exportMembersFile(*exportMembers, *format, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *importMembers != "" {
+		if err := importMembersFile(*importMembers, *format, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *diagnose != "" {
+		if err := diagnoseFile(*diagnose, *fpRate, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Generate a new cuckoo filter with 10 items and a false positive rate of 0.1
 	cf := NewCuckooFilter(10, 0.1)
 
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestReduce(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	m := uint64(c.m)
+	for _, h := range []uint64{0, 1, m - 1, m, m + 1, 1<<63 + 12345, math.MaxUint64} {
+		if got, want := c.reduce(h), uint(h%uint64(c.m)); got != want {
+			t.Errorf("reduce(%d) = %d, want %d", h, got, want)
+		}
+	}
+}
+
+// TestBucketIndexUniform checks the occupancy of the primary buckets with a
+// chi-square test: for uniform indices the statistic is close to the degrees
+// of freedom, within a few standard deviations (sqrt(2 df))
+func TestBucketIndexUniform(t *testing.T) {
+	const items = 1 << 18
+	c := NewCuckooFilter(items/8, 0.01)
+	counts := make([]float64, c.m)
+	for i := 0; i < items; i++ {
+		i1, _, _ := c.hashes(strconv.Itoa(i))
+		counts[i1]++
+	}
+	expected := float64(items) / float64(c.m)
+	chi2 := 0.0
+	for _, n := range counts {
+		chi2 += (n - expected) * (n - expected) / expected
+	}
+	df := float64(c.m - 1)
+	if math.Abs(chi2-df) > 5*math.Sqrt(2*df) {
+		t.Errorf("chi-square %.0f for %.0f degrees of freedom: bucket indices are not uniform", chi2, df)
+	}
+}
+
+// zeroFingerprintItem returns an item whose hash starts with f zero bytes,
+// the fingerprint reserved for empty slots
+func zeroFingerprintItem(t *testing.T, c *Cuckoo) string {
+	t.Helper()
+	for i := 0; i < 1<<24; i++ {
+		item := "zero-" + strconv.Itoa(i)
+		if h := c.hashItem([]byte(item)); bytes.Equal(h[:c.f], make([]byte, c.f)) {
+			return item
+		}
+	}
+	t.Fatal("no item with a zero fingerprint")
+	return ""
+}
+
+func TestZeroFingerprint(t *testing.T) {
+	c := NewCuckooFilter(100, 0.01)
+	item := zeroFingerprintItem(t, c)
+	if _, _, f := c.hashes(item); bytes.Equal(f, make([]byte, c.f)) {
+		t.Fatalf("fingerprint of %q is all zero", item)
+	}
+
+	// deleting the item from an empty filter must not "remove" an empty slot
+	c.delete(item)
+	if c.count != 0 {
+		t.Errorf("count %d after deleting from an empty filter", c.count)
+	}
+
+	if err := c.insert(item); err != nil {
+		t.Fatal(err)
+	}
+	if !c.lookup(item) {
+		t.Errorf("item %q with a zero fingerprint not found after insertion", item)
+	}
+	if c.count != 1 {
+		t.Errorf("count %d after one insertion", c.count)
+	}
+	c.delete(item)
+	if c.lookup(item) || c.count != 0 {
+		t.Errorf("item %q still found after deletion (count %d)", item, c.count)
+	}
+}
+
+func TestNonZero(t *testing.T) {
+	if got := nonZero(fingerprint{0, 0}); !bytes.Equal(got, fingerprint{0, 1}) {
+		t.Errorf("nonZero(00) = %x, want 0001", got)
+	}
+	if got := nonZero(fingerprint{2, 0}); !bytes.Equal(got, fingerprint{2, 0}) {
+		t.Errorf("nonZero(0200) = %x, want it unchanged", got)
+	}
+}
+
+// TestCuckooSizing checks that a filter for n items has room for them below
+// the target occupancy, without allocating more than twice that, for small
+// and huge n (the huge sizes are only computed, not allocated)
+func TestCuckooSizing(t *testing.T) {
+	for _, n := range []uint{0, 1, 2, 3, 4, 10, 100, 1000, 1 << 20, 1e9, 1 << 40} {
+		m, f := cuckooParams(n, 0.01)
+		if m == 0 || m&(m-1) != 0 {
+			t.Errorf("n=%d: %d buckets, not a power of two", n, m)
+		}
+		if f < 1 {
+			t.Errorf("n=%d: %d-byte fingerprints", n, f)
+		}
+		slots := float64(m) * float64(b)
+		if float64(n) > slots*targetLoad {
+			t.Errorf("n=%d: %d buckets of %d entries exceed the target occupancy %.2f", n, m, b, targetLoad)
+		}
+		if n > b && float64(n) <= slots*targetLoad/2 {
+			t.Errorf("n=%d: %d buckets of %d entries, more than twice the needed", n, m, b)
+		}
+	}
+}
+
+// TestCuckooFillToCapacity inserts n items in filters built for n
+func TestCuckooFillToCapacity(t *testing.T) {
+	for _, n := range []uint{1, 2, 3, 10, 37, 1000, 100000} {
+		c := NewCuckooFilter(n, 0.1)
+		for i := uint(0); i < n; i++ {
+			if err := c.insert(strconv.Itoa(int(i))); err != nil {
+				t.Fatalf("n=%d: insertion %d: %v", n, i, err)
+			}
+		}
+		if c.count != n {
+			t.Errorf("n=%d: count %d", n, c.count)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Decaying count-min sketch.
+// A windowed sketch must be rotated, and forgets a burst all at once when its
+// window ends. Here every counter decays exponentially with time instead:
+// an occurrence counts 1 when added, 1/2 after one half-life, 1/4 after two,
+// and so on, so the count of an item is a rate over a sliding horizon of a
+// few half-lives, with no rotation.
+// Each counter stores its value and the time of its last update, and is
+// decayed to the current time before it is read or added to. All counters
+// decay at the same rate, so the minimum over the rows still never
+// underestimates the decayed count of an item.
+
+// decayCell is a counter: its value at time last
+type decayCell struct {
+	count float64
+	last  int64 // unix nanoseconds
+}
+
+// DecayingSketch is a count-min sketch with exponentially decaying counts.
+// It is not safe for concurrent use.
+type DecayingSketch struct {
+	width    uint
+	depth    uint
+	cells    []decayCell // depth rows of width counters
+	halfLife time.Duration
+	now      func() time.Time
+}
+
+// NewDecayingSketch returns a sketch with the error bounds of
+// NewCountMinSketch whose counts halve every halfLife
+func NewDecayingSketch(eps, delta float64, halfLife time.Duration) *DecayingSketch {
+	width, depth := sketchSize(eps, delta)
+	return &DecayingSketch{
+		width:    width,
+		depth:    depth,
+		cells:    make([]decayCell, width*depth),
+		halfLife: halfLife,
+		now:      time.Now,
+	}
+}
+
+// decayed returns the value of a counter at time now
+func (s *DecayingSketch) decayed(c decayCell, now int64) float64 {
+	if c.count == 0 || now <= c.last {
+		return c.count
+	}
+	return c.count * math.Exp2(-float64(now-c.last)/float64(s.halfLife))
+}
+
+// Add adds n occurrences (or n units of value) of the item now and returns
+// its new decayed count
+func (s *DecayingSketch) Add(item string, n float64) float64 {
+	now := s.now().UnixNano()
+	count := math.Inf(1)
+	sketchCells(item, s.width, s.depth, func(i uint) {
+		c := &s.cells[i]
+		c.count = s.decayed(*c, now) + n
+		c.last = max(c.last, now)
+		count = math.Min(count, c.count)
+	})
+	return count
+}
+
+// Count returns the decayed count of the item now
+func (s *DecayingSketch) Count(item string) float64 {
+	now := s.now().UnixNano()
+	count := math.Inf(1)
+	sketchCells(item, s.width, s.depth, func(i uint) {
+		count = math.Min(count, s.decayed(s.cells[i], now))
+	})
+	return count
+}
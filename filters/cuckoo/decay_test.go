@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDecayingSketch(t *testing.T) {
+	clock := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	s := NewDecayingSketch(0.001, 0.01, time.Hour)
+	s.now = func() time.Time { return clock }
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+
+	if got := s.Add("bc1q", 8); !near(got, 8) {
+		t.Errorf("Add = %v", got)
+	}
+	clock = clock.Add(time.Hour)
+	if got := s.Count("bc1q"); !near(got, 4) {
+		t.Errorf("count after one half-life = %v, want 4", got)
+	}
+	if got := s.Add("bc1q", 1); !near(got, 5) {
+		t.Errorf("Add after one half-life = %v, want 5", got)
+	}
+	clock = clock.Add(2 * time.Hour)
+	if got := s.Count("bc1q"); !near(got, 1.25) {
+		t.Errorf("count after three half-lives = %v, want 1.25", got)
+	}
+	if s.Count("never added") != 0 {
+		t.Error("count of an item never added")
+	}
+
+	// a clock set back before the last update reads the counters as updated
+	clock = clock.Add(-150 * time.Minute)
+	if got := s.Count("bc1q"); !near(got, 5) {
+		t.Errorf("count with the clock set back = %v, want 5", got)
+	}
+}
+
+func TestDecayingSketchOverestimates(t *testing.T) {
+	clock := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	s := NewDecayingSketch(0.001, 0.01, time.Hour)
+	s.now = func() time.Time { return clock }
+	for i := 0; i < 5000; i++ {
+		s.Add(strconv.Itoa(i), float64(i%10+1))
+		clock = clock.Add(time.Second)
+	}
+	// the decayed count of an item is at least its own decayed occurrences
+	for i := 0; i < 5000; i++ {
+		age := clock.Sub(time.Date(2026, 10, 15, 0, 0, i, 0, time.UTC))
+		want := float64(i%10+1) * math.Exp2(-age.Hours())
+		if got := s.Count(strconv.Itoa(i)); got < want-1e-9 {
+			t.Fatalf("count of %d = %v, below %v", i, got, want)
+		}
+	}
+}
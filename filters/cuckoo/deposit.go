@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Deposit detection by output script and calldata.
+// A watch-only wallet learns of a deposit from the outputs of a transaction,
+// which carry scriptPubKeys, not address strings. Matching on the script
+// avoids formatting every output as an address (and the mismatches between
+// encodings of the same address): the script is recognized as one of the
+// standard templates and the key it pays (a hash or a witness program) is
+// looked up in the filter watching that template.
+//
+//	P2PKH   OP_DUP OP_HASH160 <20> OP_EQUALVERIFY OP_CHECKSIG   key: hash160 of the public key
+//	P2SH    OP_HASH160 <20> OP_EQUAL                           key: hash160 of the redeem script
+//	P2WPKH  OP_0 <20>                                          key: hash160 of the public key
+//	P2WSH   OP_0 <32>                                          key: sha256 of the witness script
+//	P2TR    OP_1 <32>                                          key: output public key
+//
+// P2SH-wrapped segwit outputs (P2SH-P2WPKH, P2SH-P2WSH) are plain P2SH
+// outputs on chain: they are watched by the hash160 of their redeem script.
+//
+// On Ethereum, a token deposit is a call to the token contract:
+// transfer(to, amount) or transferFrom(from, to, amount). The recipient is
+// decoded from the calldata and token || to is looked up, as encoded by the
+// "erc20-transfer" key extractor.
+
+// DepositKind is the template a deposit is matched by
+type DepositKind uint8
+
+const (
+	KindP2PKH DepositKind = iota + 1
+	KindP2SH
+	KindP2WPKH
+	KindP2WSH
+	KindP2TR
+	KindERC20
+)
+
+var depositKindNames = map[DepositKind]string{
+	KindP2PKH:  "p2pkh",
+	KindP2SH:   "p2sh",
+	KindP2WPKH: "p2wpkh",
+	KindP2WSH:  "p2wsh",
+	KindP2TR:   "p2tr",
+	KindERC20:  "erc20",
+}
+
+func (k DepositKind) String() string {
+	if name, ok := depositKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("DepositKind(%d)", uint8(k))
+}
+
+// Script opcodes of the standard templates
+const (
+	opFalse       = 0x00
+	opTrue        = 0x51
+	opDup         = 0x76
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opHash160     = 0xa9
+	opCheckSig    = 0xac
+)
+
+// ParseScript recognizes a scriptPubKey as one of the standard templates and
+// returns its kind and the key it pays. ok is false for any other script
+// (multisig, OP_RETURN, future witness versions, ...).
+func ParseScript(script []byte) (kind DepositKind, key []byte, ok bool) {
+	switch n := len(script); {
+	case n == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == 20 &&
+		script[23] == opEqualVerify && script[24] == opCheckSig:
+		return KindP2PKH, script[3:23], true
+	case n == 23 && script[0] == opHash160 && script[1] == 20 && script[22] == opEqual:
+		return KindP2SH, script[2:22], true
+	case n == 22 && script[0] == opFalse && script[1] == 20:
+		return KindP2WPKH, script[2:], true
+	case n == 34 && script[0] == opFalse && script[1] == 32:
+		return KindP2WSH, script[2:], true
+	case n == 34 && script[0] == opTrue && script[1] == 32:
+		return KindP2TR, script[2:], true
+	}
+	return 0, nil, false
+}
+
+// ParseERC20Call decodes the recipient of an ERC-20 transfer or transferFrom
+// call to the token contract. ok is false for any other call, and for
+// calldata that is not a valid ABI encoding of them.
+func ParseERC20Call(token [20]byte, calldata []byte) (ERC20Transfer, bool) {
+	t, ok := DecodeTokenCall(token, [20]byte{}, calldata)
+	if !ok || t.Standard == StandardERC721 {
+		return ERC20Transfer{}, false
+	}
+	return ERC20Transfer{Token: t.Token, To: t.To}, true
+}
+
+// DepositMatcher looks up the outputs and token transfers of transactions in
+// one filter per deposit kind. The same filter may watch several kinds, e.g.
+// a filter of public key hashes for both P2PKH and P2WPKH.
+type DepositMatcher struct {
+	filters map[DepositKind]Filter
+}
+
+// NewDepositMatcher returns a matcher watching no kind
+func NewDepositMatcher() *DepositMatcher {
+	return &DepositMatcher{filters: make(map[DepositKind]Filter)}
+}
+
+// Watch sets the filter of the deposits of kind
+func (d *DepositMatcher) Watch(kind DepositKind, filter Filter) {
+	d.filters[kind] = filter
+}
+
+// AddScript adds the key of a scriptPubKey of the wallet to the filter of
+// its kind. It returns an error if the script is not a standard template or
+// its kind is not watched.
+func (d *DepositMatcher) AddScript(script []byte) error {
+	kind, key, ok := ParseScript(script)
+	if !ok {
+		return fmt.Errorf("script %s is not a standard template", hex.EncodeToString(script))
+	}
+	filter, ok := d.filters[kind]
+	if !ok {
+		return fmt.Errorf("%s outputs are not watched", kind)
+	}
+	return filter.insert(string(key))
+}
+
+// AddERC20 adds a token address of the wallet to the ERC-20 filter
+func (d *DepositMatcher) AddERC20(t ERC20Transfer) error {
+	filter, ok := d.filters[KindERC20]
+	if !ok {
+		return fmt.Errorf("%s transfers are not watched", KindERC20)
+	}
+	key, _ := erc20TransferKey(t)
+	return filter.insert(key)
+}
+
+// MatchScript returns the kind of a scriptPubKey and whether it pays the
+// wallet. Scripts of other templates and of unwatched kinds never match.
+func (d *DepositMatcher) MatchScript(script []byte) (DepositKind, bool) {
+	kind, key, ok := ParseScript(script)
+	if !ok {
+		return 0, false
+	}
+	filter, ok := d.filters[kind]
+	return kind, ok && filter.lookup(string(key))
+}
+
+// MatchCall returns true if a call to the token contract transfers tokens
+// to the wallet
+func (d *DepositMatcher) MatchCall(token [20]byte, calldata []byte) bool {
+	filter, ok := d.filters[KindERC20]
+	if !ok {
+		return false
+	}
+	t, ok := ParseERC20Call(token, calldata)
+	if !ok {
+		return false
+	}
+	key, _ := erc20TransferKey(t)
+	return filter.lookup(key)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Deposit pipeline.
+// A watch-only wallet subscribes to the blocks of its node and must find,
+// among thousands of outputs per block, the few paying it. The pipeline
+// matches every output script and token transfer against the DepositMatcher
+// (see deposit.go), confirms each candidate against the exact set of the
+// wallet's keys (a database, usually: only the candidates reach it), and
+// calls the callback with the confirmed deposits of the block, in block
+// order.
+// A block is delivered as a whole or not at all: the candidates of a block
+// are all verified before the first deposit is handed to the callback, so an
+// exact set error leaves nothing delivered, and Run stops with the error.
+// A callback error also stops Run, possibly after some deposits of the block
+// were delivered: the block is fed again on restart, so the callback must be
+// idempotent (a deposit is identified by its transaction and index).
+// Deposits are reported at the height of the block that includes them;
+// waiting for confirmations and handling reorganizations is up to the
+// callback.
+
+// Block is what the pipeline needs of a block
+type Block struct {
+	Height uint64
+	Hash   string
+	Txs    []BlockTx
+}
+
+// BlockTx is what the pipeline needs of a transaction: its outputs on UTXO
+// chains, its calls to token contracts on EVM chains
+type BlockTx struct {
+	ID      string
+	Outputs []TxOutput
+	From    [20]byte    // sender, on EVM chains (the caller of the top-level call)
+	Calls   []TokenCall // the call of the transaction and its internal calls, from a trace
+}
+
+// TxOutput is an output of a transaction
+type TxOutput struct {
+	Script []byte // scriptPubKey
+	Value  uint64 // in the smallest unit
+}
+
+// TokenCall is a call to a contract
+type TokenCall struct {
+	From [20]byte // caller: the transaction sender, or the contract making an internal call
+	To   [20]byte // contract called
+	Data []byte   // calldata
+}
+
+// Deposit is a confirmed payment to the wallet
+type Deposit struct {
+	Kind      DepositKind
+	Height    uint64
+	BlockHash string
+	TxID      string
+	Index     int            // output index, or call index for a token transfer
+	Value     uint64         // of an output
+	Transfer  *TokenTransfer // of a token transfer, nil for an output
+}
+
+// DepositPipeline feeds the confirmed deposits of blocks to a callback.
+// Blocks must be processed one at a time, in chain order.
+type DepositPipeline struct {
+	matcher  *DepositMatcher
+	exact    ExactSet // keys of the wallet, as inserted in the filters of matcher
+	callback func(Deposit) error
+
+	candidates atomic.Uint64 // outputs and transfers matching the filters
+	deposits   atomic.Uint64 // candidates confirmed by the exact set
+}
+
+// NewDepositPipeline returns a pipeline matching with matcher, confirming
+// against exact and delivering to callback. exact holds the keys the
+// filters of matcher hold: script keys as returned by ParseScript, token
+// transfers as encoded by the "erc20-transfer" key extractor.
+func NewDepositPipeline(matcher *DepositMatcher, exact ExactSet, callback func(Deposit) error) *DepositPipeline {
+	return &DepositPipeline{matcher: matcher, exact: exact, callback: callback}
+}
+
+// Run processes the blocks of a subscription until the channel is closed
+// (nil is returned), the context is cancelled, or a block fails
+func (p *DepositPipeline) Run(ctx context.Context, blocks <-chan Block) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case blk, ok := <-blocks:
+			if !ok {
+				return nil
+			}
+			if err := p.ProcessBlock(blk); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ProcessBlock delivers the confirmed deposits of a block to the callback
+func (p *DepositPipeline) ProcessBlock(blk Block) error {
+	var deposits []Deposit
+	confirm := func(key string, d Deposit) error {
+		p.candidates.Add(1)
+		ok, err := p.exact.Contains(key)
+		if err != nil {
+			return fmt.Errorf("block %d tx %s index %d: %w", blk.Height, d.TxID, d.Index, err)
+		}
+		if ok {
+			deposits = append(deposits, d)
+		}
+		return nil
+	}
+
+	for _, tx := range blk.Txs {
+		for i, out := range tx.Outputs {
+			kind, ok := p.matcher.MatchScript(out.Script)
+			if !ok {
+				continue
+			}
+			_, key, _ := ParseScript(out.Script)
+			d := Deposit{Kind: kind, Height: blk.Height, BlockHash: blk.Hash, TxID: tx.ID, Index: i, Value: out.Value}
+			if err := confirm(string(key), d); err != nil {
+				return err
+			}
+		}
+		for i, call := range tx.Calls {
+			if !p.matcher.MatchCall(call.To, call.Data) {
+				continue
+			}
+			// the tokens of a transfer() come from the caller, a contract
+			// for an internal call, not the sender of the transaction
+			t, ok := DecodeTokenCall(call.To, call.From, call.Data)
+			if !ok {
+				continue
+			}
+			key, _ := erc20TransferKey(ERC20Transfer{Token: t.Token, To: t.To})
+			d := Deposit{Kind: KindERC20, Height: blk.Height, BlockHash: blk.Hash, TxID: tx.ID, Index: i, Transfer: &t}
+			if err := confirm(key, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, d := range deposits {
+		p.deposits.Add(1)
+		if err := p.callback(d); err != nil {
+			return fmt.Errorf("deposit callback, block %d tx %s index %d: %w", blk.Height, d.TxID, d.Index, err)
+		}
+	}
+	return nil
+}
+
+// Stats returns the candidates matched by the filters and those confirmed
+// as deposits; the others were false positives
+func (p *DepositPipeline) Stats() (candidates, deposits uint64) {
+	// loaded in this order, deposits never exceeds candidates
+	deposits = p.deposits.Load()
+	return p.candidates.Load(), deposits
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// p2wpkh returns the P2WPKH script paying the hash
+func p2wpkh(hash byte) []byte {
+	script := []byte{opFalse, 20}
+	return append(script, slices.Repeat([]byte{hash}, 20)...)
+}
+
+// transferCall returns the calldata of transfer(to, amount)
+func transferCall(to [20]byte, amount byte) []byte {
+	data := slices.Clone(selectorTransfer)
+	data = append(data, make([]byte, 12)...)
+	data = append(data, to[:]...)
+	data = append(data, make([]byte, 31)...)
+	return append(data, amount)
+}
+
+func TestDepositPipeline(t *testing.T) {
+	token, wallet := [20]byte{0xee}, [20]byte{0x77}
+	sender, router := [20]byte{0x11}, [20]byte{0x22}
+	m := NewDepositMatcher()
+	m.Watch(KindP2WPKH, NewCuckooFilter(100, 0.01))
+	m.Watch(KindERC20, NewCuckooFilter(100, 0.01))
+	exact := make(MapSet)
+	for _, script := range [][]byte{p2wpkh(1), p2wpkh(2)} {
+		if err := m.AddScript(script); err != nil {
+			t.Fatal(err)
+		}
+		_, key, _ := ParseScript(script)
+		exact.Add(string(key))
+	}
+	if err := m.AddERC20(ERC20Transfer{Token: token, To: wallet}); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := erc20TransferKey(ERC20Transfer{Token: token, To: wallet})
+	exact.Add(key)
+	// a key of the filter that the wallet no longer holds: a false positive
+	if err := m.AddScript(p2wpkh(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	blk := Block{Height: 100, Hash: "00ab", Txs: []BlockTx{
+		{ID: "tx1", Outputs: []TxOutput{{Script: p2wpkh(9), Value: 5}, {Script: p2wpkh(1), Value: 7}}},
+		{ID: "tx2", Outputs: []TxOutput{{Script: p2wpkh(3), Value: 1}, {Script: p2wpkh(2), Value: 8}}},
+		// a call of the sender, then an internal call of a router contract
+		{ID: "tx3", From: sender, Calls: []TokenCall{
+			{From: sender, To: token, Data: transferCall([20]byte{0x66}, 1)},
+			{From: router, To: token, Data: transferCall(wallet, 42)},
+		}},
+	}}
+
+	var got []Deposit
+	p := NewDepositPipeline(m, exact, func(d Deposit) error {
+		got = append(got, d)
+		return nil
+	})
+	if err := p.ProcessBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind  DepositKind
+		tx    string
+		index int
+	}{{KindP2WPKH, "tx1", 1}, {KindP2WPKH, "tx2", 1}, {KindERC20, "tx3", 1}}
+	if len(got) != len(want) {
+		t.Fatalf("%d deposits, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		d := got[i]
+		if d.Kind != w.kind || d.TxID != w.tx || d.Index != w.index || d.Height != 100 || d.BlockHash != "00ab" {
+			t.Errorf("deposit %d = %+v, want %v", i, d, w)
+		}
+	}
+	if got[0].Value != 7 || got[2].Transfer == nil || got[2].Transfer.Value.Int64() != 42 {
+		t.Fatalf("deposit amounts %d and %v", got[0].Value, got[2].Transfer)
+	}
+	if got[2].Transfer.From != router {
+		t.Errorf("internal transfer from %x, want the calling contract %x", got[2].Transfer.From, router)
+	}
+	if c, d := p.Stats(); c != 4 || d != 3 {
+		t.Errorf("Stats = %d candidates, %d deposits, want 4 and 3", c, d)
+	}
+
+	// an exact set error delivers nothing of the block
+	got = nil
+	failing := NewDepositPipeline(m, failingSet{}, p.callback)
+	if err := failing.ProcessBlock(blk); !errors.Is(err, errUnreachable) {
+		t.Errorf("exact set error: ProcessBlock = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("%d deposits delivered despite the error", len(got))
+	}
+
+	// Run stops at the first callback error
+	errCallback := errors.New("callback failed")
+	calls := 0
+	stopping := NewDepositPipeline(m, exact, func(Deposit) error {
+		calls++
+		return errCallback
+	})
+	blocks := make(chan Block, 2)
+	blocks <- blk
+	blocks <- blk
+	if err := stopping.Run(context.Background(), blocks); !errors.Is(err, errCallback) {
+		t.Errorf("Run = %v, want the callback error", err)
+	}
+	if calls != 1 {
+		t.Errorf("callback called %d times after an error", calls)
+	}
+
+	// and returns once the subscription ends
+	blocks = make(chan Block, 1)
+	blocks <- blk
+	close(blocks)
+	got = nil
+	if err := p.Run(context.Background(), blocks); err != nil || len(got) != 3 {
+		t.Errorf("Run = %v with %d deposits", err, len(got))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Run(ctx, make(chan Block)); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Run = %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Known device tracking.
+// A signing request from a device the user never used before (a new
+// browser, a stolen session token replayed elsewhere) deserves a second
+// factor. DeviceFilter remembers the (user, device fingerprint) pairs seen,
+// in a rotating filter of cuckoo generations: a pair is forgotten after
+// the TTL without requests.
+// An administrator can revoke a device. Its pair cannot be deleted from the
+// generations (see Rotating), so revocations are kept apart, exactly: the
+// next request of a revoked device is flagged, which lifts the revocation,
+// and a revocation is dropped once the generations that held the pair have
+// expired. Revocations are rare, so the set stays small.
+// A false positive lets a new device pass as known with the filter's false
+// positive rate.
+
+// deviceGenerations is the number of generations of the TTL
+const deviceGenerations = 8
+
+// DeviceFilter tracks the known devices of the users.
+// It is safe for concurrent use.
+type DeviceFilter struct {
+	seen *Rotating
+	keep time.Duration // time a pair stays in the generations
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time // key of the pair -> time of the revocation
+	now     func() time.Time
+}
+
+// NewDeviceFilter returns a filter for n (user, device) pairs seen within
+// a TTL, with the false positive rate e. A pair is remembered between ttl
+// and ttl + ttl/8 after its last request.
+func NewDeviceFilter(n uint, e float64, ttl time.Duration) *DeviceFilter {
+	newFilter := func() Filter {
+		return NewCuckooFilter(n, e, WithIdempotentInsert())
+	}
+	period := ttl / deviceGenerations
+	return &DeviceFilter{
+		seen:    NewRotating(period, deviceGenerations+1, newFilter),
+		keep:    period * (deviceGenerations + 1),
+		revoked: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func deviceKey(userID, device string) string {
+	return userID + "\x00" + device
+}
+
+// Seen records a request of the user from the device and returns true if
+// the device was known. A request from an unknown device should be flagged
+// (e.g., challenged with a second factor); the device is known from then on.
+func (d *DeviceFilter) Seen(userID, device string) (bool, error) {
+	key := deviceKey(userID, device)
+	known := d.seen.lookup(key)
+	if d.isRevoked(key) {
+		d.mu.Lock()
+		delete(d.revoked, key)
+		d.mu.Unlock()
+		known = false
+	}
+	return known, d.seen.insert(key)
+}
+
+// isRevoked returns true if the pair was revoked since its last request
+func (d *DeviceFilter) isRevoked(key string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.revoked[key]
+	return ok
+}
+
+// Known returns true if the device is known for the user, without recording
+// a request
+func (d *DeviceFilter) Known(userID, device string) bool {
+	key := deviceKey(userID, device)
+	return d.seen.lookup(key) && !d.isRevoked(key)
+}
+
+// Revoke forgets the device of the user: its next request is flagged
+func (d *DeviceFilter) Revoke(userID, device string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	for key, at := range d.revoked {
+		if now.Sub(at) > d.keep {
+			delete(d.revoked, key)
+		}
+	}
+	d.revoked[deviceKey(userID, device)] = now
+}
+
+// AdminHandler serves the revocation of devices:
+//
+//	DELETE ?user=<user ID>&device=<device fingerprint>
+//
+// It does not authenticate requests: mount it behind the admin
+// authentication of the API.
+func (d *DeviceFilter) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", http.MethodDelete)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, device := r.URL.Query().Get("user"), r.URL.Query().Get("device")
+		if user == "" || device == "" {
+			http.Error(w, "user and device are required", http.StatusBadRequest)
+			return
+		}
+		d.Revoke(user, device)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
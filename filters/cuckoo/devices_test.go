@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeviceFilter(t *testing.T) {
+	clock := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	now := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		clock = clock.Add(d)
+		mu.Unlock()
+	}
+	const ttl = 8 * time.Hour
+	d := NewDeviceFilter(1000, 0.001, ttl)
+	d.now, d.seen.now = now, now
+	d.seen.gens[0].Start = clock
+
+	seen := func(user, device string) bool {
+		t.Helper()
+		known, err := d.Seen(user, device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return known
+	}
+	if seen("alice", "laptop") {
+		t.Error("first request from a device known")
+	}
+	if !seen("alice", "laptop") || !d.Known("alice", "laptop") {
+		t.Error("device unknown after a request")
+	}
+	if d.Known("bob", "laptop") || d.Known("alice", "phone") {
+		t.Error("device known for another user, or another device")
+	}
+
+	// a revoked device is flagged once
+	d.Revoke("alice", "laptop")
+	if d.Known("alice", "laptop") {
+		t.Error("revoked device known")
+	}
+	if seen("alice", "laptop") {
+		t.Error("request of a revoked device not flagged")
+	}
+	if !seen("alice", "laptop") {
+		t.Error("device flagged again after its challenge")
+	}
+	// as is a device revoked before its first request
+	d.Revoke("carol", "tablet")
+	if seen("carol", "tablet") || !seen("carol", "tablet") {
+		t.Error("device revoked before its first request: flagged more or less than once")
+	}
+
+	// a device is forgotten after the TTL without requests
+	advance(ttl - time.Hour)
+	if !seen("alice", "laptop") {
+		t.Error("device forgotten within the TTL")
+	}
+	advance(ttl + ttl/deviceGenerations)
+	if d.Known("alice", "laptop") {
+		t.Error("device known after the TTL")
+	}
+}
+
+func TestDeviceRevocationsPruned(t *testing.T) {
+	clock := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	d := NewDeviceFilter(100, 0.01, 8*time.Hour)
+	d.now = func() time.Time { return clock }
+	d.Revoke("alice", "laptop")
+	d.Revoke("bob", "phone")
+	clock = clock.Add(d.keep + time.Minute)
+	d.Revoke("carol", "tablet")
+	if len(d.revoked) != 1 || !d.isRevoked(deviceKey("carol", "tablet")) {
+		t.Errorf("revocations %v, want only the last one", d.revoked)
+	}
+}
+
+func TestDeviceAdminHandler(t *testing.T) {
+	d := NewDeviceFilter(100, 0.01, time.Hour)
+	d.Seen("alice", "laptop")
+	h := d.AdminHandler()
+	for _, tc := range []struct {
+		method, query string
+		want          int
+	}{
+		{http.MethodGet, "?user=alice&device=laptop", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "?user=alice", http.StatusBadRequest},
+		{http.MethodDelete, "?device=laptop", http.StatusBadRequest},
+		{http.MethodDelete, "?user=alice&device=laptop", http.StatusNoContent},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, "/devices"+tc.query, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.query, rec.Code, tc.want)
+		}
+	}
+	if d.Known("alice", "laptop") {
+		t.Error("device known after its revocation")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Hash quality diagnostics.
+// The false positive rate and the load a cuckoo filter reaches assume its hash
+// spreads items uniformly: if real addresses (which share prefixes, checksums
+// and encodings) pile up in some buckets or fingerprints, the filter fills
+// early and false positives are more frequent than configured.
+// DiagnoseHash measures, over a sample of real items and for the filter's
+// hash (keyed or not):
+//   - bucket occupancy: chi-square test of the first bucket of every item
+//     (buckets are grouped by their low bits so each group expects at least
+//     5 items, as the chi-square test requires)
+//   - fingerprint distribution: chi-square test of the first fingerprint byte
+//     (0 and 1 are left out: nonZero maps 0 to 1)
+//   - avalanche: flipping one input bit should flip every hash bit with
+//     probability 1/2
+//
+// The chi-square statistics are reported as z-scores, (chi2 - df) / sqrt(2 df),
+// which are approximately standard normal for a uniform hash.
+// `go run . -diagnose addresses.txt` runs the tests on a file of items.
+
+const (
+	maxZScore         = 4.0  // |z| above this is flagged
+	maxAvalancheBias  = 0.05 // |P(flip) - 1/2| above this is flagged
+	avalancheMaxItems = 1000 // items of the sample used for the avalanche test
+)
+
+// HashReport is the result of DiagnoseHash
+type HashReport struct {
+	Items         int
+	BucketZ       float64 // z-score of the bucket occupancy
+	FingerprintZ  float64 // z-score of the first fingerprint byte
+	AvalancheBias float64 // worst |P(output bit flips) - 1/2| over the hash bits
+	Warnings      []string
+}
+
+// OK returns true if no statistic was flagged
+func (r HashReport) OK() bool {
+	return len(r.Warnings) == 0
+}
+
+func (r HashReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "items:            %d\n", r.Items)
+	fmt.Fprintf(&sb, "bucket z-score:   %.2f\n", r.BucketZ)
+	fmt.Fprintf(&sb, "fingerprint z:    %.2f\n", r.FingerprintZ)
+	fmt.Fprintf(&sb, "avalanche bias:   %.4f\n", r.AvalancheBias)
+	for _, w := range r.Warnings {
+		fmt.Fprintf(&sb, "WARNING: %s\n", w)
+	}
+	return sb.String()
+}
+
+// DiagnoseHash tests the hash of the filter c on sample (see above).
+// The filter is not modified.
+func DiagnoseHash(c *Cuckoo, sample []string) HashReport {
+	r := HashReport{Items: len(sample)}
+	if len(sample) < 10 {
+		r.Warnings = append(r.Warnings, "sample too small, at least 10 items are needed")
+		return r
+	}
+
+	groups := min(c.m, nextPower(uint(len(sample)/5+1))/2)
+	buckets := make([]float64, groups)
+	fps := make([]float64, 254)
+	fpItems := 0
+	for _, item := range sample {
+		i1, _, f := c.hashes(item)
+		buckets[i1&(groups-1)]++
+		if f[0] >= 2 {
+			fps[f[0]-2]++
+			fpItems++
+		}
+	}
+	r.BucketZ = chiSquareZ(buckets, len(sample))
+	r.FingerprintZ = chiSquareZ(fps, fpItems)
+
+	if math.Abs(r.BucketZ) > maxZScore {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("bucket occupancy is not uniform (z = %.2f): the filter will fill early", r.BucketZ))
+	}
+	if math.Abs(r.FingerprintZ) > maxZScore {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("fingerprints are not uniform (z = %.2f): the false positive rate will be higher than configured", r.FingerprintZ))
+	}
+
+	r.AvalancheBias = c.avalancheBias(sample)
+	if r.AvalancheBias > maxAvalancheBias {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("poor avalanche (bias %.4f): similar items get related buckets and fingerprints", r.AvalancheBias))
+	}
+	return r
+}
+
+// chiSquareZ returns the z-score of the chi-square statistic of counts
+// against a uniform distribution of n items
+func chiSquareZ(counts []float64, n int) float64 {
+	expected := float64(n) / float64(len(counts))
+	var chi2 float64
+	for _, o := range counts {
+		d := o - expected
+		chi2 += d * d / expected
+	}
+	df := float64(len(counts) - 1)
+	if df == 0 {
+		return 0
+	}
+	return (chi2 - df) / math.Sqrt(2*df)
+}
+
+// avalancheBias flips every bit of (up to avalancheMaxItems) sample items and
+// returns the worst deviation from 1/2 of the flip probability of a hash bit
+func (c *Cuckoo) avalancheBias(sample []string) float64 {
+	var flips []float64
+	trials := 0
+	for _, item := range sample[:min(len(sample), avalancheMaxItems)] {
+		data := []byte(item)
+		h := c.hashItem(data)
+		if flips == nil {
+			flips = make([]float64, 8*len(h))
+		}
+		for bit := 0; bit < 8*len(data); bit++ {
+			data[bit/8] ^= 1 << (bit % 8)
+			h2 := c.hashItem(data)
+			data[bit/8] ^= 1 << (bit % 8)
+
+			for o := range flips {
+				if (h[o/8]^h2[o/8])>>(o%8)&1 == 1 {
+					flips[o]++
+				}
+			}
+			trials++
+		}
+	}
+
+	var worst float64
+	for _, n := range flips {
+		worst = math.Max(worst, math.Abs(n/float64(trials)-0.5))
+	}
+	return worst
+}
+
+// diagnoseFile runs DiagnoseHash on the items of a file, one per line, with a
+// filter sized for them and the false positive rate e, and prints the report
+// to w. It returns an error if the file cannot be read or a statistic is flagged.
+func diagnoseFile(path string, e float64, w io.Writer) error {
+	sample, err := readItems(path)
+	if err != nil {
+		return err
+	}
+
+	r := DiagnoseHash(NewCuckooFilter(uint(len(sample)), e), sample)
+	if _, err := fmt.Fprint(w, r); err != nil {
+		return err
+	}
+	if !r.OK() {
+		return fmt.Errorf("%s: hash quality warnings", path)
+	}
+	return nil
+}
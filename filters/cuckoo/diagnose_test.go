@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addresses returns n items sharing a prefix and an encoding, as real
+// addresses do
+func addresses(n int) []string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf("bc1q%038x", i)
+	}
+	return items
+}
+
+func TestDiagnoseHash(t *testing.T) {
+	sample := addresses(2000)
+	for _, c := range []*Cuckoo{
+		NewCuckooFilter(2000, 0.001),
+		NewCuckooFilter(2000, 0.001, WithKey([]byte("tenant key"))),
+		NewCuckooFilter(2000, 0.001, WithHashFamily(HashSHA256, 7)),
+	} {
+		r := DiagnoseHash(c, sample)
+		if !r.OK() || r.Items != len(sample) {
+			t.Errorf("%v", r)
+		}
+	}
+
+	// a hash copying its input piles items up and does not avalanche
+	identity := &HashFamily{ID: 999, Name: "identity", sum: func(data []byte) []byte {
+		h := make([]byte, 32)
+		copy(h, data)
+		return h
+	}}
+	r := DiagnoseHash(NewCuckooFilter(2000, 0.001, WithHashFamily(identity, 0)), sample)
+	if r.OK() || len(r.Warnings) != 3 {
+		t.Errorf("identity hash:\n%v", r)
+	}
+	if !strings.Contains(r.String(), "WARNING: poor avalanche") {
+		t.Errorf("report lacks the avalanche warning:\n%v", r)
+	}
+
+	if r := DiagnoseHash(NewCuckooFilter(100, 0.01), addresses(9)); r.OK() {
+		t.Error("sample of 9 items diagnosed")
+	}
+}
+
+func TestChiSquareZ(t *testing.T) {
+	if z := chiSquareZ([]float64{100, 100, 100, 100}, 400); z >= 0 {
+		t.Errorf("z-score of a perfectly even distribution = %v", z)
+	}
+	if z := chiSquareZ([]float64{400, 0, 0, 0}, 400); z < maxZScore {
+		t.Errorf("z-score of a single bucket = %v", z)
+	}
+	if z := chiSquareZ([]float64{5}, 5); z != 0 {
+		t.Errorf("z-score of one group = %v", z)
+	}
+}
+
+func TestDiagnoseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addresses.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(addresses(5000), "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := diagnoseFile(path, 0.01, &out); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(out.String(), "items:            5000") {
+		t.Errorf("report:\n%s", out.String())
+	}
+	if err := diagnoseFile(filepath.Join(t.TempDir(), "missing.txt"), 0.01, &out); err == nil {
+		t.Error("missing file diagnosed")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Dust attack detection.
+// A dusting attack sends many tiny outputs to the addresses of a wallet, in
+// the hope that the wallet spends them together with its other coins and
+// links its addresses. A few small deposits are normal; a burst of them to
+// one address is not. DustDetector counts the outputs of at most DustLimit
+// received by each deposit address in the current window, in a count-min
+// sketch (memory does not grow with the number of addresses), and flags the
+// address once its count reaches the burst size.
+// The wallet quarantines the dust outputs of a flagged address, so coin
+// selection never spends them. A sketch may overcount, which only flags an
+// address early: never too late.
+
+// DustOutput is an output received by a deposit address
+type DustOutput struct {
+	Address  string
+	Outpoint Outpoint
+	Value    uint64 // in the smallest unit (satoshis)
+}
+
+// DustAlert is reported for an output to an address under a dust burst
+type DustAlert struct {
+	Output DustOutput
+	Count  uint64    // dust outputs received by the address in the window
+	Window time.Time // start of the window
+}
+
+// DustDetector flags bursts of dust outputs per address.
+// It is safe for concurrent use.
+type DustDetector struct {
+	mu     sync.Mutex
+	limit  uint64        // outputs of at most limit are dust
+	burst  uint64        // dust outputs per window that flag an address
+	window time.Duration // counting window
+	start  time.Time     // start of the current window
+	sketch *CountMinSketch
+	bus    *EventBus // nil if events are not published
+	tenant string
+	now    func() time.Time
+}
+
+// NewDustDetector returns a detector flagging an address once it received
+// burst outputs of at most limit within a window. bus may be nil; when set,
+// a "dust" event is published the first time an address is flagged in a window.
+func NewDustDetector(limit, burst uint64, window time.Duration, bus *EventBus, tenant string) *DustDetector {
+	d := &DustDetector{
+		limit:  limit,
+		burst:  max(burst, 1),
+		window: window,
+		sketch: NewCountMinSketch(0.0001, 0.001),
+		bus:    bus,
+		tenant: tenant,
+		now:    time.Now,
+	}
+	d.start = d.now().Truncate(window)
+	return d
+}
+
+// Observe counts an output received by a deposit address and returns an
+// alert if it is dust to an address under a burst: the caller quarantines
+// the output, and on the first alert of the address, its other dust outputs.
+func (d *DustDetector) Observe(out DustOutput) (DustAlert, bool) {
+	if out.Value > d.limit {
+		return DustAlert{}, false
+	}
+
+	d.mu.Lock()
+	if start := d.now().Truncate(d.window); start.After(d.start) {
+		d.start = start
+		d.sketch.Reset()
+	}
+	count := d.sketch.Add(out.Address, 1)
+	alert := DustAlert{Output: out, Count: count, Window: d.start}
+	d.mu.Unlock()
+
+	if count < d.burst {
+		return DustAlert{}, false
+	}
+	if count == d.burst && d.bus != nil {
+		// a closed bus does not publish the event; the alert is returned anyway
+		_ = d.bus.Emit(Verdict{Item: out.Address, Results: []Result{{Filter: "dust", Hit: true}}}, d.tenant)
+	}
+	return alert, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDustDetector(t *testing.T) {
+	clock := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+	rec := &recordingPublisher{}
+	bus, err := NewEventBus([]byte("event key"), 10, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDustDetector(1000, 3, time.Hour, bus, "tenant-a")
+	d.now = func() time.Time { return clock }
+	d.start = clock.Truncate(time.Hour)
+
+	out := func(addr string, vout uint32, value uint64) DustOutput {
+		return DustOutput{Address: addr, Outpoint: Outpoint{Vout: vout}, Value: value}
+	}
+	// payments above the limit are not counted
+	for i := 0; i < 5; i++ {
+		if _, ok := d.Observe(out("bc1qvictim", uint32(i), 50000)); ok {
+			t.Fatal("payment flagged as dust")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := d.Observe(out("bc1qvictim", uint32(i), 546)); ok {
+			t.Fatalf("dust output %d flagged below the burst", i)
+		}
+	}
+	for i := 2; i < 5; i++ {
+		alert, ok := d.Observe(out("bc1qvictim", uint32(i), 546))
+		if !ok || alert.Count != uint64(i+1) || alert.Output.Outpoint.Vout != uint32(i) || !alert.Window.Equal(d.start) {
+			t.Errorf("output %d: alert %+v, %v", i, alert, ok)
+		}
+	}
+	if _, ok := d.Observe(out("bc1qother", 0, 546)); ok {
+		t.Error("other address flagged")
+	}
+
+	// a new window starts from zero
+	clock = clock.Add(time.Hour)
+	if _, ok := d.Observe(out("bc1qvictim", 9, 546)); ok {
+		t.Error("address still flagged in the next window")
+	}
+
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// one event, the first time the address is flagged
+	if len(rec.events) != 1 || rec.events[0].Filter != "dust" || rec.events[0].Tenant != "tenant-a" {
+		t.Errorf("events %+v", rec.events)
+	}
+}
+
+func TestDustDetectorWithoutBus(t *testing.T) {
+	d := NewDustDetector(1000, 1, time.Hour, nil, "")
+	for i := 0; i < 3; i++ {
+		if _, ok := d.Observe(DustOutput{Address: "bc1q" + strconv.Itoa(i), Value: 1}); !ok {
+			t.Error("dust output not flagged with a burst of 1")
+		}
+	}
+	if d.burst != 1 || NewDustDetector(1000, 0, time.Hour, nil, "").burst != 1 {
+		t.Error("burst below 1")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the filters. Callers branch on them with errors.Is
+// (for the sentinel values) and errors.As (for the error types), never on
+// the message.
+var (
+	// ErrFull is returned by insert when the item could not be placed after
+	// the maximum number of relocations (and, for the cuckoo filter, the
+	// victim stash is full too). The last relocated fingerprint is dropped,
+	// so one previously inserted item may no longer be found: the filter
+	// should be rebuilt larger.
+	ErrFull = errors.New("filter full")
+
+	// ErrOverCapacity is returned by insert when the item would take the filter
+	// above its maximum load factor
+	ErrOverCapacity = errors.New("cuckoo filter over capacity")
+
+	// ErrEmptyKey is returned by insert for the empty key, unless WithEmptyKeys is set
+	ErrEmptyKey = errors.New("empty key")
+
+	// ErrClosed is returned by the operations of a filter after Close
+	ErrClosed = errors.New("filter closed")
+
+	// ErrIncompatibleParams matches every *ParamMismatchError with errors.Is,
+	// for callers that do not need to know which parameter differs
+	ErrIncompatibleParams = errors.New("incompatible filter parameters")
+)
+
+// Is makes errors.Is(err, ErrIncompatibleParams) true for a *ParamMismatchError
+func (e *ParamMismatchError) Is(target error) bool {
+	return target == ErrIncompatibleParams
+}
+
+// ErrCorruptSnapshot is returned when a serialized filter cannot be decoded
+type ErrCorruptSnapshot struct {
+	Offset int64  // position in the input where decoding failed
+	Reason string // what was wrong at that position
+}
+
+func (e *ErrCorruptSnapshot) Error() string {
+	return fmt.Sprintf("corrupt snapshot at offset %d: %s", e.Offset, e.Reason)
+}
+
+// ErrVersionMismatch is returned when a serialized filter uses a format
+// version this code cannot read
+type ErrVersionMismatch struct {
+	Got  uint32 // version of the input
+	Want uint32 // version supported
+}
+
+func (e *ErrVersionMismatch) Error() string {
+	return fmt.Sprintf("snapshot version %d not supported (want %d)", e.Got, e.Want)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is published when a screened item hits a filter.
+// Only a keyed hash of the item is published, HMAC-SHA256 under the key of
+// the bus: a plain hash of an address can be reversed by hashing candidate
+// addresses, which are public. Subscribers holding the key can match an
+// event with an address they know; the others only see an opaque ID, the
+// same for every hit of the item.
+type Event struct {
+	Filter    string    `json:"filter"`
+	ItemHash  string    `json:"item_hash"` // hex HMAC-SHA256 of the item under the bus key
+	Tenant    string    `json:"tenant,omitempty"`
+	Confirmed bool      `json:"confirmed"`
+	Time      time.Time `json:"time"`
+}
+
+// Publisher delivers events to one destination (webhook, message broker, ...).
+// Publish must be safe to call again with the same event after a failure:
+// delivery is at-least-once, so subscribers must tolerate duplicates.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// WebhookPublisher posts every event as JSON to a URL
+type WebhookPublisher struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (w *WebhookPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// ErrBusClosed is returned by Emit once the bus is closed
+var ErrBusClosed = errors.New("event bus closed")
+
+// EventBus publishes screening hits to every publisher in the background.
+// Each publisher has its own queue and goroutine, so a slow or failing
+// publisher does not hold back the others until its queue is full. An event
+// is retried with exponential backoff up to maxAttempts times per publisher,
+// then dropped (see Dropped): delivery is at-least-once as long as the
+// publisher recovers within the retries. The queues live in memory: events
+// still queued when the process dies are lost.
+type EventBus struct {
+	key         []byte // of the item hashes
+	subscribers []*subscriber
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	mu       sync.RWMutex // held by Emit while queueing, to close the queues
+	closed   bool
+	stop     chan struct{} // closed by Close, to unblock Emit
+	stopOnce sync.Once
+
+	// backoff before the first retry, doubled up to maxBackoff after each failure
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int // deliveries of an event to a publisher before it is dropped
+
+	dropped atomic.Uint64
+}
+
+// subscriber is a publisher and the queue of its events
+type subscriber struct {
+	p     Publisher
+	queue chan Event
+}
+
+// NewEventBus starts a bus delivering to the publishers.
+// key is the secret the item hashes are keyed with (see Event).
+// queueSize bounds the number of events waiting for delivery to each publisher.
+func NewEventBus(key []byte, queueSize int, publishers ...Publisher) (*EventBus, error) {
+	if len(key) == 0 {
+		return nil, errors.New("event bus without a key")
+	}
+	bus := newEventBus(key, queueSize, publishers...)
+	bus.start()
+	return bus, nil
+}
+
+// newEventBus returns a bus with the default retries, not started yet
+func newEventBus(key []byte, queueSize int, publishers ...Publisher) *EventBus {
+	ctx, cancel := context.WithCancel(context.Background())
+	bus := &EventBus{
+		key:         append([]byte(nil), key...),
+		ctx:         ctx,
+		cancel:      cancel,
+		stop:        make(chan struct{}),
+		backoff:     100 * time.Millisecond,
+		maxBackoff:  30 * time.Second,
+		maxAttempts: 20,
+	}
+	for _, p := range publishers {
+		bus.subscribers = append(bus.subscribers, &subscriber{p: p, queue: make(chan Event, queueSize)})
+	}
+	return bus
+}
+
+// start starts the delivery goroutine of every publisher
+func (bus *EventBus) start() {
+	for _, sub := range bus.subscribers {
+		bus.wg.Add(1)
+		go bus.run(sub)
+	}
+}
+
+// Emit queues an event for every filter that hit in the verdict, to every
+// publisher. It blocks while the queue of a publisher is full, so a slow
+// subscriber slows screening down rather than losing alerts. It returns
+// ErrBusClosed once the bus is closed, including while it is blocked.
+func (bus *EventBus) Emit(v Verdict, tenant string) error {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	if bus.closed {
+		return ErrBusClosed
+	}
+
+	var itemHash string
+	for _, r := range v.Results {
+		if !r.Hit {
+			continue
+		}
+		if itemHash == "" {
+			mac := hmac.New(sha256.New, bus.key)
+			mac.Write([]byte(v.Item))
+			itemHash = hex.EncodeToString(mac.Sum(nil))
+		}
+		e := Event{
+			Filter:    r.Filter,
+			ItemHash:  itemHash,
+			Tenant:    tenant,
+			Confirmed: r.Confirmed,
+			Time:      time.Now(),
+		}
+		for _, sub := range bus.subscribers {
+			select {
+			case sub.queue <- e:
+			case <-bus.stop:
+				return ErrBusClosed
+			}
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of deliveries given up after maxAttempts
+func (bus *EventBus) Dropped() uint64 {
+	return bus.dropped.Load()
+}
+
+// Close stops accepting events and waits until the queued ones are delivered
+// or ctx is done, in which case undelivered events are dropped. Emit calls
+// blocked on a full queue return ErrBusClosed.
+func (bus *EventBus) Close(ctx context.Context) error {
+	bus.stopOnce.Do(func() { close(bus.stop) })
+	bus.mu.Lock()
+	if bus.closed {
+		bus.mu.Unlock()
+		return ErrBusClosed
+	}
+	bus.closed = true
+	for _, sub := range bus.subscribers {
+		close(sub.queue)
+	}
+	bus.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bus.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		bus.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (bus *EventBus) run(sub *subscriber) {
+	defer bus.wg.Done()
+	for e := range sub.queue {
+		if !bus.deliver(sub.p, e) {
+			bus.dropped.Add(1)
+		}
+	}
+}
+
+// deliver retries until the publisher accepts the event, maxAttempts
+// deliveries failed or the bus is cancelled, and returns true if the event
+// was accepted
+func (bus *EventBus) deliver(p Publisher, e Event) bool {
+	backoff := bus.backoff
+	for attempt := 1; ; attempt++ {
+		if err := p.Publish(bus.ctx, e); err == nil {
+			return true
+		}
+		if attempt >= bus.maxAttempts {
+			return false
+		}
+		select {
+		case <-bus.ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > bus.maxBackoff {
+			backoff = bus.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher records the events it accepts
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, e Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+	return nil
+}
+
+func (p *recordingPublisher) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.events)
+}
+
+// downPublisher fails every delivery
+type downPublisher struct {
+	mu       sync.Mutex
+	attempts int
+}
+
+func (p *downPublisher) Publish(context.Context, Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	return errors.New("publisher down")
+}
+
+func hitVerdict(item string) Verdict {
+	return Verdict{Item: item, Results: []Result{{Filter: "sanctions", Hit: true}}}
+}
+
+func TestEventBusEmitAfterClose(t *testing.T) {
+	bus, err := NewEventBus([]byte("event key"), 1, &recordingPublisher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Emit(hitVerdict("addr"), ""); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("Emit after Close: got %v, want ErrBusClosed", err)
+	}
+	if err := bus.Close(context.Background()); !errors.Is(err, ErrBusClosed) {
+		t.Errorf("second Close: got %v, want ErrBusClosed", err)
+	}
+}
+
+func TestEventBusFailingPublisher(t *testing.T) {
+	ok, down := &recordingPublisher{}, &downPublisher{}
+	bus := newEventBus([]byte("event key"), 10, down, ok)
+	bus.backoff, bus.maxBackoff, bus.maxAttempts = time.Millisecond, time.Millisecond, 3
+	bus.start()
+
+	const events = 5
+	for i := 0; i < events; i++ {
+		if err := bus.Emit(hitVerdict("addr"), "tenant"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the healthy publisher is not held back by the retries of the other one
+	deadline := time.Now().Add(time.Second)
+	for ok.count() < events && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := ok.count(); got != events {
+		t.Errorf("healthy publisher got %d events, want %d", got, events)
+	}
+
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if down.attempts != events*3 {
+		t.Errorf("%d delivery attempts, want %d", down.attempts, events*3)
+	}
+	if got := bus.Dropped(); got != events {
+		t.Errorf("%d events dropped, want %d", got, events)
+	}
+}
+
+func TestEventBusCloseUnblocksEmit(t *testing.T) {
+	down := &downPublisher{}
+	bus := newEventBus([]byte("event key"), 1, down)
+	bus.backoff, bus.maxBackoff = time.Hour, time.Hour
+	bus.start()
+
+	// the first event is retried for an hour, the second one fills the queue
+	if err := bus.Emit(hitVerdict("addr"), ""); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		down.mu.Lock()
+		attempts := down.attempts
+		down.mu.Unlock()
+		if attempts > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := bus.Emit(hitVerdict("addr"), ""); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bus.Emit(hitVerdict("addr"), "")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := bus.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close: got %v, want the deadline of its context", err)
+	}
+	if err := <-errc; !errors.Is(err, ErrBusClosed) {
+		t.Errorf("blocked Emit: got %v, want ErrBusClosed", err)
+	}
+}
+
+func TestEventItemHash(t *testing.T) {
+	if _, err := NewEventBus(nil, 1); err == nil {
+		t.Error("NewEventBus accepted an empty key")
+	}
+	key := []byte("event key")
+	var hashes []string
+	for _, k := range [][]byte{key, []byte("other key")} {
+		rec := &recordingPublisher{}
+		bus, err := NewEventBus(k, 10, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Emit(hitVerdict("bc1qexample"), ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.events) != 1 {
+			t.Fatalf("%d events", len(rec.events))
+		}
+		hashes = append(hashes, rec.events[0].ItemHash)
+	}
+
+	// the hash of the item cannot be recomputed without the key
+	plain := sha256.Sum256([]byte("bc1qexample"))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("bc1qexample"))
+	if hashes[0] != hex.EncodeToString(mac.Sum(nil)) || hashes[0] == hex.EncodeToString(plain[:]) {
+		t.Errorf("item hash %s is not the HMAC of the item", hashes[0])
+	}
+	if hashes[0] == hashes[1] {
+		t.Error("item hash does not depend on the key")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "sync/atomic"
+
+// ExactSet is the exact membership store consulted to confirm a filter hit.
+// A filter never misses an inserted item but may report items that were never
+// inserted, so any blocking decision (sanctions, allowlist) must be confirmed
+// against the real list. Implementations may be in-memory, a database or a
+// remote API, hence the error.
+type ExactSet interface {
+	Contains(item string) (bool, error)
+}
+
+// MapSet is an in-memory ExactSet
+type MapSet map[string]struct{}
+
+// Add adds items to the set
+func (s MapSet) Add(items ...string) {
+	for _, item := range items {
+		s[item] = struct{}{}
+	}
+}
+
+func (s MapSet) Contains(item string) (bool, error) {
+	_, ok := s[item]
+	return ok, nil
+}
+
+// Confirmed pairs a filter with the exact set it was built from.
+// Items missed by the filter are rejected without touching the exact set;
+// filter hits are confirmed against it, and false positives are reported
+// back to adaptive filters.
+// It counts hits and confirmations: the share of unconfirmed hits is the
+// observed false positive rate, and a drift away from the filter's
+// target rate means the filter is overfilled or the list has changed.
+// The counters are atomic: Contains may be called concurrently if the filter
+// and the exact set allow it.
+type Confirmed struct {
+	filter Lookuper
+	exact  ExactSet
+
+	hits      atomic.Uint64 // lookups that matched the filter
+	confirmed atomic.Uint64 // hits found in the exact set
+}
+
+// NewConfirmed returns a filter whose hits are confirmed against exact
+func NewConfirmed(filter Lookuper, exact ExactSet) *Confirmed {
+	return &Confirmed{filter: filter, exact: exact}
+}
+
+// Contains reports whether the item is a confirmed member.
+// An error from the exact set is returned as is, and the hit is not counted as
+// confirmed: callers making blocking decisions should fail closed on it.
+func (c *Confirmed) Contains(item string) (bool, error) {
+	if !c.filter.lookup(item) {
+		return false, nil
+	}
+	c.hits.Add(1)
+	ok, err := c.exact.Contains(item)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		c.confirmed.Add(1)
+	} else if a, adaptive := c.filter.(falsePositiveReporter); adaptive {
+		a.reportFalsePositive(item)
+	}
+	return ok, nil
+}
+
+// falsePositiveReporter is implemented by filters that can adapt to a
+// confirmed false positive so it does not repeat (see Adaptive)
+type falsePositiveReporter interface {
+	reportFalsePositive(item string) bool
+}
+
+// ConfirmationRate returns the share of filter hits confirmed by the exact set
+// (1 - observed false positive share of hits), or 1 if there was no hit yet
+func (c *Confirmed) ConfirmationRate() float64 {
+	hits, confirmed := c.Hits()
+	if hits == 0 {
+		return 1
+	}
+	return float64(confirmed) / float64(hits)
+}
+
+// Hits returns the number of filter hits and how many of them were confirmed
+func (c *Confirmed) Hits() (hits, confirmed uint64) {
+	// a hit is counted before its confirmation, so loading confirmed first
+	// never reports more confirmations than hits
+	confirmed = c.confirmed.Load()
+	return c.hits.Load(), confirmed
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestConfirmedConcurrent counts hits and confirmations from concurrent
+// lookups; run with -race to check the counters
+func TestConfirmedConcurrent(t *testing.T) {
+	f := NewBlockedBloomFilter(1000, 0.001) // lock-free lookups, so the test does not order the counters
+	exact := make(MapSet)
+	for i := 0; i < 100; i++ {
+		f.insert(strconv.Itoa(i))
+		exact.Add(strconv.Itoa(i))
+	}
+	c := NewConfirmed(f, exact)
+
+	const workers, rounds = 8, 1000
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				if ok, err := c.Contains(strconv.Itoa(r % 100)); !ok || err != nil {
+					t.Errorf("inserted item %d: %v, %v", r%100, ok, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if hits, confirmed := c.Hits(); hits != workers*rounds || confirmed != workers*rounds {
+		t.Errorf("%d hits, %d confirmed, want %d", hits, confirmed, workers*rounds)
+	}
+	if rate := c.ConfirmationRate(); rate != 1 {
+		t.Errorf("confirmation rate %v, want 1", rate)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build experimental
+
+package main
+
+import "unsafe"
+
+// ExperimentalFilters is true in builds with the experimental tag, which
+// include the Morton, vacuum and adaptive filters (see stability.go)
+const ExperimentalFilters = true
+
+// estimateExperimental completes the parameters of an experimental filter
+// for EstimateMemory and returns its memory
+func estimateExperimental(params *Config) uint64 {
+	switch params.Type {
+	case VacuumType:
+		m, _, f := vacuumParams(params.N, params.FPRate)
+		params.M, params.B, params.F = m, b, f
+		return uint64(m) * uint64(b) * uint64(f)
+
+	case MortonType:
+		// the fingerprint size is fixed, fpRate does not change the layout
+		blocks := mortonBlocks(params.N)
+		params.M, params.F = blocks, 8
+		return uint64(blocks) * uint64(unsafe.Sizeof(mortonBlock{}))
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+//go:build experimental
+
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestExperimentalFilterConformance runs RunConformance against the filters
+// of the experimental build
+func TestExperimentalFilterConformance(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		FilterFactory
+	}{
+		{"morton", FilterFactory{New: func(n uint) Filter { return NewMortonFilter(n) }, MaxFPRate: 0.04}}, // 8-bit fingerprints
+		{"vacuum", FilterFactory{New: func(n uint) Filter { return NewVacuumFilter(n, 0.01) }, MaxFPRate: cuckooFPBound(0.01)}},
+		{"adaptive", FilterFactory{New: func(n uint) Filter { return NewAdaptiveFilter(n, 0.01) }, MaxFPRate: cuckooFPBound(0.01)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) { RunConformance(t, tc.FilterFactory) })
+	}
+}
+
+// TestVacuumMemory checks that a vacuum filter for n just above a power of
+// two uses its slab of m*b*f bytes, well below the cuckoo filter's doubled table
+func TestVacuumMemory(t *testing.T) {
+	const n = 1<<20 + 1
+	v := NewVacuumFilter(n, 0.01)
+	vacuum, params := EstimateMemory(n, 0.01, VacuumType)
+	if uint64(len(v.slots)) != vacuum || params.M != v.m {
+		t.Errorf("vacuum filter of %d bytes, %d buckets; estimated %d bytes, %d buckets", len(v.slots), v.m, vacuum, params.M)
+	}
+	cuckoo, _ := EstimateMemory(n, 0.01, CuckooType)
+	if float64(vacuum) > 0.6*float64(cuckoo) {
+		t.Errorf("vacuum filter of %d bytes, cuckoo filter of %d", vacuum, cuckoo)
+	}
+}
+
+func TestAdaptiveFalsePositive(t *testing.T) {
+	a := NewAdaptiveFilter(1000, 0.2) // one byte fingerprints: false positives are easy to find
+	members := make(MapSet)
+	for i := 0; i < 900; i++ {
+		item := strconv.Itoa(i)
+		if err := a.insert(item); err != nil {
+			t.Fatal(err)
+		}
+		members.Add(item)
+	}
+	var fps []string
+	for i := 1000; len(fps) < 20; i++ {
+		if item := strconv.Itoa(i); a.lookup(item) {
+			fps = append(fps, item)
+		}
+	}
+
+	c := NewConfirmed(a, members)
+	for _, item := range fps {
+		if ok, err := c.Contains(item); ok || err != nil {
+			t.Fatalf("Contains(%s) = %v, %v", item, ok, err)
+		}
+	}
+	repeated := 0
+	for _, item := range fps {
+		if a.lookup(item) {
+			repeated++
+		}
+	}
+	// a re-encoded slot may collide again, with the rate of the filter
+	if repeated > len(fps)/4 {
+		t.Errorf("%d of %d reported false positives repeat", repeated, len(fps))
+	}
+	for i := 0; i < 900; i++ {
+		if !a.lookup(strconv.Itoa(i)) {
+			t.Fatalf("member %d lost by the adaptation", i)
+		}
+	}
+
+	// lookups only read the compact part
+	remote := a.remote
+	a.remote = nil
+	if !a.lookup("1") {
+		t.Error("member not found without the remote representation")
+	}
+	a.remote = remote
+
+	// a delete clears the item's own slot only
+	a.delete("1")
+	for i := 0; i < 900; i++ {
+		if i != 1 && !a.lookup(strconv.Itoa(i)) {
+			t.Fatalf("member %d lost by the delete of another", i)
+		}
+	}
+}
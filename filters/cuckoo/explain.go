@@ -0,0 +1,87 @@
+package main
+
+import "encoding/hex"
+
+// Explained lookups.
+// When support asks why an address was flagged, a bare "hit" is not enough:
+// LookupExplain shows what the filter computed for the item (fingerprint,
+// candidate buckets), how full those buckets are and where the fingerprint
+// was found. A hit in a full bucket of a filter near capacity is much more
+// likely to be a false positive than one in a near-empty bucket.
+// Screener.Explain adds the result of every filter of the chain, with the
+// explanation of each filter that can give one.
+
+// BucketProbe is one candidate bucket of an explained lookup
+type BucketProbe struct {
+	Index    uint `json:"index"`
+	Occupied uint `json:"occupied"` // non-empty entries of the bucket
+	Size     uint `json:"size"`     // entries per bucket
+	Entry    int  `json:"entry"`    // entry holding the fingerprint, -1 if none
+}
+
+// Explanation details a lookup in a cuckoo filter
+type Explanation struct {
+	Item        string         `json:"item"`
+	Fingerprint string         `json:"fingerprint"` // hex
+	Buckets     [2]BucketProbe `json:"buckets"`
+	Stashed     bool           `json:"stashed"` // found in the victim stash
+	Found       bool           `json:"found"`
+}
+
+// explainer is implemented by filters that can explain a lookup
+type explainer interface {
+	LookupExplain(item string) Explanation
+}
+
+var _ explainer = (*Cuckoo)(nil)
+
+// LookupExplain looks the item up and explains the result. It gives the
+// same answer as a lookup, but is not recorded in latency statistics.
+// For a keyed filter, the fingerprint shown depends on the key.
+func (c *Cuckoo) LookupExplain(item string) Explanation {
+	i1, i2, f := c.hashes(item)
+	x := Explanation{Item: item, Fingerprint: hex.EncodeToString(f)}
+	for k, i := range [2]uint{i1, i2} {
+		p := BucketProbe{Index: i, Size: c.b, Entry: -1}
+		for j := uint(0); j < c.b; j++ {
+			if !isEmpty(c.entry(i, j)) {
+				p.Occupied++
+			}
+		}
+		if j, ok := c.find(i, f); ok {
+			p.Entry = int(j)
+		}
+		x.Buckets[k] = p
+	}
+	_, x.Stashed = c.stashed(i1, i2, f)
+	x.Found = x.Buckets[0].Entry >= 0 || x.Buckets[1].Entry >= 0 || x.Stashed
+	return x
+}
+
+// StageExplanation is the result of one filter of an explained screening
+type StageExplanation struct {
+	Result
+	Lookup *Explanation `json:"lookup,omitempty"` // nil if the filter cannot explain its lookups
+}
+
+// ScreenExplanation is an explained screening
+type ScreenExplanation struct {
+	Verdict Verdict            `json:"verdict"`
+	Stages  []StageExplanation `json:"stages"` // in chain order
+}
+
+// Explain screens the item as Check does, and explains the lookup of every
+// filter of the chain. It counts as a screening: exact sets are consulted
+// and shadow filters compared.
+func (s *Screener) Explain(item string) ScreenExplanation {
+	v := s.Check(item)
+	x := ScreenExplanation{Verdict: v, Stages: make([]StageExplanation, len(s.stages))}
+	for i, st := range s.stages {
+		x.Stages[i].Result = v.Results[i]
+		if e, ok := st.filter.(explainer); ok {
+			lookup := e.LookupExplain(item)
+			x.Stages[i].Lookup = &lookup
+		}
+	}
+	return x
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestLookupExplain(t *testing.T) {
+	c := NewCuckooFilter(200, 0.1)
+	var inserted []string
+	for i := 0; len(c.victims) == 0; i++ {
+		item := strconv.Itoa(i)
+		if err := c.insert(item); err != nil {
+			break
+		}
+		inserted = append(inserted, item)
+	}
+
+	stashed := 0
+	for _, item := range inserted {
+		x := c.LookupExplain(item)
+		if !x.Found {
+			t.Fatalf("inserted %s not found: %+v", item, x)
+		}
+		if x.Stashed {
+			stashed++
+			continue
+		}
+		for _, p := range x.Buckets {
+			if p.Entry >= 0 && (p.Occupied == 0 || uint(p.Entry) >= p.Size) {
+				t.Errorf("%s: %+v", item, p)
+			}
+		}
+		if x.Buckets[0].Entry < 0 && x.Buckets[1].Entry < 0 {
+			t.Errorf("%s found in no bucket: %+v", item, x)
+		}
+	}
+	if stashed != len(c.victims) {
+		t.Errorf("%d items explained as stashed, %d in the stash", stashed, len(c.victims))
+	}
+
+	// the same answer as a lookup, for members or not
+	for i := 0; i < 5000; i++ {
+		item := "other-" + strconv.Itoa(i)
+		x := c.LookupExplain(item)
+		if x.Found != c.lookup(item) {
+			t.Fatalf("%s: explained %v, looked up %v", item, x.Found, c.lookup(item))
+		}
+		i1, i2, f := c.hashes(item)
+		if x.Buckets[0].Index != i1 || x.Buckets[1].Index != i2 || len(x.Fingerprint) != 2*len(f) || x.Item != item {
+			t.Fatalf("%s: %+v", item, x)
+		}
+	}
+}
+
+func TestScreenerExplain(t *testing.T) {
+	ofac := NewCuckooFilter(100, 0.01)
+	ofac.insert("bc1qsanctioned")
+	bloom := NewBlockedBloomFilter(100, 0.01)
+	bloom.insert("bc1qsanctioned")
+
+	s := NewScreener()
+	s.Add("ofac", ofac, 0.01, MapSet{"bc1qsanctioned": {}})
+	s.Add("bloom", bloom, 0.01, nil)
+	x := s.Explain("bc1qsanctioned")
+	if x.Verdict.Matched == nil || x.Verdict.Matched.Filter != "ofac" || len(x.Stages) != 2 {
+		t.Fatalf("explanation %+v", x)
+	}
+	if st := x.Stages[0]; !st.Hit || !st.Confirmed || st.Lookup == nil || !st.Lookup.Found {
+		t.Errorf("ofac stage %+v", st)
+	}
+	// a filter that cannot explain its lookups still gives its result
+	if st := x.Stages[1]; !st.Hit || st.Lookup != nil {
+		t.Errorf("bloom stage %+v", st)
+	}
+	if _, err := json.Marshal(x); err != nil {
+		t.Error(err)
+	}
+
+	if x := s.Explain("bc1qclean"); x.Verdict.Matched != nil || x.Stages[0].Lookup.Found {
+		t.Errorf("clean address explained as %+v", x)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// A KeyExtractor turns a domain object into the key that is hashed into a
+// filter. Every call site of a filter of outpoints must marshal them the
+// same way, byte for byte, or lookups miss: with an extractor, the encoding
+// is chosen once per filter, by name, and call sites pass the objects.
+type KeyExtractor interface {
+	Key(v any) (string, error)
+}
+
+// KeyExtractorFunc adapts a function to the KeyExtractor interface
+type KeyExtractorFunc func(v any) (string, error)
+
+// Key calls f(v)
+func (f KeyExtractorFunc) Key(v any) (string, error) {
+	return f(v)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]KeyExtractor{
+		"raw":            KeyExtractorFunc(rawKey),
+		"outpoint":       KeyExtractorFunc(outpointKey),
+		"erc20-transfer": KeyExtractorFunc(erc20TransferKey),
+	}
+)
+
+// RegisterKeyExtractor makes an extractor available under name.
+// It returns an error if the name is already taken, so a plugin cannot
+// silently change the encoding of existing filters.
+func RegisterKeyExtractor(name string, e KeyExtractor) error {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	if _, ok := extractors[name]; ok {
+		return fmt.Errorf("key extractor %q already registered", name)
+	}
+	extractors[name] = e
+	return nil
+}
+
+// lookupKeyExtractor returns the extractor registered under name,
+// the raw one if name is empty
+func lookupKeyExtractor(name string) (KeyExtractor, error) {
+	if name == "" {
+		name = "raw"
+	}
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	e, ok := extractors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown key extractor %q", name)
+	}
+	return e, nil
+}
+
+// Outpoint identifies a transaction output (UTXO)
+type Outpoint struct {
+	TxID [32]byte
+	Vout uint32
+}
+
+// ERC20Transfer is the part of an ERC-20 transfer that identifies its
+// destination: the token contract and the recipient
+type ERC20Transfer struct {
+	Token [20]byte
+	To    [20]byte
+}
+
+// rawKey accepts keys that are already marshaled
+func rawKey(v any) (string, error) {
+	switch k := v.(type) {
+	case string:
+		return k, nil
+	case []byte:
+		return string(k), nil
+	}
+	return "", fmt.Errorf("raw key: unsupported type %T", v)
+}
+
+// outpointKey encodes an outpoint as txid || vout, the vout in little-endian
+// as in the Bitcoin serialization
+func outpointKey(v any) (string, error) {
+	o, ok := v.(Outpoint)
+	if !ok {
+		return "", fmt.Errorf("outpoint key: unsupported type %T", v)
+	}
+	var k [36]byte
+	copy(k[:32], o.TxID[:])
+	binary.LittleEndian.PutUint32(k[32:], o.Vout)
+	return string(k[:]), nil
+}
+
+// erc20TransferKey encodes a transfer as token || to
+func erc20TransferKey(v any) (string, error) {
+	t, ok := v.(ERC20Transfer)
+	if !ok {
+		return "", fmt.Errorf("erc20-transfer key: unsupported type %T", v)
+	}
+	return string(t.Token[:]) + string(t.To[:]), nil
+}
+
+// Keyed is a filter of domain objects, keyed by a named extractor
+type Keyed struct {
+	filter  Filter
+	extract KeyExtractor
+}
+
+// NewKeyed wraps filter so its items are extracted with the extractor
+// registered under name, e.g. the Extractor of its FilterConfig
+func NewKeyed(filter Filter, name string) (*Keyed, error) {
+	e, err := lookupKeyExtractor(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyed{filter: filter, extract: e}, nil
+}
+
+// Insert adds the key of v to the filter
+func (k *Keyed) Insert(v any) error {
+	key, err := k.extract.Key(v)
+	if err != nil {
+		return err
+	}
+	return k.filter.insert(key)
+}
+
+// Lookup returns true if the key of v is in the filter
+func (k *Keyed) Lookup(v any) (bool, error) {
+	key, err := k.extract.Key(v)
+	if err != nil {
+		return false, err
+	}
+	return k.filter.lookup(key), nil
+}
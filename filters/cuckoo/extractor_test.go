@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKeyExtractors(t *testing.T) {
+	var txid [32]byte
+	txid[0], txid[31] = 0xaa, 0xbb
+	var token, to [20]byte
+	token[0], to[19] = 0x01, 0x02
+	for _, tc := range []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"raw", "bc1qexample", "bc1qexample"},
+		{"raw", []byte("bc1qexample"), "bc1qexample"},
+		{"", "bc1qexample", "bc1qexample"},
+		{"outpoint", Outpoint{TxID: txid, Vout: 258}, string(txid[:]) + "\x02\x01\x00\x00"},
+		{"erc20-transfer", ERC20Transfer{Token: token, To: to}, string(token[:]) + string(to[:])},
+	} {
+		e, err := lookupKeyExtractor(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := e.Key(tc.v)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("%s key = %x, want %x", tc.name, got, tc.want)
+		}
+	}
+
+	for _, name := range []string{"raw", "outpoint", "erc20-transfer"} {
+		e, _ := lookupKeyExtractor(name)
+		if _, err := e.Key(42); err == nil {
+			t.Errorf("%s extractor accepted an int", name)
+		}
+	}
+	if _, err := lookupKeyExtractor("nope"); err == nil {
+		t.Error("unknown extractor found")
+	}
+}
+
+func TestRegisterKeyExtractor(t *testing.T) {
+	lower := KeyExtractorFunc(func(v any) (string, error) {
+		return strings.ToLower(v.(string)), nil
+	})
+	if err := RegisterKeyExtractor("outpoint", lower); err == nil {
+		t.Error("built-in extractor replaced")
+	}
+	if err := RegisterKeyExtractor("test-lower", lower); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterKeyExtractor("test-lower", lower); err == nil {
+		t.Error("extractor registered twice")
+	}
+
+	k, err := NewKeyed(NewCuckooFilter(100, 0.01), "test-lower")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Insert("0xABC"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := k.Lookup("0xabc"); err != nil || !ok {
+		t.Errorf("Lookup of the same key = %v, %v", ok, err)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	if _, err := NewKeyed(NewCuckooFilter(100, 0.01), "nope"); err == nil {
+		t.Error("NewKeyed accepted an unknown extractor")
+	}
+	k, err := NewKeyed(NewCuckooFilter(100, 0.01), "outpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := Outpoint{Vout: 1}
+	if err := k.Insert(spent); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := k.Lookup(Outpoint{Vout: 1}); err != nil || !ok {
+		t.Errorf("Lookup of the inserted outpoint = %v, %v", ok, err)
+	}
+	if ok, _ := k.Lookup(Outpoint{Vout: 2}); ok {
+		t.Error("other output of the transaction found")
+	}
+	if err := k.Insert("raw bytes"); err == nil {
+		t.Error("Insert accepted an item of the wrong type")
+	}
+	if _, err := k.Lookup("raw bytes"); err == nil {
+		t.Error("Lookup accepted an item of the wrong type")
+	}
+}
+
+func TestServiceConfigExtractor(t *testing.T) {
+	in := `{"filters": {"utxos": {"type": "cuckoo", "capacity": 1000, "fprate": 0.01, "extractor": "outpoint"}}}`
+	cfg, err := LoadServiceConfig(strings.NewReader(in), noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := cfg.Filters["utxos"]
+	if _, err := NewKeyed(NewCuckooFilter(f.Capacity, f.FPRate), f.Extractor); err != nil {
+		t.Error(err)
+	}
+
+	bad := strings.Replace(in, `"outpoint"`, `"utxo"`, 1)
+	if _, err := LoadServiceConfig(strings.NewReader(bad), noEnv); err == nil || !strings.Contains(err.Error(), "filters.utxos.extractor") {
+		t.Errorf("unknown extractor: %v", err)
+	}
+	env := func(k string) (string, bool) {
+		return "nope", k == "BLOOM_FILTERS_UTXOS_EXTRACTOR"
+	}
+	if _, err := LoadServiceConfig(strings.NewReader(in), env); err == nil {
+		t.Error("unknown extractor from the environment accepted")
+	}
+	// no extractor is the raw one
+	plain := `{"filters": {"sanctions": {"type": "cuckoo", "capacity": 1000, "fprate": 0.01}}}`
+	if _, err := LoadServiceConfig(bytes.NewReader([]byte(plain)), noEnv); err != nil {
+		t.Error(err)
+	}
+}
+
+func noEnv(string) (string, bool) { return "", false }
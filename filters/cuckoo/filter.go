@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Filter is the interface implemented by every approximate membership filter:
+// an inserted item is always found by lookup (no false negatives), while an
+// item that was never inserted is found with a small probability (false positive).
+// insert returns an error when the item could not be added (e.g., ErrOverCapacity).
+type Filter interface {
+	Lookuper
+	insert(item string) error
+}
+
+// Lookuper answers membership queries. It is all the screening code needs,
+// so read-only filters (Frozen, Cascade) can be used wherever a filter is queried.
+type Lookuper interface {
+	lookup(item string) bool
+}
+
+// DeletableFilter is a Filter that can also remove items, like the cuckoo filter
+type DeletableFilter interface {
+	Filter
+	delete(item string)
+}
+
+var _ DeletableFilter = (*Cuckoo)(nil)
+
+// checkFilter is the membership check of the conformance suite shared by all
+// filter types (see RunConformance).
+// It builds a filter for n items with newFilter and asserts that:
+//  1. every inserted item is found (no false negatives)
+//  2. the false positive rate measured over n items that were never inserted
+//     does not exceed maxFPRate
+//  3. a DeletableFilter is empty again once every inserted item is deleted
+//
+// Items are the decimal strings "0".."n-1" for inserted items and "n".."2n-1"
+// for the negative set, so runs are reproducible.
+func checkFilter(newFilter func(n uint) Filter, n uint, maxFPRate float64) error {
+	filter := newFilter(n)
+
+	for i := uint(0); i < n; i++ {
+		if err := filter.insert(strconv.FormatUint(uint64(i), 10)); err != nil {
+			return err
+		}
+	}
+
+	for i := uint(0); i < n; i++ {
+		item := strconv.FormatUint(uint64(i), 10)
+		if !filter.lookup(item) {
+			return fmt.Errorf("false negative for inserted item %q", item)
+		}
+	}
+
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if filter.lookup(strconv.FormatUint(uint64(i), 10)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / float64(n); rate > maxFPRate {
+		return fmt.Errorf("false positive rate %.4f above %.4f", rate, maxFPRate)
+	}
+
+	d, ok := filter.(DeletableFilter)
+	if !ok {
+		return nil
+	}
+	for i := uint(0); i < n; i++ {
+		d.delete(strconv.FormatUint(uint64(i), 10))
+	}
+	for i := uint(0); i < n; i++ {
+		item := strconv.FormatUint(uint64(i), 10)
+		if d.lookup(item) {
+			return fmt.Errorf("item %q still found after deleting every item", item)
+		}
+	}
+	return nil
+}
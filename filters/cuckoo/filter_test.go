@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cuckooFPBound is the false positive rate bound of a cuckoo filter built for
+// the rate e: 2b / 2^(8f) for fingerprints of f bytes
+func cuckooFPBound(e float64) float64 {
+	_, f := cuckooParams(1, e)
+	return 2 * float64(b) / math.Exp2(8*float64(f))
+}
+
+// FilterFactory describes a filter type for RunConformance
+type FilterFactory struct {
+	New       func(n uint) Filter // builds an empty filter for n items
+	MaxFPRate float64             // bound of the measured false positive rate
+	// Read decodes what WriteTo wrote, for filters that serialize
+	// (implement io.WriterTo); nil for the others
+	Read func(r io.Reader) (Filter, error)
+	// Concurrent is set for filters whose inserts are safe for concurrent use
+	Concurrent bool
+}
+
+// RunConformance is the conformance suite of the filter types: a new
+// backend passes it by adding its factory to TestFilterConformance (or
+// TestExperimentalFilterConformance). Its subtests check:
+//   - membership: checkFilter with 10000 items
+//   - round-trip: a serialized filter reads back with the same answers, for
+//     filters with a Read function
+//   - concurrency: lookups (and inserts, for Concurrent filters) from several
+//     goroutines, which the race detector checks under go test -race
+//
+// Filters implementing io.Closer are closed at the end of each subtest.
+func RunConformance(t *testing.T, f FilterFactory) {
+	build := func(t *testing.T, n uint) Filter {
+		filter := f.New(n)
+		if c, ok := filter.(io.Closer); ok {
+			t.Cleanup(func() {
+				if err := c.Close(); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		return filter
+	}
+	item := func(i int) string { return strconv.Itoa(i) }
+	const n = 2000
+
+	t.Run("membership", func(t *testing.T) {
+		if err := checkFilter(func(n uint) Filter { return build(t, n) }, 10000, f.MaxFPRate); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("round-trip", func(t *testing.T) {
+		filter := build(t, n)
+		w, ok := filter.(io.WriterTo)
+		if f.Read == nil || !ok {
+			t.Skip("the filter does not serialize")
+		}
+		for i := 0; i < n; i++ {
+			if err := filter.insert(item(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		read, err := f.Read(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c, ok := read.(io.Closer); ok {
+			defer c.Close()
+		}
+		for i := 0; i < 2*n; i++ {
+			if got, want := read.lookup(item(i)), filter.lookup(item(i)); got != want {
+				t.Fatalf("item %d: lookup %v after the round-trip, %v before", i, got, want)
+			}
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		filter := build(t, 4*n)
+		for i := 0; i < n; i++ {
+			if err := filter.insert(item(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var wg sync.WaitGroup
+		for w := 1; w <= 3; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					if f.Concurrent {
+						if err := filter.insert(item(w*n + i)); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+					if !filter.lookup(item(i)) {
+						t.Errorf("item %d not found during concurrent lookups", i)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if f.Concurrent {
+			for i := n; i < 4*n; i++ {
+				if !filter.lookup(item(i)) {
+					t.Fatalf("item %d inserted concurrently not found", i)
+				}
+			}
+		}
+	})
+}
+
+// TestFilterConformance runs RunConformance against every filter type of the
+// default build (see experimental_test.go for the others)
+func TestFilterConformance(t *testing.T) {
+	readCuckoo := func(opts ...Option) func(io.Reader) (Filter, error) {
+		return func(r io.Reader) (Filter, error) { return ReadCuckoo(r, opts...) }
+	}
+	key := []byte("conformance test key")
+	for _, tc := range []struct {
+		name string
+		FilterFactory
+	}{
+		{"cuckoo", FilterFactory{
+			New:       func(n uint) Filter { return NewCuckooFilter(n, 0.01) },
+			MaxFPRate: cuckooFPBound(0.01),
+			Read:      readCuckoo(),
+		}},
+		{"cuckoo-offheap", FilterFactory{
+			New:       func(n uint) Filter { return NewCuckooFilter(n, 0.01, WithOffHeap()) },
+			MaxFPRate: cuckooFPBound(0.01),
+			Read:      readCuckoo(WithOffHeap()),
+		}},
+		{"cuckoo-keyed", FilterFactory{
+			New:       func(n uint) Filter { return NewCuckooFilter(n, 0.01, WithKey(key)) },
+			MaxFPRate: cuckooFPBound(0.01),
+			Read:      readCuckoo(WithKey(key)),
+		}},
+		{"bloom", FilterFactory{
+			New:       func(n uint) Filter { return NewBlockedBloomFilter(n, 0.01) },
+			MaxFPRate: 0.015,
+		}},
+		{"rotating", FilterFactory{
+			New: func(n uint) Filter {
+				return NewRotating(time.Hour, 2, func() Filter { return NewCuckooFilter(n, 0.01) })
+			},
+			MaxFPRate:  cuckooFPBound(0.01),
+			Concurrent: true,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) { RunConformance(t, tc.FilterFactory) })
+	}
+}
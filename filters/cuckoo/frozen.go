@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// Frozen is an immutable cuckoo filter, for static lists that are built once
+// and then only queried. It has no insert or delete method, so mutating it is
+// a compile-time error, and it records no latency histograms: lookups do not
+// write anything, so any number of goroutines can query it concurrently
+// without locking.
+type Frozen struct {
+	c     *Cuckoo
+	pages []uint32 // checksums of the bucket pages, see Verify
+	stash uint32   // checksum of the victim stash
+
+	// Verify reads the whole bucket storage: Close waits for it, or it would
+	// read unmapped off-heap buckets
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Freeze returns an immutable copy of the filter.
+// The filter itself stays mutable and later changes to it do not affect the copy.
+func (c *Cuckoo) Freeze() *Frozen {
+	clone := c.Clone()
+	clone.latency = nil
+	return &Frozen{c: clone, pages: pageChecksums(clone.slots), stash: stashChecksum(clone.victims)}
+}
+
+// lookup needle in the frozen filter
+func (fz *Frozen) lookup(needle string) bool {
+	return fz.c.lookup(needle)
+}
+
+// lookupBatch looks up a batch of items (see Cuckoo.lookupBatch)
+func (fz *Frozen) lookupBatch(items []string) []bool {
+	return fz.c.lookupBatch(items)
+}
+
+// Range calls fn for every stored fingerprint (see Cuckoo.Range)
+func (fz *Frozen) Range(fn func(bucketIdx uint, fp []byte) bool) {
+	fz.c.Range(fn)
+}
+
+// LoadFactor returns the share of occupied slots of the filter
+func (fz *Frozen) LoadFactor() float64 {
+	return fz.c.LoadFactor()
+}
+
+// Thaw returns a mutable copy of the frozen filter, e.g. to build the next
+// version of a static list from the current one
+func (fz *Frozen) Thaw() *Cuckoo {
+	return fz.c.Clone()
+}
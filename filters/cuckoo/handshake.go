@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Filter exchange handshake.
+// Before two nodes exchange the contents of a filter, each sends a Hello
+// describing its copy: filter type, snapshot versions it reads, layout
+// (m, b, f), a commitment to its fingerprint key, the hash of its contents
+// and the snapshot codecs it reads (see compress.go). Peers whose filters
+// cannot be combined fail on the handshake, with the reason, instead of
+// applying each other's buckets:
+//   - another filter type: an error naming both
+//   - no common snapshot version: *ErrVersionMismatch
+//   - another layout, hash or key: *ParamMismatchError, as Compatible
+//
+// The key commitment is HMAC-SHA256(key, "cuckoo key commitment"): equal for
+// equal keys, and it reveals nothing of the key. Equal content hashes mean
+// the peers already hold the same fingerprints and nothing is transferred.
+// Hellos are exchanged as one JSON line each way.
+
+const filterTypeCuckoo = "cuckoo"
+
+// Hello describes a peer's copy of a filter
+type Hello struct {
+	Type          string   `json:"type"`
+	Versions      []uint32 `json:"versions"` // snapshot versions the peer reads
+	M             uint     `json:"m"`
+	B             uint     `json:"b"`
+	F             uint     `json:"f"`
+	KeyCommitment string   `json:"key_commitment,omitempty"` // hex, empty for a plain filter
+	Hash          uint32   `json:"hash,omitempty"`           // hash family ID, 0 for the default
+	Seed          uint64   `json:"seed,omitempty"`           // hash seed
+	ContentHash   string   `json:"content_hash"`             // hex SHA-256 of the buckets and stash
+	Count         uint     `json:"count"`
+	Codecs        []string `json:"codecs,omitempty"` // snapshot codecs the peer reads
+}
+
+// HandshakeResult is the outcome of a successful handshake
+type HandshakeResult struct {
+	Version uint32 // snapshot version to exchange
+	InSync  bool   // both peers hold the same fingerprints
+	Codec   string // snapshot codec to exchange
+}
+
+// Hello returns the description of the filter sent to peers
+func (c *Cuckoo) Hello() Hello {
+	h := Hello{Type: filterTypeCuckoo, M: c.m, B: c.b, F: c.f, Count: c.count, Codecs: SnapshotCodecs}
+	for v := uint32(1); v <= SnapshotVersion; v++ {
+		h.Versions = append(h.Versions, v)
+	}
+	family, seed := c.HashFamily()
+	h.Hash, h.Seed = family.ID, seed
+	if c.key != nil {
+		mac := hmac.New(sha256.New, c.key)
+		mac.Write([]byte("cuckoo key commitment"))
+		h.KeyCommitment = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	sum := sha256.New()
+	sum.Write(c.slots)
+	for _, e := range c.victims {
+		sum.Write(binary.LittleEndian.AppendUint64(nil, uint64(e.i)))
+		sum.Write(e.f)
+	}
+	h.ContentHash = hex.EncodeToString(sum.Sum(nil))
+	return h
+}
+
+// Negotiate checks the hello of a peer against the local one and returns
+// the snapshot version to use (see above for the errors)
+func Negotiate(local, peer Hello) (HandshakeResult, error) {
+	if peer.Type != local.Type {
+		return HandshakeResult{}, fmt.Errorf("incompatible filters: peer has a %q filter, local is %q", peer.Type, local.Type)
+	}
+	version, err := NegotiateVersion(peer.Versions)
+	if err != nil {
+		return HandshakeResult{}, err
+	}
+	switch {
+	case local.M != peer.M:
+		return HandshakeResult{}, &ParamMismatchError{Param: "m", Got: local.M, Want: peer.M}
+	case local.B != peer.B:
+		return HandshakeResult{}, &ParamMismatchError{Param: "b", Got: local.B, Want: peer.B}
+	case local.F != peer.F:
+		return HandshakeResult{}, &ParamMismatchError{Param: "f", Got: local.F, Want: peer.F}
+	case helloHash(local) != helloHash(peer):
+		return HandshakeResult{}, &ParamMismatchError{Param: "hash", Got: uint(helloHash(local)), Want: uint(helloHash(peer))}
+	case local.Seed != peer.Seed:
+		return HandshakeResult{}, &ParamMismatchError{Param: "seed", Got: uint(local.Seed), Want: uint(peer.Seed)}
+	case !hmac.Equal([]byte(local.KeyCommitment), []byte(peer.KeyCommitment)):
+		return HandshakeResult{}, &ParamMismatchError{Param: "key"}
+	}
+	return HandshakeResult{
+		Version: version,
+		InSync:  local.ContentHash == peer.ContentHash,
+		Codec:   NegotiateCodec(peer.Codecs),
+	}, nil
+}
+
+// helloHash returns the hash family ID of a hello, resolving the default
+// of peers that do not send it
+func helloHash(h Hello) uint32 {
+	switch {
+	case h.Hash != 0:
+		return h.Hash
+	case h.KeyCommitment != "":
+		return hashHMACSHA256.ID
+	}
+	return HashSHA1.ID
+}
+
+// Handshake sends the hello of the filter to the peer over rw, reads the
+// peer's, and negotiates. Both peers run it; the peer's hello is returned
+// for logging.
+func (c *Cuckoo) Handshake(rw io.ReadWriter) (HandshakeResult, Hello, error) {
+	local := c.Hello()
+	line, err := json.Marshal(local)
+	if err != nil {
+		return HandshakeResult{}, Hello{}, err
+	}
+	// both peers write first: write while reading, so an unbuffered
+	// transport cannot deadlock
+	written := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(append(line, '\n'))
+		written <- err
+	}()
+	reply, err := readLine(rw, maxHelloBytes)
+	if werr := <-written; werr != nil {
+		return HandshakeResult{}, Hello{}, fmt.Errorf("handshake: %w", werr)
+	}
+	if err != nil {
+		return HandshakeResult{}, Hello{}, fmt.Errorf("handshake: %w", err)
+	}
+	var peer Hello
+	if err := json.Unmarshal(reply, &peer); err != nil {
+		return HandshakeResult{}, Hello{}, fmt.Errorf("handshake: malformed peer hello: %w", err)
+	}
+	res, err := Negotiate(local, peer)
+	return res, peer, err
+}
+
+// maxHelloBytes bounds the size of a peer hello
+const maxHelloBytes = 4096
+
+// readLine reads up to a newline, one byte at a time so nothing past it is
+// consumed: the snapshot follows the hello on the same stream
+func readLine(r io.Reader, limit int) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < limit {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			return line, nil
+		}
+		line = append(line, b[0])
+	}
+	return nil, fmt.Errorf("line longer than %d bytes", limit)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// handshake runs the handshake of two filters over a pipe
+func handshake(t *testing.T, a, b *Cuckoo) (HandshakeResult, error) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	type result struct {
+		res HandshakeResult
+		err error
+	}
+	peer := make(chan result, 1)
+	go func() {
+		res, _, err := b.Handshake(cb)
+		peer <- result{res, err}
+	}()
+	res, hello, err := a.Handshake(ca)
+	other := <-peer
+	if (err == nil) != (other.err == nil) || res != other.res {
+		t.Errorf("peers disagree: %+v, %v and %+v, %v", res, err, other.res, other.err)
+	}
+	if err == nil && hello.ContentHash != b.Hello().ContentHash {
+		t.Error("returned hello is not the peer's")
+	}
+	return res, err
+}
+
+func TestHandshake(t *testing.T) {
+	a := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 100; i++ {
+		a.insert(strconv.Itoa(i))
+	}
+	res, err := handshake(t, a, a.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != SnapshotVersion || !res.InSync || res.Codec != CodecFlate {
+		t.Errorf("handshake of equal filters = %+v", res)
+	}
+	b := a.Clone()
+	b.insert("one more")
+	if res, err := handshake(t, a, b); err != nil || res.InSync {
+		t.Errorf("handshake of different contents = %+v, %v", res, err)
+	}
+
+	var mismatch *ParamMismatchError
+	for _, tc := range []struct {
+		name  string
+		other *Cuckoo
+		param string
+	}{
+		{"layout", NewCuckooFilter(100000, 0.01), "m"},
+		{"fingerprints", NewCuckooFilter(1000, 1e-9), "f"},
+		{"key", NewCuckooFilter(1000, 0.01, WithKey([]byte("k"))), "hash"},
+		{"hash", NewCuckooFilter(1000, 0.01, WithHashFamily(HashSHA256, 0)), "hash"},
+		{"seed", NewCuckooFilter(1000, 0.01, WithHashFamily(HashSHA1, 42)), "seed"},
+	} {
+		_, err := handshake(t, a, tc.other)
+		if !errors.As(err, &mismatch) || mismatch.Param != tc.param {
+			t.Errorf("%s: handshake = %v, want a %s mismatch", tc.name, err, tc.param)
+		}
+	}
+	k1 := NewCuckooFilter(1000, 0.01, WithKey([]byte("k1")))
+	k2 := NewCuckooFilter(1000, 0.01, WithKey([]byte("k2")))
+	if _, err := handshake(t, k1, k2); !errors.As(err, &mismatch) || mismatch.Param != "key" {
+		t.Errorf("different keys: handshake = %v", err)
+	}
+	if _, err := handshake(t, k1, NewCuckooFilter(1000, 0.01, WithKey([]byte("k1")))); err != nil {
+		t.Errorf("same key: %v", err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	local := NewCuckooFilter(1000, 0.01).Hello()
+
+	peer := local
+	peer.Type = "bloom"
+	if _, err := Negotiate(local, peer); err == nil {
+		t.Error("filter types negotiated")
+	}
+	peer = local
+	peer.Versions = []uint32{SnapshotVersion + 1}
+	var vm *ErrVersionMismatch
+	if _, err := Negotiate(local, peer); !errors.As(err, &vm) {
+		t.Errorf("no common version: %v", err)
+	}
+	// an older peer: its latest version, no hash field, no codecs
+	peer = local
+	peer.Versions, peer.Hash, peer.Codecs = []uint32{1, 2}, 0, nil
+	res, err := Negotiate(local, peer)
+	if err != nil || res.Version != 2 || res.Codec != CodecNone {
+		t.Errorf("older peer: %+v, %v", res, err)
+	}
+
+	if helloHash(Hello{}) != HashSHA1.ID || helloHash(Hello{KeyCommitment: "ab"}) != hashHMACSHA256.ID || helloHash(Hello{Hash: 3}) != 3 {
+		t.Error("helloHash defaults")
+	}
+}
+
+func TestHandshakeMalformed(t *testing.T) {
+	for _, reply := range []string{"not json\n", "{\"type\": \"cuckoo\"", string(bytes.Repeat([]byte("x"), maxHelloBytes+1))} {
+		ca, cb := net.Pipe()
+		go func() {
+			buf := make([]byte, maxHelloBytes)
+			cb.Read(buf) // the local hello
+			cb.Write([]byte(reply))
+			cb.Close()
+		}()
+		if _, _, err := NewCuckooFilter(100, 0.01).Handshake(ca); err == nil {
+			t.Errorf("peer hello %.20q accepted", reply)
+		}
+		ca.Close()
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	r := bytes.NewReader([]byte("hello\nsnapshot"))
+	line, err := readLine(r, 10)
+	if err != nil || string(line) != "hello" {
+		t.Errorf("readLine = %q, %v", line, err)
+	}
+	if r.Len() != len("snapshot") {
+		t.Errorf("readLine consumed %d bytes past the line", len("snapshot")-r.Len())
+	}
+	if _, err := readLine(bytes.NewReader([]byte("too long\n")), 4); err == nil {
+		t.Error("line over the limit read")
+	}
+}
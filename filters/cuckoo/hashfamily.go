@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Hash families.
+// The buckets and fingerprint of an item depend on the hash that produced
+// them: a filter queried with another hash (or another seed) answers
+// "absent" for its items, silently. The family and seed of a filter are
+// recorded in its snapshots (version 4) and manifests, and resolved from a
+// registry by ID at load time: a filter loaded without WithHashFamily takes
+// the one recorded, and one loaded with a different family or seed fails
+// with a *ParamMismatchError ("hash" or "seed").
+// A non-zero seed is hashed before the item, so filters of the same family
+// with different seeds place items independently. A keyed filter (WithKey)
+// always hashes with HMAC-SHA256 under its key, recorded as "hmac-sha256".
+// The alternate bucket of a fingerprint is derived with SHA1 in every
+// family: it depends on the fingerprint only, not on the item.
+
+// HashFamily is a hash of items registered under an ID
+type HashFamily struct {
+	ID   uint32
+	Name string
+	sum  func(data []byte) []byte // nil for keyed families
+}
+
+var (
+	// HashSHA1 is the default hash of plain filters
+	HashSHA1 = &HashFamily{ID: 1, Name: "sha1", sum: hash}
+	// HashSHA256 hashes items with SHA-256
+	HashSHA256 = &HashFamily{ID: 3, Name: "sha256", sum: func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}}
+
+	// hashHMACSHA256 is the hash of keyed filters, set by WithKey
+	hashHMACSHA256 = &HashFamily{ID: 2, Name: "hmac-sha256"}
+)
+
+var (
+	hashFamiliesMu sync.RWMutex
+	hashFamilies   = map[uint32]*HashFamily{
+		HashSHA1.ID:       HashSHA1,
+		hashHMACSHA256.ID: hashHMACSHA256,
+		HashSHA256.ID:     HashSHA256,
+	}
+)
+
+// RegisterHashFamily registers a hash of items under an ID and a name, both
+// unique, so snapshots recording the ID can be loaded. sum must return at
+// least 20 bytes, and the same bytes for the same input on every node.
+func RegisterHashFamily(id uint32, name string, sum func(data []byte) []byte) (*HashFamily, error) {
+	hashFamiliesMu.Lock()
+	defer hashFamiliesMu.Unlock()
+	for _, h := range hashFamilies {
+		if h.ID == id || h.Name == name {
+			return nil, fmt.Errorf("hash family %d %q already registered as %d %q", id, name, h.ID, h.Name)
+		}
+	}
+	h := &HashFamily{ID: id, Name: name, sum: sum}
+	hashFamilies[id] = h
+	return h, nil
+}
+
+// LookupHashFamily returns the hash family registered under id
+func LookupHashFamily(id uint32) (*HashFamily, bool) {
+	hashFamiliesMu.RLock()
+	defer hashFamiliesMu.RUnlock()
+	h, ok := hashFamilies[id]
+	return h, ok
+}
+
+// UnknownHashError is returned when loading a filter hashed with a family
+// that is not registered
+type UnknownHashError struct {
+	ID uint32
+}
+
+func (e *UnknownHashError) Error() string {
+	return fmt.Sprintf("filter hashed with unknown hash family %d", e.ID)
+}
+
+// WithHashFamily hashes the items with family h, seeded with seed (0 for
+// none), instead of SHA1. It has no effect on keyed filters (see WithKey).
+func WithHashFamily(h *HashFamily, seed uint64) Option {
+	return func(c *Cuckoo) {
+		c.family, c.seed = h, seed
+	}
+}
+
+// HashFamily returns the hash family and seed of the filter
+func (c *Cuckoo) HashFamily() (*HashFamily, uint64) {
+	switch {
+	case c.key != nil:
+		return hashHMACSHA256, c.seed
+	case c.family == nil:
+		return HashSHA1, c.seed
+	}
+	return c.family, c.seed
+}
+
+// hashFamily is HashFamily without the seed
+func (c *Cuckoo) hashFamily() *HashFamily {
+	h, _ := c.HashFamily()
+	return h
+}
+
+// hashItem returns the hash of an item: keyed if the filter has a key,
+// seeded if it has a seed
+func (c *Cuckoo) hashItem(data []byte) []byte {
+	if c.seed != 0 {
+		data = append(binary.LittleEndian.AppendUint64(nil, c.seed), data...)
+	}
+	if c.key != nil {
+		mac := hmac.New(sha256.New, c.key)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	if c.family == nil {
+		return hash(data)
+	}
+	return c.family.sum(data)
+}
+
+// adoptHash checks the hash family and seed recorded for a loaded filter
+// (id 0 for the default of the keyed flag) against the options it is
+// loaded with, and sets them if the options leave them unset
+func (c *Cuckoo) adoptHash(id uint32, seed uint64) error {
+	recorded := HashSHA1
+	if c.key != nil {
+		recorded = hashHMACSHA256
+	}
+	if id != 0 {
+		var ok bool
+		if recorded, ok = LookupHashFamily(id); !ok {
+			return &UnknownHashError{ID: id}
+		}
+	}
+	if (recorded == hashHMACSHA256) != (c.key != nil) {
+		return fmt.Errorf("hash family %s does not match the key of the filter", recorded.Name)
+	}
+	if c.family == nil && c.seed == 0 {
+		if recorded != hashHMACSHA256 {
+			c.family = recorded
+		}
+		c.seed = seed
+		return nil
+	}
+	if got, _ := c.HashFamily(); got != recorded {
+		return &ParamMismatchError{Param: "hash", Got: uint(got.ID), Want: uint(recorded.ID)}
+	}
+	if c.seed != seed {
+		return &ParamMismatchError{Param: "seed", Got: uint(c.seed), Want: uint(seed)}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// IP reputation filter.
+// Threat-intel feeds list addresses and networks (CIDR prefixes) of IPs
+// seen attacking wallets. Keys are canonical prefixes, "<network>/<bits>":
+// an address is its /32 (IPv4) or /128 (IPv6), IPv4-mapped IPv6 addresses
+// are IPv4, and zones are dropped, so every spelling of an address gives
+// the same key.
+// A lookup checks the address at each prefix length of ipv4Lengths or
+// ipv6Lengths. A feed entry whose length is not one of them is split into
+// the prefixes of the next longer length (a /20 into sixteen /24), which
+// match exactly the same addresses.
+// Attackers rotate addresses within their network, so entries can also be
+// widened at insert time to their covering prefix (e.g., every listed
+// address blocks its /24): this blocks more than the feed lists, by choice.
+
+var (
+	ipv4Lengths = []int{32, 24, 16}
+	ipv6Lengths = []int{128, 64, 48, 32}
+)
+
+// maxPrefixSplit bounds the number of keys a feed entry is split into
+const maxPrefixSplit = 256
+
+// IPFilter answers IsBadIP for the entries of a threat-intel feed.
+// It is safe for concurrent use.
+type IPFilter struct {
+	mu     sync.RWMutex
+	filter Filter
+	n      uint
+	e      float64
+	widen4 int // IPv4 entries longer than this are widened to it; 0 for none
+	widen6 int
+}
+
+var _ Lookuper = (*IPFilter)(nil)
+
+// NewIPFilter returns a filter for n keys with the false positive rate e.
+// widen4 and widen6, if not zero, are the prefix lengths IPv4 and IPv6
+// entries are widened to; they must be lengths of ipv4Lengths and ipv6Lengths.
+func NewIPFilter(n uint, e float64, widen4, widen6 int) (*IPFilter, error) {
+	if widen4 != 0 && !containsInt(ipv4Lengths, widen4) {
+		return nil, fmt.Errorf("cannot widen IPv4 entries to /%d, lengths are %v", widen4, ipv4Lengths)
+	}
+	if widen6 != 0 && !containsInt(ipv6Lengths, widen6) {
+		return nil, fmt.Errorf("cannot widen IPv6 entries to /%d, lengths are %v", widen6, ipv6Lengths)
+	}
+	return &IPFilter{filter: NewCuckooFilter(n, e), n: n, e: e, widen4: widen4, widen6: widen6}, nil
+}
+
+func containsInt(s []int, x int) bool {
+	for _, y := range s {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix canonicalizes an address or a CIDR prefix
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	a = a.Unmap().WithZone("")
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// lengths returns the lookup prefix lengths of an address family
+func lengths(a netip.Addr) []int {
+	if a.Is4() {
+		return ipv4Lengths
+	}
+	return ipv6Lengths
+}
+
+// keys returns the keys of a feed entry: the prefixes of lookup lengths
+// covering exactly its addresses, after widening
+func (f *IPFilter) keys(p netip.Prefix) ([]string, error) {
+	widen := f.widen6
+	if p.Addr().Is4() {
+		widen = f.widen4
+	}
+	if widen != 0 && p.Bits() > widen {
+		p = netip.PrefixFrom(p.Addr(), widen).Masked()
+	}
+
+	// the shortest lookup length not shorter than the prefix
+	target := -1
+	for _, l := range lengths(p.Addr()) {
+		if l >= p.Bits() {
+			target = l
+		}
+	}
+	if target < 0 {
+		return nil, fmt.Errorf("prefix %v is shorter than every lookup length", p)
+	}
+	if target-p.Bits() > 0 && 1<<(target-p.Bits()) > maxPrefixSplit {
+		return nil, fmt.Errorf("prefix %v splits into more than %d /%d prefixes", p, maxPrefixSplit, target)
+	}
+
+	var keys []string
+	for a := p.Addr(); a.IsValid() && p.Contains(a); {
+		sub := netip.PrefixFrom(a, target)
+		keys = append(keys, sub.String())
+		a = lastAddr(sub).Next() // invalid past the last address
+	}
+	return keys, nil
+}
+
+// lastAddr returns the last address of a masked prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// Add adds feed entries, addresses or CIDR prefixes
+func (f *IPFilter) Add(entries ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addTo(f.filter, entries)
+}
+
+// addTo inserts the keys of the entries in filter
+func (f *IPFilter) addTo(filter Filter, entries []string) error {
+	for _, entry := range entries {
+		p, err := parsePrefix(entry)
+		if err != nil {
+			return err
+		}
+		keys, err := f.keys(p)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := filter.insert(k); err != nil {
+				return fmt.Errorf("%s: %w", entry, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Refresh replaces the entries with those of a feed, one address or CIDR
+// prefix per line ('#' starts a comment). The new filter is built aside and
+// swapped in: lookups see the old list until the new one is complete, and
+// keep it if the feed is malformed.
+func (f *IPFilter) Refresh(r io.Reader) (int, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	filter := NewCuckooFilter(f.n, f.e)
+	if err := f.addTo(filter, entries); err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	f.filter = filter
+	f.mu.Unlock()
+	return len(entries), nil
+}
+
+// IsBadIP returns true if the address is in a listed network.
+// It returns an error if ip is not an address.
+func (f *IPFilter) IsBadIP(ip string) (bool, error) {
+	a, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false, err
+	}
+	a = a.Unmap().WithZone("")
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, l := range lengths(a) {
+		p, _ := a.Prefix(l)
+		if f.filter.lookup(p.String()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookup is IsBadIP, for the screener; an invalid address is not found
+func (f *IPFilter) lookup(ip string) bool {
+	bad, _ := f.IsBadIP(ip)
+	return bad
+}
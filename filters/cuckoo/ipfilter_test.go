@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := NewIPFilter(10000, 0.0001, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("203.0.113.7", "198.51.100.0/20", "2001:db8:1::/48", "fe80::1%eth0"); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"203.0.113.7":          true,
+		"::ffff:203.0.113.7":   true, // IPv4-mapped
+		" 203.0.113.7 ":        true,
+		"203.0.113.8":          false,
+		"198.51.100.1":         true, // first /24 of the /20
+		"198.51.111.254":       true, // last one
+		"198.51.112.1":         false,
+		"2001:db8:1:ffff::1":   true,
+		"2001:db8:2::1":        false,
+		"fe80::1":              true, // zone dropped
+		"fe80::1%eth1":         true,
+		"2001:db8:1::7%wlan0":  true,
+		"100.64.0.1":           false,
+		"::ffff:198.51.100.20": true,
+	} {
+		got, err := f.IsBadIP(ip)
+		if err != nil {
+			t.Errorf("IsBadIP(%q): %v", ip, err)
+		} else if got != want {
+			t.Errorf("IsBadIP(%q) = %v, want %v", ip, got, want)
+		}
+	}
+	if _, err := f.IsBadIP("not an ip"); err == nil {
+		t.Error("invalid address accepted")
+	}
+	if f.lookup("not an ip") {
+		t.Error("invalid address found")
+	}
+
+	for _, entry := range []string{"10.0.0.0/7", "2001:db8::/20", "::ffff:10.0.0.0/100", "garbage", "10.0.0.0/33"} {
+		if err := f.Add(entry); err == nil {
+			t.Errorf("Add(%q) accepted", entry)
+		}
+	}
+	// a /8 splits into 256 /16, the most allowed
+	if err := f.Add("10.0.0.0/8"); err != nil {
+		t.Error(err)
+	}
+	if bad, _ := f.IsBadIP("10.200.3.4"); !bad {
+		t.Error("address of a listed /8 not found")
+	}
+}
+
+func TestIPFilterWiden(t *testing.T) {
+	if _, err := NewIPFilter(100, 0.01, 20, 0); err == nil {
+		t.Error("widening to /20 accepted")
+	}
+	if _, err := NewIPFilter(100, 0.01, 0, 56); err == nil {
+		t.Error("widening to /56 accepted")
+	}
+	f, err := NewIPFilter(1000, 0.0001, 24, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("203.0.113.7", "2001:db8:1:2::3", "198.51.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"203.0.113.200":   true,
+		"203.0.114.1":     false,
+		"2001:db8:1:9::1": true,
+		"2001:db8:2::1":   false,
+		"198.51.7.7":      true, // shorter than the widening, unchanged
+	} {
+		if got, _ := f.IsBadIP(ip); got != want {
+			t.Errorf("IsBadIP(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestIPFilterRefresh(t *testing.T) {
+	f, err := NewIPFilter(1000, 0.0001, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := f.Refresh(strings.NewReader("# feed of 2026-10-15\n203.0.113.7\n\n198.51.100.0/24 # scanner\n"))
+	if err != nil || n != 2 {
+		t.Fatalf("Refresh = %d, %v", n, err)
+	}
+	// a malformed feed keeps the current list
+	if _, err := f.Refresh(strings.NewReader("192.0.2.1\nnot an ip\n")); err == nil {
+		t.Error("malformed feed accepted")
+	}
+	for ip, want := range map[string]bool{"203.0.113.7": true, "198.51.100.9": true, "192.0.2.1": false} {
+		if got, _ := f.IsBadIP(ip); got != want {
+			t.Errorf("after a malformed feed, IsBadIP(%q) = %v", ip, got)
+		}
+	}
+	// a new feed replaces the list
+	if _, err := f.Refresh(strings.NewReader("192.0.2.1\n")); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"203.0.113.7": false, "192.0.2.1": true} {
+		if got, _ := f.IsBadIP(ip); got != want {
+			t.Errorf("after a refresh, IsBadIP(%q) = %v", ip, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Journal records which items were inserted into a deletable filter at each
+// block height, so a block reorganization can undo them.
+// A filter only stores fingerprints, so it cannot tell which of them came
+// from the orphaned blocks: the journal keeps the items themselves until
+// their block is final (see Prune).
+// Undoing an insert deletes one copy of the item's fingerprint, so every
+// insert must store one: a filter built WithIdempotentInsert skips the
+// items it already finds (or finds by a false positive), and rolling back
+// the insert of the item they collide with would then remove them.
+// It is safe for concurrent use, as long as the filter is only modified
+// through the journal.
+type Journal struct {
+	mu     sync.RWMutex
+	filter DeletableFilter
+	blocks map[uint64][]string // inserted items by block height
+}
+
+var _ Lookuper = (*Journal)(nil)
+
+// NewJournal wraps a deletable filter (e.g., a cuckoo filter of txids or
+// deposit addresses). It refuses a cuckoo filter built WithIdempotentInsert.
+func NewJournal(filter DeletableFilter) (*Journal, error) {
+	if c, ok := filter.(*Cuckoo); ok && c.idempotent {
+		return nil, errors.New("journal of a filter with idempotent inserts")
+	}
+	return &Journal{
+		filter: filter,
+		blocks: make(map[uint64][]string),
+	}, nil
+}
+
+// InsertAt inserts the item in the filter and records it under the block height.
+// Nothing is recorded if the filter refuses the item.
+func (j *Journal) InsertAt(height uint64, item string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.filter.insert(item); err != nil {
+		return err
+	}
+	j.blocks[height] = append(j.blocks[height], item)
+	return nil
+}
+
+// lookup queries the filter
+func (j *Journal) lookup(item string) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.filter.lookup(item)
+}
+
+// RollbackToHeight deletes from the filter every item inserted at a height above h,
+// leaving the filter as it was once block h was applied
+func (j *Journal) RollbackToHeight(h uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for height, items := range j.blocks {
+		if height <= h {
+			continue
+		}
+		for _, item := range items {
+			j.filter.delete(item)
+		}
+		delete(j.blocks, height)
+	}
+}
+
+// Prune forgets the items of every block at or below the finalized height.
+// Those blocks can no longer be reorganized, so their items stay in the filter
+// for good and the journal does not need to grow with the chain.
+func (j *Journal) Prune(finalized uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for height := range j.blocks {
+		if height <= finalized {
+			delete(j.blocks, height)
+		}
+	}
+}
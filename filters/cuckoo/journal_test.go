@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	j, err := NewJournal(NewCuckooFilter(1000, 0.001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h := uint64(100); h < 105; h++ {
+		for i := 0; i < 10; i++ {
+			if err := j.InsertAt(h, strconv.Itoa(int(h)*100+i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the same item in an orphaned block and in a final one
+	if err := j.InsertAt(101, "dup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.InsertAt(104, "dup"); err != nil {
+		t.Fatal(err)
+	}
+
+	j.RollbackToHeight(102)
+	for h := 100; h <= 102; h++ {
+		for i := 0; i < 10; i++ {
+			if item := strconv.Itoa(h*100 + i); !j.lookup(item) {
+				t.Errorf("item %s of block %d lost by the rollback", item, h)
+			}
+		}
+	}
+	if !j.lookup("dup") {
+		t.Error("item inserted below the rollback height lost with its copy above")
+	}
+
+	// pruned blocks are final: a deeper rollback leaves their items
+	j.Prune(101)
+	j.RollbackToHeight(99)
+	for i := 0; i < 10; i++ {
+		if !j.lookup(strconv.Itoa(100*100 + i)) {
+			t.Fatalf("item of a pruned block deleted by a rollback")
+		}
+	}
+	if !j.lookup("dup") {
+		t.Error("item of a pruned block deleted by a rollback")
+	}
+	if j.lookup(strconv.Itoa(102 * 100)) {
+		t.Error("item of an orphaned block still found")
+	}
+
+	if _, err := NewJournal(NewCuckooFilter(1000, 0.001, WithIdempotentInsert())); err == nil {
+		t.Error("NewJournal accepted a filter with idempotent inserts")
+	}
+}
+
+func TestJournalConcurrent(t *testing.T) {
+	j, err := NewJournal(NewCuckooFilter(10000, 0.001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				item := strconv.Itoa(w*1000 + i)
+				if err := j.InsertAt(uint64(i), item); err != nil {
+					t.Error(err)
+					return
+				}
+				if !j.lookup(item) {
+					t.Errorf("item %s not found", item)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for h := uint64(0); h < 500; h += 50 {
+			j.Prune(h)
+		}
+	}()
+	wg.Wait()
+	j.RollbackToHeight(0)
+	for w := 0; w < 4; w++ {
+		if !j.lookup(strconv.Itoa(w * 1000)) {
+			t.Errorf("item of block 0 lost")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Operation identifies a filter operation for the latency histograms
+type Operation int
+
+const (
+	OpInsert Operation = iota
+	OpLookup
+	OpDelete
+	numOperations
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpInsert:
+		return "insert"
+	case OpLookup:
+		return "lookup"
+	case OpDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Each power of two of nanoseconds is split into 2^subBucketBits linear
+// sub-buckets (H2-style log-linear histogram), so the relative error of a
+// reported quantile is at most 1/2^subBucketBits = 25% while the whole
+// range of a 64-bit duration fits in 256 counters.
+const subBucketBits = 2
+
+const histogramBuckets = 64 << subBucketBits
+
+// latencyHistogram counts operation latencies in log-linear buckets.
+// The counters are atomic: concurrent lookups (and the workers of a batch)
+// record into the same histogram.
+type latencyHistogram struct {
+	counts [histogramBuckets]atomic.Uint64
+}
+
+// bucketOf returns the histogram bucket of a latency of v nanoseconds.
+// Values below 2^subBucketBits get one bucket each; larger values keep
+// their top subBucketBits+1 bits: the position of the highest bit selects
+// the power of two and the bits below it select the linear sub-bucket.
+func bucketOf(v uint64) int {
+	if v < 1<<subBucketBits {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return (shift+1)<<subBucketBits + int(v>>shift) - 1<<subBucketBits
+}
+
+// bucketLowerBound returns the smallest latency (in nanoseconds) counted in bucket i
+func bucketLowerBound(i int) uint64 {
+	if i < 1<<subBucketBits {
+		return uint64(i)
+	}
+	shift := i>>subBucketBits - 1
+	return uint64(1<<subBucketBits+i&(1<<subBucketBits-1)) << shift
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.observeN(d, 1)
+}
+
+// observeN records n operations of latency d each
+func (h *latencyHistogram) observeN(d time.Duration, n uint64) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketOf(uint64(d))].Add(n)
+}
+
+// snapshot returns the counts of the histogram. Operations recorded while it
+// runs may be missing, but the counts are never torn.
+func (h *latencyHistogram) snapshot() (counts [histogramBuckets]uint64, total uint64) {
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	return counts, total
+}
+
+// quantile returns an upper bound of the q-quantile (0 <= q <= 1) of the
+// observed latencies, or 0 if nothing was observed
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	counts, total := h.snapshot()
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(total))
+	if rank == 0 {
+		rank = 1
+	}
+	seen := uint64(0)
+	for i, n := range counts {
+		seen += n
+		if seen >= rank {
+			if i == histogramBuckets-1 {
+				return time.Duration(1<<63 - 1)
+			}
+			return time.Duration(bucketLowerBound(i+1) - 1)
+		}
+	}
+	return 0
+}
+
+// latencyStats holds one histogram per operation
+type latencyStats [numOperations]latencyHistogram
+
+// clone returns a copy of the histograms
+func (s *latencyStats) clone() *latencyStats {
+	clone := new(latencyStats)
+	for op := range s {
+		counts, _ := s[op].snapshot()
+		for i, n := range counts {
+			clone[op].counts[i].Store(n)
+		}
+	}
+	return clone
+}
+
+// EnableLatencyHistograms starts recording the latency of every insert,
+// lookup and delete. Recording is off by default so the hot path does not
+// pay for the clock reads. Calling it again resets the histograms.
+func (c *Cuckoo) EnableLatencyHistograms() {
+	c.latency = &latencyStats{}
+}
+
+// LatencyQuantile returns an upper bound of the q-quantile (e.g., 0.99 for P99)
+// of the latencies recorded for op, or 0 if histograms are not enabled
+func (c *Cuckoo) LatencyQuantile(op Operation, q float64) time.Duration {
+	if c.latency == nil || op < 0 || op >= numOperations {
+		return 0
+	}
+	return c.latency[op].quantile(q)
+}
+
+// observe records the time elapsed since start for op.
+// It is meant to be deferred at the start of an operation:
+//
+//	if c.latency != nil {
+//		defer c.observe(OpLookup, time.Now())
+//	}
+func (c *Cuckoo) observe(op Operation, start time.Time) {
+	c.latency[op].observe(time.Since(start))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 3, 4, 5, 7, 8, 100, 1000, 123456789, 1<<63 - 1} {
+		i := bucketOf(v)
+		if lo := bucketLowerBound(i); lo > v {
+			t.Errorf("%d in bucket %d starting at %d", v, i, lo)
+		}
+		if i+1 < histogramBuckets && bucketLowerBound(i+1) <= v {
+			t.Errorf("%d in bucket %d, but bucket %d starts at %d", v, i, i+1, bucketLowerBound(i+1))
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h latencyHistogram
+	if q := h.quantile(0.5); q != 0 {
+		t.Errorf("quantile of an empty histogram = %v", q)
+	}
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Microsecond)
+	}
+	h.observeN(time.Second, 10)
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 50 * time.Microsecond}, {0.9, 100 * time.Microsecond}, {0.99, time.Second}} {
+		got := h.quantile(tc.q)
+		// an upper bound within the relative error of the buckets
+		if got < tc.want || float64(got) > 1.25*float64(tc.want) {
+			t.Errorf("quantile(%v) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestLatencyConcurrent(t *testing.T) {
+	c := NewCuckooFilter(100000, 0.01, WithBatchParallelism(100, 4))
+	c.EnableLatencyHistograms()
+	items := make([]string, 1000)
+	for i := range items {
+		items[i] = strconv.Itoa(i)
+	}
+	if err := c.insertBatch(items); err != nil {
+		t.Fatal(err)
+	}
+
+	// lookups, single and batched, are safe for concurrent use, histograms included
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, found := range c.lookupBatch(items) {
+				if !found {
+					t.Error("item not found")
+					return
+				}
+			}
+			for _, item := range items[:100] {
+				c.lookup(item)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, lookups := c.latency[OpLookup].snapshot()
+	if want := uint64(4 * (len(items) + 100)); lookups != want {
+		t.Errorf("%d lookups recorded, want %d", lookups, want)
+	}
+	if _, inserts := c.latency[OpInsert].snapshot(); inserts != uint64(len(items)) {
+		t.Errorf("%d inserts recorded, want %d", inserts, len(items))
+	}
+	if c.LatencyQuantile(OpLookup, 0.5) == 0 {
+		t.Error("no lookup latency")
+	}
+
+	clone := c.Clone()
+	clone.lookup("0")
+	if _, n := c.latency[OpLookup].snapshot(); n != lookups {
+		t.Error("lookup of a clone recorded in the original")
+	}
+	if _, n := clone.latency[OpLookup].snapshot(); n != lookups+1 {
+		t.Errorf("clone has %d lookups recorded, want %d", n, lookups+1)
+	}
+}
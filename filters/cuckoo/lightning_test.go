@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestInvoiceVerdictBlocked(t *testing.T) {
+	f := NewCuckooFilter(100, 0.01)
+	f.insert("blocked-node")
+	ok := NewScreener()
+	ok.Add("lightning", f, 0.01, MapSet{"blocked-node": {}})
+	failing := NewScreener()
+	failing.Add("lightning", f, 0.01, failingSet{})
+
+	for _, tc := range []struct {
+		name string
+		v    InvoiceVerdict
+		want bool
+	}{
+		{"clean", InvoiceVerdict{Payee: ok.Check("node"), PaymentHash: ok.Check("hash")}, false},
+		{"payee", InvoiceVerdict{Payee: ok.Check("blocked-node"), PaymentHash: ok.Check("hash")}, true},
+		{"route hint", InvoiceVerdict{Payee: ok.Check("node"), PaymentHash: ok.Check("hash"),
+			RouteHints: []Verdict{ok.Check("hop"), ok.Check("blocked-node")}}, true},
+		{"payee error", InvoiceVerdict{Payee: failing.Check("blocked-node"), PaymentHash: ok.Check("hash")}, true},
+		{"route hint error", InvoiceVerdict{Payee: ok.Check("node"), PaymentHash: ok.Check("hash"),
+			RouteHints: []Verdict{failing.Check("blocked-node")}}, true},
+	} {
+		if got := tc.v.Blocked(); got != tc.want {
+			t.Errorf("%s: Blocked() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
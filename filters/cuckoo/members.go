@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Export and import of the members of an exact set, so compliance can hand
+// auditors the actual list behind a screening filter, and the list can be
+// loaded back to rebuild the filter and its exact set.
+// Two formats are supported:
+//   - csv: a "member" header line, then one member per line
+//   - jsonl: one {"member": "..."} object per line
+// Members are written in sorted order, so exports of the same list are
+// byte-identical and can be diffed or hashed.
+// "-export-members file" exports a list file, one member per line (the
+// list a filter and its exact set are built from), to the standard output;
+// "-import-members file" reads an export back and prints the list. -format
+// selects the format of both.
+
+// memberRecord is one line of a JSONL export
+type memberRecord struct {
+	Member string `json:"member"`
+}
+
+// Export writes the members of the set to w in the given format (csv or jsonl)
+func (s MapSet) Export(w io.Writer, format string) error {
+	members := make([]string, 0, len(s))
+	for m := range s {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"member"}); err != nil {
+			return err
+		}
+		for _, m := range members {
+			if m == "" {
+				// the csv package writes an empty field as an empty line,
+				// which readers skip: quote it so it is read back
+				cw.Flush()
+				if _, err := io.WriteString(w, "\"\"\n"); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := cw.Write([]string{m}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "jsonl":
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		for _, m := range members {
+			if err := enc.Encode(memberRecord{Member: m}); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	}
+	return fmt.Errorf("unknown member format %q", format)
+}
+
+// ImportMembers reads a set exported by MapSet.Export in the given format
+func ImportMembers(r io.Reader, format string) (MapSet, error) {
+	s := make(MapSet)
+	switch format {
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 1
+		header, err := cr.Read()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header[0] != "member" {
+			return nil, fmt.Errorf("csv members: unexpected header %q", header[0])
+		}
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return s, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			s.Add(rec[0])
+		}
+	case "jsonl":
+		dec := json.NewDecoder(r)
+		for line := 1; ; line++ {
+			var rec memberRecord
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				return s, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("jsonl members: record %d: %w", line, err)
+			}
+			s.Add(rec.Member)
+		}
+	}
+	return nil, fmt.Errorf("unknown member format %q", format)
+}
+
+// readItems returns the non-blank lines of a file, trimmed
+func readItems(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+// exportMembersFile writes the members of the list file at path to w in
+// the given format
+func exportMembersFile(path, format string, w io.Writer) error {
+	items, err := readItems(path)
+	if err != nil {
+		return err
+	}
+	s := make(MapSet, len(items))
+	for _, item := range items {
+		s.Add(item)
+	}
+	return s.Export(w, format)
+}
+
+// importMembersFile reads the export at path in the given format and writes
+// its members to w as a list, one per line in sorted order. A member that
+// a list cannot hold (empty, with a line break or surrounding spaces) is an
+// error: the list would not read back the same.
+func importMembersFile(path, format string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s, err := ImportMembers(f, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	members := make([]string, 0, len(s))
+	for m := range s {
+		if strings.TrimSpace(m) != m || m == "" || strings.ContainsAny(m, "\r\n") {
+			return fmt.Errorf("%s: member %q cannot be written as a line", path, m)
+		}
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	bw := bufio.NewWriter(w)
+	for _, m := range members {
+		bw.WriteString(m)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMembersRoundTrip(t *testing.T) {
+	s := make(MapSet)
+	for _, m := range []string{"bc1qexample", "0xabc", "", "with,comma", `with "quotes"`, "two\nlines", "café"} {
+		s.Add(m)
+	}
+	for _, format := range []string{"csv", "jsonl"} {
+		var first, second bytes.Buffer
+		if err := s.Export(&first, format); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ImportMembers(bytes.NewReader(first.Bytes()), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(got) != len(s) {
+			t.Errorf("%s: %d members read back, want %d", format, len(got), len(s))
+		}
+		for m := range s {
+			if ok, _ := got.Contains(m); !ok {
+				t.Errorf("%s: member %q lost", format, m)
+			}
+		}
+		// exports of the same list are identical
+		if err := got.Export(&second, format); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("%s: export of the imported set differs:\n%s\n%s", format, first.String(), second.String())
+		}
+	}
+
+	if err := s.Export(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("Export accepted an unknown format")
+	}
+	for _, tc := range []struct{ format, in string }{
+		{"xml", ""},
+		{"csv", "address\nbc1q\n"},
+		{"csv", "member\na,b\n"},
+		{"jsonl", `{"member": "a"}` + "\n{\n"},
+	} {
+		if _, err := ImportMembers(strings.NewReader(tc.in), tc.format); err == nil {
+			t.Errorf("ImportMembers(%q, %s) succeeded", tc.in, tc.format)
+		}
+	}
+	if got, err := ImportMembers(strings.NewReader(""), "csv"); err != nil || len(got) != 0 {
+		t.Errorf("empty csv: %v members, %v", got, err)
+	}
+}
+
+func TestMembersFiles(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "sanctions.txt")
+	if err := os.WriteFile(list, []byte("bc1qb\n  bc1qa \n\nbc1qb\n0xabc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"csv", "jsonl"} {
+		var export bytes.Buffer
+		if err := exportMembersFile(list, format, &export); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "export."+format)
+		if err := os.WriteFile(path, export.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		var back bytes.Buffer
+		if err := importMembersFile(path, format, &back); err != nil {
+			t.Fatal(err)
+		}
+		if want := "0xabc\nbc1qa\nbc1qb\n"; back.String() != want {
+			t.Errorf("%s: list read back as %q, want %q", format, back.String(), want)
+		}
+	}
+
+	// a member a list cannot hold is refused
+	path := filepath.Join(dir, "lines.jsonl")
+	if err := os.WriteFile(path, []byte(`{"member": "two\nlines"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := importMembersFile(path, "jsonl", &bytes.Buffer{}); err == nil {
+		t.Error("member with a line break written as a line")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import "math"
+
+// FilterType selects the filter family used by EstimateMemory
+type FilterType int
+
+const (
+	CuckooType FilterType = iota // cuckoo filter as built by NewCuckooFilter
+	BloomType                    // blocked Bloom filter as built by NewBlockedBloomFilter
+	XorType                      // xor filter (https://arxiv.org/abs/1912.08258)
+	MortonType                   // Morton filter as built by NewMortonFilter
+	VacuumType                   // vacuum filter as built by NewVacuumFilter
+)
+
+func (t FilterType) String() string {
+	switch t {
+	case CuckooType:
+		return "cuckoo"
+	case BloomType:
+		return "bloom"
+	case XorType:
+		return "xor"
+	case MortonType:
+		return "morton"
+	case VacuumType:
+		return "vacuum"
+	}
+	return "unknown"
+}
+
+// Config holds the parameters a filter would be built with.
+// The meaning of M and F depends on the filter type:
+//   - cuckoo, vacuum: M buckets of B entries, F fingerprint length in b_size-bit units
+//   - bloom: M bits (in blocks of 512) and K probes
+//   - xor: M fingerprint slots of F bits each
+//   - morton: M blocks of 64 bytes, F fingerprint length in bits
+type Config struct {
+	Type   FilterType
+	N      uint    // number of items - filter capacity
+	FPRate float64 // target false positive rate
+	M      uint
+	B      uint
+	F      uint
+	K      uint
+}
+
+// EstimateMemory returns the approximate number of bytes a filter of the given
+// type would use for n items and the false positive rate fpRate, together with
+// the parameters it would be built with. Nothing is allocated, so capacity
+// planners can compare the footprint of the filter families for a workload.
+//
+// The xor filter is the only one not implemented in this package; its
+// estimate follows the usual sizing formula of 1.23n + 32 slots of
+// ceil(log2(1/r)) bits. The others are sized as their constructor would
+// size them.
+// The Morton and vacuum estimates are only available in builds with the
+// experimental tag (see ExperimentalFilters).
+// An unknown or unavailable filter type, or a rate outside (0, 1), returns
+// 0 bytes.
+func EstimateMemory(n uint, fpRate float64, filterType FilterType) (bytes uint64, params Config) {
+	params = Config{Type: filterType, N: n, FPRate: fpRate}
+	if !(fpRate > 0 && fpRate < 1) {
+		return 0, params
+	}
+
+	switch filterType {
+	case CuckooType:
+		m, f := cuckooParams(n, fpRate)
+		params.M, params.B, params.F = m, b, f
+		// a single slab of m*b fingerprints of f bytes (see slab.go)
+		bytes = uint64(m) * uint64(b) * uint64(f)
+
+	case BloomType:
+		blocks, k := blockedBloomParams(n, fpRate)
+		params.M, params.K = blocks*bloomBlockBits, k
+		bytes = uint64(blocks) * bloomBlockBits / 8
+
+	case XorType:
+		f := math.Ceil(math.Log2(1 / fpRate))
+		slots := uint(math.Floor(1.23*float64(n))) + 32
+		params.M, params.F = slots, uint(f)
+		bytes = (uint64(slots)*uint64(f) + 7) / 8
+
+	case MortonType, VacuumType:
+		bytes = estimateExperimental(&params)
+	}
+
+	return bytes, params
+}
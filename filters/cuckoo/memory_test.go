@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	const n = 100000
+	for _, e := range []float64{0.01, 0.0001} {
+		bloom, params := EstimateMemory(n, e, BloomType)
+		bf := NewBlockedBloomFilter(n, e)
+		if got := uint64(len(bf.blocks)) * bloomBlockBits / 8; bloom != got || params.K != bf.k {
+			t.Errorf("bloom at %v: %d bytes, k=%d estimated; built %d bytes, k=%d", e, bloom, params.K, got, bf.k)
+		}
+		// more than a standard Bloom filter
+		if standard := -n * math.Log(e) / (math.Ln2 * math.Ln2) / 8; float64(bloom) <= standard {
+			t.Errorf("bloom at %v: %d bytes, a standard filter takes %.0f", e, bloom, standard)
+		}
+
+		cuckoo, params := EstimateMemory(n, e, CuckooType)
+		c := NewCuckooFilter(n, e)
+		if params.M != c.m || cuckoo != uint64(len(c.slots)) {
+			t.Errorf("cuckoo at %v: %d bytes, %d buckets estimated; built %d bytes, %d buckets", e, cuckoo, params.M, len(c.slots), c.m)
+		}
+	}
+
+	if x, params := EstimateMemory(n, 0.01, XorType); params.F != 7 || x != (uint64(1.23*n+32)*7+7)/8 {
+		t.Errorf("xor: %d bytes, %+v", x, params)
+	}
+	for _, e := range []float64{0, -1, 1, 2, math.NaN()} {
+		for _, ft := range []FilterType{CuckooType, BloomType, XorType, MortonType, VacuumType} {
+			if got, _ := EstimateMemory(n, e, ft); got != 0 {
+				t.Errorf("%s at rate %v: %d bytes", ft, e, got)
+			}
+		}
+	}
+	if got, _ := EstimateMemory(n, 0.01, FilterType(99)); got != 0 {
+		t.Errorf("unknown type: %d bytes", got)
+	}
+}
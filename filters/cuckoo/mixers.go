@@ -0,0 +1,109 @@
+package main
+
+// Mixer and bridge interaction detection.
+// Funds sent through a mixer or a cross-chain bridge lose their trail, so
+// risk rules treat transactions touching them apart. A transaction touches
+// a contract when it calls it directly, when one of its internal calls
+// reaches it (as reported by a trace), when the contract emits one of its
+// logs, or when it transfers tokens to or from it.
+// The curated lists are small and every hit feeds a risk decision, so their
+// filters are confirmed against the lists: the detector has no false
+// positives.
+
+// EVMTx is what the detector needs of an EVM transaction
+type EVMTx struct {
+	From          [20]byte
+	To            [20]byte   // zero for a contract creation
+	Data          []byte     // calldata
+	InternalCalls [][20]byte // targets of the internal calls, from a trace; nil if not traced
+	Logs          []EVMLog
+}
+
+// EVMLog is an event log emitted by a transaction
+type EVMLog struct {
+	Address [20]byte // emitting contract
+	Topics  [][]byte
+	Data    []byte
+}
+
+// ContractDetector detects the transactions touching curated contracts
+type ContractDetector struct {
+	mixers  *Confirmed
+	bridges *Confirmed
+}
+
+// NewContractDetector builds the detector of the mixer and bridge contracts
+func NewContractDetector(mixers, bridges [][20]byte) (*ContractDetector, error) {
+	m, err := curatedSet(mixers)
+	if err != nil {
+		return nil, err
+	}
+	b, err := curatedSet(bridges)
+	if err != nil {
+		return nil, err
+	}
+	return &ContractDetector{mixers: m, bridges: b}, nil
+}
+
+// curatedSet builds the filter of a curated list, confirmed against the list
+func curatedSet(addrs [][20]byte) (*Confirmed, error) {
+	filter := NewCuckooFilter(uint(max(len(addrs), 1)), 0.001)
+	exact := make(MapSet, len(addrs))
+	for _, a := range addrs {
+		item := evmAddress(a)
+		if err := filter.insert(item); err != nil {
+			return nil, err
+		}
+		exact.Add(item)
+	}
+	return NewConfirmed(filter, exact), nil
+}
+
+// touched returns the addresses a transaction touches (see above), the
+// direct target first. Addresses may repeat.
+func touched(tx EVMTx) [][20]byte {
+	addrs := [][20]byte{tx.To}
+	addrs = append(addrs, tx.InternalCalls...)
+	if t, ok := DecodeTokenCall(tx.To, tx.From, tx.Data); ok {
+		addrs = append(addrs, t.From, t.To)
+	}
+	for _, l := range tx.Logs {
+		addrs = append(addrs, l.Address)
+		if t, ok := DecodeTransferLog(l.Address, l.Topics, l.Data); ok {
+			addrs = append(addrs, t.From, t.To)
+		}
+	}
+	return addrs
+}
+
+// touches returns the first address of the set touched by the transaction
+func touches(set *Confirmed, tx EVMTx) ([20]byte, bool) {
+	for _, a := range touched(tx) {
+		// MapSet never fails
+		if ok, _ := set.Contains(evmAddress(a)); ok {
+			return a, true
+		}
+	}
+	return [20]byte{}, false
+}
+
+// TouchesMixer returns the first mixer contract the transaction touches
+func (d *ContractDetector) TouchesMixer(tx EVMTx) ([20]byte, bool) {
+	return touches(d.mixers, tx)
+}
+
+// TouchesBridge returns the first bridge contract the transaction touches
+func (d *ContractDetector) TouchesBridge(tx EVMTx) ([20]byte, bool) {
+	return touches(d.bridges, tx)
+}
+
+// Vars returns the policy variables of a transaction (see Policy.Decide):
+// mixer and bridge are 1 when it touches one, e.g.
+//
+//	block if mixer
+//	review if bridge && amount > 10000
+func (d *ContractDetector) Vars(tx EVMTx) map[string]float64 {
+	_, mixer := d.TouchesMixer(tx)
+	_, bridge := d.TouchesBridge(tx)
+	return map[string]float64{"mixer": boolValue(mixer), "bridge": boolValue(bridge)}
+}
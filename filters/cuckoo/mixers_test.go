@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+// abiWord returns the 32-byte ABI word of an address
+func abiWord(a [20]byte) []byte {
+	return append(make([]byte, 12), a[:]...)
+}
+
+func TestContractDetector(t *testing.T) {
+	mixer, bridge := [20]byte{0x70}, [20]byte{0xb0}
+	token, user, other := [20]byte{0xee}, [20]byte{0x11}, [20]byte{0x22}
+	d, err := NewContractDetector([][20]byte{mixer}, [][20]byte{bridge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transferLog := func(from, to [20]byte) EVMLog {
+		return EVMLog{Address: token, Topics: [][]byte{topicTransfer, abiWord(from), abiWord(to)}, Data: make([]byte, 32)}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tx     EVMTx
+		mixer  bool
+		bridge bool
+	}{
+		{"clean", EVMTx{From: user, To: other}, false, false},
+		{"direct call", EVMTx{From: user, To: mixer}, true, false},
+		{"internal call", EVMTx{From: user, To: other, InternalCalls: [][20]byte{other, bridge}}, false, true},
+		{"log of the contract", EVMTx{From: user, To: other, Logs: []EVMLog{{Address: mixer}}}, true, false},
+		{"token transfer to it", EVMTx{From: user, To: token, Data: transferCall(bridge, 1)}, false, true},
+		{"token transfer from it", EVMTx{From: user, To: other, Logs: []EVMLog{transferLog(mixer, user)}}, true, false},
+		{"both", EVMTx{From: user, To: mixer, InternalCalls: [][20]byte{bridge}}, true, true},
+	} {
+		m, gotMixer := d.TouchesMixer(tc.tx)
+		b, gotBridge := d.TouchesBridge(tc.tx)
+		if gotMixer != tc.mixer || gotBridge != tc.bridge {
+			t.Errorf("%s: mixer %v, bridge %v, want %v and %v", tc.name, gotMixer, gotBridge, tc.mixer, tc.bridge)
+		}
+		if gotMixer && m != mixer || gotBridge && b != bridge {
+			t.Errorf("%s: touched %x and %x", tc.name, m, b)
+		}
+		vars := d.Vars(tc.tx)
+		if vars["mixer"] != boolValue(tc.mixer) || vars["bridge"] != boolValue(tc.bridge) {
+			t.Errorf("%s: Vars = %v", tc.name, vars)
+		}
+	}
+
+	// empty lists touch nothing
+	empty, err := NewContractDetector(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := empty.TouchesMixer(EVMTx{To: mixer}); ok {
+		t.Error("empty list touched")
+	}
+}
@@ -0,0 +1,231 @@
+//go:build experimental
+
+package main
+
+import (
+	"math/rand"
+)
+
+// Morton filter based on https://www.vldb.org/pvldb/vol11/p1041-breslow.pdf
+// (A. Breslow, N. Jayasena, "Morton Filters: Faster, Space-Efficient Cuckoo Filters
+// via Biasing, Compression, and Decoupled Logical Sparsity").
+//
+// A Morton filter is a compressed cuckoo filter. Logical buckets are grouped in
+// blocks of one cache line (64 bytes): instead of reserving b slots per bucket,
+// a block stores the fingerprints of its 64 buckets back to back in a shared
+// array of 46 slots, with a 2-bit counter per bucket telling how many of them
+// belong to it. Empty buckets use no slot, so ~0.95 load factors are reached
+// with less memory than the cuckoo filter at the same fingerprint size.
+//
+// Items are always inserted in their primary bucket when its block has room
+// (biasing). An overflow tracking bit, set when an item of a bucket had to go
+// to its secondary bucket, lets most negative lookups read a single cache line.
+//
+// Fingerprints are 8 bits, so the false positive rate is around 2-3%,
+// close to the cuckoo filter with b_size = 8.
+
+const (
+	mortonBuckets  = 64 // logical buckets per block
+	mortonSlots    = 46 // fingerprint slots shared by the buckets of a block
+	mortonBucketFP = 3  // maximum fingerprints per logical bucket (2-bit counter)
+	mortonOTABits  = 16 // overflow tracking bits per block
+	mortonLoad     = 0.95
+)
+
+// mortonBlock is one cache line: 46 + 16 + 2 = 64 bytes
+type mortonBlock struct {
+	fsa [mortonSlots]uint8       // fingerprint storage array
+	fca [mortonBuckets / 4]uint8 // fullness counter array, 2 bits per bucket
+	ota uint16                   // overflow tracking array
+}
+
+// Morton is a Morton filter
+type Morton struct {
+	blocks []mortonBlock
+	mask   uint // number of logical buckets - 1
+}
+
+var _ DeletableFilter = (*Morton)(nil)
+
+// NewMortonFilter creates a Morton filter for n items.
+// The number of blocks is rounded up to a power of two, like the number of
+// buckets of the cuckoo filter, so the alternate bucket can be computed by XOR.
+func NewMortonFilter(n uint) *Morton {
+	blocks := mortonBlocks(n)
+	return &Morton{
+		blocks: make([]mortonBlock, blocks),
+		mask:   blocks*mortonBuckets - 1,
+	}
+}
+
+// mortonBlocks returns the number of blocks holding n items at the target load factor
+func mortonBlocks(n uint) uint {
+	return nextPower(uint(float64(n)/(mortonSlots*mortonLoad)) + 1)
+}
+
+// count returns the number of fingerprints of logical bucket k in the block
+func (blk *mortonBlock) count(k uint) uint {
+	return uint(blk.fca[k/4]>>(2*(k%4))) & 3
+}
+
+func (blk *mortonBlock) setCount(k, c uint) {
+	shift := 2 * (k % 4)
+	blk.fca[k/4] = blk.fca[k/4]&^(3<<shift) | uint8(c<<shift)
+}
+
+// offset returns the position in the fsa of the first fingerprint of bucket k
+// and the total number of fingerprints in the block
+func (blk *mortonBlock) offset(k uint) (uint, uint) {
+	var off, total uint
+	for j := uint(0); j < mortonBuckets; j++ {
+		if j == k {
+			off = total
+		}
+		total += blk.count(j)
+	}
+	return off, total
+}
+
+// bucketAt returns the logical bucket holding the fingerprint at position p of the fsa
+func (blk *mortonBlock) bucketAt(p uint) uint {
+	var total uint
+	for j := uint(0); j < mortonBuckets; j++ {
+		total += blk.count(j)
+		if p < total {
+			return j
+		}
+	}
+	return mortonBuckets - 1
+}
+
+func (blk *mortonBlock) otaBit(k uint) uint16 {
+	return 1 << (k % mortonOTABits)
+}
+
+// bucket returns the block and the logical bucket within it of bucket i
+func (mf *Morton) bucket(i uint) (*mortonBlock, uint) {
+	return &mf.blocks[i/mortonBuckets], i % mortonBuckets
+}
+
+// hashes returns the primary and secondary bucket and the fingerprint of an item
+func (mf *Morton) hashes(data string) (uint, uint, uint8) {
+	h := hash([]byte(data))
+	f := h[0]
+	i1 := uint(hashIndex(h)) & mf.mask
+	return i1, mf.altIndex(i1, f), f
+}
+
+// altIndex returns the alternate bucket of a fingerprint stored in bucket i
+func (mf *Morton) altIndex(i uint, f uint8) uint {
+	return i ^ uint(hashIndex(hash([]byte{f})))&mf.mask
+}
+
+// add stores the fingerprint in bucket i if the bucket and its block have room
+func (mf *Morton) add(i uint, f uint8) bool {
+	blk, k := mf.bucket(i)
+	c := blk.count(k)
+	off, total := blk.offset(k)
+	if c == mortonBucketFP || total == mortonSlots {
+		return false
+	}
+	// shift the fingerprints of the following buckets to make room
+	pos := off + c
+	copy(blk.fsa[pos+1:total+1], blk.fsa[pos:total])
+	blk.fsa[pos] = f
+	blk.setCount(k, c+1)
+	return true
+}
+
+// find returns the position in the fsa of the fingerprint in bucket i
+func (mf *Morton) find(i uint, f uint8) (uint, bool) {
+	blk, k := mf.bucket(i)
+	off, _ := blk.offset(k)
+	for p := off; p < off+blk.count(k); p++ {
+		if blk.fsa[p] == f {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// remove deletes the fingerprint at position p of bucket i
+func (mf *Morton) remove(i, p uint) {
+	blk, k := mf.bucket(i)
+	_, total := blk.offset(k)
+	copy(blk.fsa[p:total-1], blk.fsa[p+1:total])
+	blk.fsa[total-1] = 0
+	blk.setCount(k, blk.count(k)-1)
+}
+
+// insert adds an item to the Morton filter
+func (mf *Morton) insert(input string) error {
+	i1, i2, f := mf.hashes(input)
+
+	// biasing: the primary bucket first
+	if mf.add(i1, f) {
+		return nil
+	}
+	// the item goes to its secondary bucket: lookups of bucket i1 must check it
+	blk, k := mf.bucket(i1)
+	blk.ota |= blk.otaBit(k)
+	if mf.add(i2, f) {
+		return nil
+	}
+
+	// relocate fingerprints out of the secondary bucket's block.
+	// add only fails when the bucket holds 3 fingerprints or the block is full:
+	// a victim is taken from the bucket in the first case and from any bucket
+	// of the block in the second, which makes room for f in bucket i.
+	// The victim may be in its primary bucket, so the overflow bit of the
+	// bucket it leaves is set: a stale bit only costs an extra probe.
+	i := i2
+	for r := 0; r < retries; r++ {
+		blk, k := mf.bucket(i)
+		j := k
+		if blk.count(k) < mortonBucketFP {
+			j = blk.bucketAt(uint(rand.Intn(mortonSlots)))
+		}
+		base := i - k // first bucket of the block
+
+		off, _ := blk.offset(j)
+		p := off + uint(rand.Intn(int(blk.count(j))))
+		victim := blk.fsa[p]
+		mf.remove(base+j, p)
+		blk.ota |= blk.otaBit(j)
+		mf.add(i, f)
+
+		f, i = victim, mf.altIndex(base+j, victim)
+		if mf.add(i, f) {
+			return nil
+		}
+	}
+	return ErrFull
+}
+
+// lookup needle in the Morton filter
+func (mf *Morton) lookup(needle string) bool {
+	i1, i2, f := mf.hashes(needle)
+	if _, ok := mf.find(i1, f); ok {
+		return true
+	}
+	// the secondary bucket is only read if some item of i1 overflowed
+	blk, k := mf.bucket(i1)
+	if blk.ota&blk.otaBit(k) == 0 {
+		return false
+	}
+	_, ok := mf.find(i2, f)
+	return ok
+}
+
+// delete the fingerprint from the Morton filter.
+// Overflow bits are never cleared: they may be shared with other buckets.
+func (mf *Morton) delete(needle string) {
+	i1, i2, f := mf.hashes(needle)
+	if p, ok := mf.find(i1, f); ok {
+		mf.remove(i1, p)
+		return
+	}
+	if p, ok := mf.find(i2, f); ok {
+		mf.remove(i2, p)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+// WithOffHeap allocates the buckets outside the Go heap, with an anonymous
+// mmap, on Linux. The slab holds no pointers, so the collector does not scan
+// it either way, but a multi-GB heap still makes the collector run less often
+// and keeps the memory until the next cycles; off-heap buckets count neither
+// in the heap size nor in GOGC pacing, and are returned to the kernel at once.
+// The memory is not managed: Close must be called when the filter is no
+// longer used, or it leaks. Clones (and frozen copies) of an off-heap filter
+// are off-heap too, and must be closed separately.
+// On other platforms, or if the mapping fails, the buckets stay on the heap
+// and Close does nothing.
+func WithOffHeap() Option {
+	return func(c *Cuckoo) {
+		c.offHeap = true
+	}
+}
+
+// allocSlots allocates size zeroed bytes of bucket storage for the filter.
+// Off-heap storage is only an optimization: if the mmap fails, the buckets
+// are allocated on the heap instead and the filter is no longer off-heap
+// (see OffHeap), rather than failing the constructor. Loaders use
+// tryAllocSlots, which returns the error.
+func (c *Cuckoo) allocSlots(size uint) {
+	slots, err := c.tryAllocSlots(size)
+	if err != nil {
+		c.offHeap = false
+		slots = make([]byte, size)
+	}
+	c.slots = slots
+}
+
+// OffHeap returns true if the filter was built with WithOffHeap and its
+// buckets did not fall back to the heap
+func (c *Cuckoo) OffHeap() bool {
+	return c.offHeap
+}
+
+// Close releases the buckets of an off-heap filter (see WithOffHeap).
+// The filter must not be used after Close. It does nothing for other filters.
+func (c *Cuckoo) Close() error {
+	if !c.offHeap || c.slots == nil {
+		return nil
+	}
+	err := munmapSlots(c.slots)
+	c.slots = nil
+	return err
+}
+
+// Close releases the buckets of an off-heap frozen filter (see WithOffHeap).
+// It waits for a Verify in progress.
+func (fz *Frozen) Close() error {
+	fz.mu.Lock()
+	defer fz.mu.Unlock()
+	fz.closed = true
+	return fz.c.Close()
+}
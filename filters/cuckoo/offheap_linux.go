@@ -0,0 +1,22 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// mmapSlots maps size bytes of anonymous memory, zeroed by the kernel
+func mmapSlots(size uint) ([]byte, error) {
+	mem, err := syscall.Mmap(-1, 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	return mem, nil
+}
+
+// munmapSlots unmaps memory returned by mmapSlots
+func munmapSlots(mem []byte) error {
+	return syscall.Munmap(mem)
+}
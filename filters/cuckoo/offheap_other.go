@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+// mmapSlots allocates the buckets on the heap: off-heap storage is only
+// implemented on Linux
+func mmapSlots(size uint) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+// munmapSlots leaves heap memory to the garbage collector
+func munmapSlots(mem []byte) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestOffHeap(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01, WithOffHeap())
+	if !c.OffHeap() {
+		t.Error("filter built with WithOffHeap is not off-heap")
+	}
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	d := c.Clone()
+	if !d.OffHeap() || !d.Equal(c) {
+		t.Error("clone of an off-heap filter differs")
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestOffHeapFallback checks that a failed mmap leaves the buckets on the
+// heap instead of panicking
+func TestOffHeapFallback(t *testing.T) {
+	withFaults(t, faultHooks{alloc: func(uint) error { return errInjected }})
+	c := NewCuckooFilter(1000, 0.01, WithOffHeap())
+	if c.OffHeap() {
+		t.Error("filter still off-heap after a failed allocation")
+	}
+	for i := 0; i < 500; i++ {
+		if err := c.insert(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := c.Clone()
+	if !d.Equal(c) {
+		t.Error("clone differs")
+	}
+
+	faults = faultHooks{}
+	e := NewCuckooFilter(1000, 0.01, WithOffHeap())
+	withFaults(t, faultHooks{alloc: func(uint) error { return errInjected }})
+	f := e.Clone()
+	if f.OffHeap() || !e.OffHeap() {
+		t.Errorf("clone off-heap %v, original %v: want only the original off-heap", f.OffHeap(), e.OffHeap())
+	}
+	for _, x := range []*Cuckoo{c, d, e, f} {
+		if err := x.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+// Option is an optional setting of NewCuckooFilter
+type Option func(*Cuckoo)
+
+// WithMaxLoadFactor makes insert return ErrOverCapacity instead of adding an
+// item that would take the filter above the load factor lf (e.g., 0.95),
+// the share of occupied slots. Past ~95% the relocations of the cuckoo filter
+// start to fail, and every extra item raises the false positive rate, so an
+// over-filled filter degrades silently instead of failing loudly.
+// The caller can then rebuild a larger filter or rotate to a new one.
+func WithMaxLoadFactor(lf float64) Option {
+	return func(c *Cuckoo) {
+		c.maxLoad = lf
+	}
+}
+
+// WithEmptyKeys makes the empty key a regular item.
+// By default insert refuses it with ErrEmptyKey: an empty address or txid is
+// almost always a parsing bug upstream, and once inserted it would make every
+// other empty value match the filter. Lookups and deletes of the empty key
+// are always allowed (it is simply not found unless inserted).
+func WithEmptyKeys() Option {
+	return func(c *Cuckoo) {
+		c.allowEmpty = true
+	}
+}
+
+// WithIdempotentInsert makes insert a no-op when the item is already found
+// in the filter, instead of storing one more copy of its fingerprint.
+// By default (multi-insert) every insert uses a slot and a delete removes one
+// copy, so an item inserted twice stays after one delete; repeated inserts
+// then silently consume capacity.
+// An item that is a false positive of the filter is also not stored in
+// idempotent mode, so deleting the item it collides with makes it a false
+// negative: only use it for filters whose items are never deleted.
+func WithIdempotentInsert() Option {
+	return func(c *Cuckoo) {
+		c.idempotent = true
+	}
+}
+
+// LoadFactor returns the share of occupied slots of the filter
+func (c *Cuckoo) LoadFactor() float64 {
+	return float64(c.count) / float64(c.m*c.b)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEmptyKey(t *testing.T) {
+	c := NewCuckooFilter(100, 0.01)
+	if err := c.insert(""); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("insert of the empty key: got %v, want ErrEmptyKey", err)
+	}
+	if err := c.insertBatch([]string{"a", ""}); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("batch insert of the empty key: got %v, want ErrEmptyKey", err)
+	}
+	if c.lookup("") {
+		t.Error("refused empty key found")
+	}
+	c.delete("") // always allowed
+
+	c = NewCuckooFilter(100, 0.01, WithEmptyKeys())
+	if err := c.insert(""); err != nil {
+		t.Fatalf("insert of the empty key with WithEmptyKeys: %v", err)
+	}
+	if !c.lookup("") {
+		t.Error("empty key inserted with WithEmptyKeys not found")
+	}
+}
+
+func TestDuplicateInsert(t *testing.T) {
+	// multi-insert: every insert takes a slot, a delete removes one copy
+	c := NewCuckooFilter(100, 0.01)
+	for i := 0; i < 2; i++ {
+		if err := c.insert("item"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.count != 2 {
+		t.Errorf("multi-insert: count %d after two inserts, want 2", c.count)
+	}
+	c.delete("item")
+	if !c.lookup("item") {
+		t.Error("multi-insert: item inserted twice not found after one delete")
+	}
+
+	// idempotent: the second insert is a no-op
+	c = NewCuckooFilter(100, 0.01, WithIdempotentInsert())
+	for i := 0; i < 2; i++ {
+		if err := c.insert("item"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.count != 1 {
+		t.Errorf("idempotent: count %d after two inserts, want 1", c.count)
+	}
+	c.delete("item")
+	if c.lookup("item") || c.count != 0 {
+		t.Errorf("idempotent: item found after one delete (count %d)", c.count)
+	}
+}
+
+// TestDuplicateInsertCapacity checks that repeated inserts of one item do not
+// consume the filter in idempotent mode
+func TestDuplicateInsertCapacity(t *testing.T) {
+	c := NewCuckooFilter(10, 0.01, WithIdempotentInsert(), WithMaxLoadFactor(0.5))
+	for i := 0; i < 1000; i++ {
+		if err := c.insert("item"); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if c.LoadFactor() > 1/float64(c.m*c.b) {
+		t.Errorf("load factor %.2f after inserting one item 1000 times", c.LoadFactor())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestEstimateIntersection checks the estimates of two filters of 100k
+// items against the documented error bounds
+func TestEstimateIntersection(t *testing.T) {
+	const n = 100000
+	for _, overlap := range []int{0, 1000, 10000, 50000, n} {
+		a, b := NewBlockedBloomFilter(n, 0.01), NewBlockedBloomFilter(n, 0.01)
+		for i := 0; i < n; i++ {
+			a.insert(fmt.Sprintf("addr-%d", i))
+			b.insert(fmt.Sprintf("addr-%d", n-overlap+i))
+		}
+		got, err := EstimateIntersection(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("overlap %d: estimated %.0f", overlap, got)
+		if math.Abs(got-float64(overlap)) > 0.005*float64(2*n-overlap) {
+			t.Errorf("overlap %d: estimated %.0f", overlap, got)
+		}
+		jaccard, err := EstimateJaccard(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := float64(overlap) / float64(2*n-overlap); math.Abs(jaccard-want) > 0.005 {
+			t.Errorf("overlap %d: Jaccard index %.4f, want %.4f", overlap, jaccard, want)
+		}
+	}
+}
+
+func TestEstimateOverlapEdges(t *testing.T) {
+	a, b := NewBlockedBloomFilter(1000, 0.01), NewBlockedBloomFilter(1000, 0.01)
+	if j, err := EstimateJaccard(a, b); err != nil || j != 0 {
+		t.Errorf("Jaccard index of empty filters = %v, %v", j, err)
+	}
+	a.insert("a")
+	if i, err := EstimateIntersection(a, b); err != nil || i != 0 {
+		t.Errorf("intersection with an empty filter = %v, %v", i, err)
+	}
+
+	for _, other := range []*BlockedBloom{NewBlockedBloomFilter(2000, 0.01), NewBlockedBloomFilter(1000, 0.00001)} {
+		var mismatch *ParamMismatchError
+		if _, err := EstimateIntersection(a, other); !errors.As(err, &mismatch) || !errors.Is(err, ErrIncompatibleParams) {
+			t.Errorf("EstimateIntersection of incompatible filters = %v", err)
+		}
+		if _, err := EstimateJaccard(a, other); err == nil {
+			t.Error("EstimateJaccard of incompatible filters succeeded")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+)
+
+// PaddedLookuper hides which items a wallet looks up from the filter it
+// queries, when that filter is operated by a third party (e.g., a remote
+// screening service implementing Lookuper).
+// Every batch is padded with dummy queries to a multiple of the batch size,
+// with at least one dummy, and sent in a random order, so the service only
+// learns that the payee is one of the queried items, not which one.
+// This is not private information retrieval: the real items are still sent.
+// The privacy depends on the dummies looking like real queries, so dummy
+// should return items of the same kind (e.g., random addresses of the same
+// chain and format), never a fixed decoy the service could learn to ignore.
+type PaddedLookuper struct {
+	filter    Lookuper
+	batchSize int
+	dummy     func() string
+}
+
+var _ Lookuper = (*PaddedLookuper)(nil)
+
+// NewPaddedLookuper queries filter in batches padded to a multiple of batchSize
+// with items returned by dummy
+func NewPaddedLookuper(filter Lookuper, batchSize int, dummy func() string) *PaddedLookuper {
+	return &PaddedLookuper{filter: filter, batchSize: max(batchSize, 1), dummy: dummy}
+}
+
+// lookup queries the item within a padded batch
+func (p *PaddedLookuper) lookup(item string) bool {
+	return p.lookupBatch([]string{item})[0]
+}
+
+// lookupBatch pads and shuffles the batch, looks it up in one call to the
+// filter and returns the results of the real items in their original order
+func (p *PaddedLookuper) lookupBatch(items []string) []bool {
+	total := (len(items)/p.batchSize + 1) * p.batchSize
+
+	queries := make([]string, total)
+	copy(queries, items)
+	for i := len(items); i < total; i++ {
+		queries[i] = p.dummy()
+	}
+
+	// perm[j] is the position of the j-th query in the shuffled batch
+	perm := make([]int, total)
+	for i := range perm {
+		perm[i] = i
+	}
+	for i := total - 1; i > 0; i-- {
+		j := randIntn(i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	shuffled := make([]string, total)
+	for j, pos := range perm {
+		shuffled[pos] = queries[j]
+	}
+
+	found := lookupBatch(p.filter, shuffled)
+
+	results := make([]bool, len(items))
+	for j := range items {
+		results[j] = found[perm[j]]
+	}
+	return results
+}
+
+// randIntn returns a uniform random number in [0, n) from crypto/rand:
+// with math/rand, an observer of a few batches could predict the order
+// of the next ones. Values of the last, partial range of n are drawn again,
+// so v % n is uniform.
+func randIntn(n int) int {
+	limit := math.MaxUint64 - math.MaxUint64%uint64(n)
+	var b [8]byte
+	for {
+		rand.Read(b[:]) // never fails: crypto/rand crashes the program instead
+		if v := binary.LittleEndian.Uint64(b[:]); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// batchRecorder is a filter recording the batches it is queried with
+type batchRecorder struct {
+	Lookuper
+	batches [][]string
+}
+
+func (r *batchRecorder) lookupBatch(items []string) []bool {
+	r.batches = append(r.batches, slices.Clone(items))
+	return lookupBatch(r.Lookuper, items)
+}
+
+func TestPaddedLookuper(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.001)
+	c.insert("payee-1")
+	c.insert("payee-3")
+	remote := &batchRecorder{Lookuper: c}
+	n := 0
+	p := NewPaddedLookuper(remote, 8, func() string {
+		n++
+		return "dummy-" + strconv.Itoa(n)
+	})
+
+	items := []string{"payee-0", "payee-1", "payee-2", "payee-3"}
+	if got := p.lookupBatch(items); !slices.Equal(got, []bool{false, true, false, true}) {
+		t.Errorf("lookupBatch = %v", got)
+	}
+	if !p.lookup("payee-1") || p.lookup("payee-2") {
+		t.Error("lookup of a single item")
+	}
+	// a full batch still gets dummies
+	eight := make([]string, 8)
+	for i := range eight {
+		eight[i] = "payee-" + strconv.Itoa(i)
+	}
+	p.lookupBatch(eight)
+
+	for i, want := range []int{8, 8, 8, 16} {
+		batch := remote.batches[i]
+		if len(batch) != want {
+			t.Errorf("batch %d of %d queries, want %d", i, len(batch), want)
+		}
+		dummies := 0
+		for _, q := range batch {
+			if q[:6] == "dummy-" {
+				dummies++
+			}
+		}
+		if dummies == 0 {
+			t.Errorf("batch %d without dummies", i)
+		}
+	}
+
+	// the real items are not always at the front
+	moved := false
+	for i := 0; i < 20 && !moved; i++ {
+		p.lookupBatch(items)
+		moved = !slices.Equal(remote.batches[len(remote.batches)-1][:4], items)
+	}
+	if !moved {
+		t.Error("batches not shuffled")
+	}
+}
+
+func TestRandIntn(t *testing.T) {
+	var counts [3]int
+	for i := 0; i < 30000; i++ {
+		counts[randIntn(3)]++
+	}
+	for v, c := range counts {
+		if c < 9400 || c > 10600 {
+			t.Errorf("%d drawn %d times in 30000", v, c)
+		}
+	}
+	if randIntn(1) != 0 {
+		t.Error("randIntn(1) != 0")
+	}
+}
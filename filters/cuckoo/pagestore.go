@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Content-addressed snapshot store.
+// A PageStore keeps filters as pages of their bucket slab, each stored once
+// under its SHA-256 in pages/, and a manifest per filter name listing the
+// hashes of its pages (plus the header fields and the stash). A rebuild that
+// leaves a page unchanged reuses the stored page, so a day's snapshot only
+// costs the pages that differ from the previous one; a replica pulling a
+// filter from another store only fetches the pages it does not hold.
+// How many pages are shared depends on the churn: a page changes as soon
+// as one of its buckets does, so smaller pages share more, at the cost of
+// longer manifests.
+//
+//	<dir>/pages/<sha256 hex>       page contents
+//	<dir>/manifests/<name>.json    PageManifest
+//
+// Files are written with writeAtomic, so a crash never leaves a partial page
+// or manifest under its final name. Pages no manifest
+// references are left behind; they are removed by GC (see retention.go).
+
+// PageManifest describes a stored filter
+type PageManifest struct {
+	Keyed    bool            `json:"keyed"`
+	N        uint            `json:"n"`
+	M        uint            `json:"m"`
+	B        uint            `json:"b"`
+	F        uint            `json:"f"`
+	Count    uint            `json:"count"`
+	Hash     uint32          `json:"hash,omitempty"` // hash family ID, 0 for the default
+	Seed     uint64          `json:"seed,omitempty"` // hash seed
+	PageSize int             `json:"page_size"`
+	Pages    []string        `json:"pages"` // hex SHA-256 of each page of the slab
+	Stash    []ManifestEntry `json:"stash,omitempty"`
+	Created  time.Time       `json:"created"`
+}
+
+// ManifestEntry is a stashed fingerprint of a manifest
+type ManifestEntry struct {
+	Bucket      uint   `json:"bucket"`
+	Fingerprint string `json:"fingerprint"` // hex
+}
+
+// Limits of what Pull accepts from a peer: a page is never larger than
+// the page size of its manifest, and a manifest lists the hashes of its pages
+// (a filter of 4 GiB in pages of 64 KiB, or 64 MiB in pages of 1 KiB, takes
+// about 4 MiB).
+const (
+	maxPageBytes     = 16 << 20
+	maxManifestBytes = 16 << 20
+)
+
+// PageStore stores filters as content-addressed pages.
+// It is safe for concurrent use by several goroutines and processes, as long
+// as they do not write the same name at the same time.
+type PageStore struct {
+	dir      string
+	pageSize int
+}
+
+// NewPageStore opens (creating it if needed) the store in dir, splitting
+// the filters it stores into pages of pageSize bytes
+func NewPageStore(dir string, pageSize int) (*PageStore, error) {
+	if pageSize <= 0 || pageSize > maxPageBytes {
+		return nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	for _, sub := range []string{"pages", "manifests"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &PageStore{dir: dir, pageSize: pageSize}, nil
+}
+
+// validName rejects names that are not a single path element
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid filter name %q", name)
+	}
+	return nil
+}
+
+// validHash rejects page names that are not a hex SHA-256
+func validHash(h string) error {
+	if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid page hash %q", h)
+	}
+	return nil
+}
+
+func (s *PageStore) pagePath(h string) string {
+	return filepath.Join(s.dir, "pages", h)
+}
+
+func (s *PageStore) manifestPath(name string) string {
+	return filepath.Join(s.dir, "manifests", name+".json")
+}
+
+// hasPage returns true if the page is stored
+func (s *PageStore) hasPage(h string) bool {
+	_, err := os.Stat(s.pagePath(h))
+	return err == nil
+}
+
+// putPage stores a page unless it is stored already, and returns true if
+// it was written. A page stored already is touched, so GC sees it as new
+// until the manifest referencing it is written.
+func (s *PageStore) putPage(page []byte) (string, bool, error) {
+	sum := sha256.Sum256(page)
+	h := hex.EncodeToString(sum[:])
+	if s.hasPage(h) {
+		now := time.Now()
+		return h, false, os.Chtimes(s.pagePath(h), now, now)
+	}
+	return h, true, writeFile(s.pagePath(h), page)
+}
+
+// readPage reads a page and checks its hash
+func (s *PageStore) readPage(h string) ([]byte, error) {
+	if err := validHash(h); err != nil {
+		return nil, err
+	}
+	page, err := os.ReadFile(s.pagePath(h))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(page); hex.EncodeToString(sum[:]) != h {
+		return nil, fmt.Errorf("page %s is corrupted", h)
+	}
+	return page, nil
+}
+
+// Put stores the filter under name, replacing the previous one, and
+// returns the number of pages written (the others were stored already)
+func (s *PageStore) Put(name string, c *Cuckoo) (int, error) {
+	if err := validName(name); err != nil {
+		return 0, err
+	}
+	m := PageManifest{
+		Keyed: c.key != nil, N: c.n, M: c.m, B: c.b, F: c.f, Count: c.count,
+		PageSize: s.pageSize, Created: time.Now().UTC(),
+	}
+	family, seed := c.HashFamily()
+	m.Hash, m.Seed = family.ID, seed
+	written := 0
+	for off := 0; off < len(c.slots); off += s.pageSize {
+		h, wrote, err := s.putPage(c.slots[off:min(off+s.pageSize, len(c.slots))])
+		if err != nil {
+			return written, err
+		}
+		if wrote {
+			written++
+		}
+		m.Pages = append(m.Pages, h)
+	}
+	for _, e := range c.victims {
+		m.Stash = append(m.Stash, ManifestEntry{Bucket: e.i, Fingerprint: hex.EncodeToString(e.f)})
+	}
+	return written, s.putManifest(name, &m)
+}
+
+func (s *PageStore) putManifest(name string, m *PageManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.manifestPath(name), data)
+}
+
+// Manifest returns the manifest of the filter stored under name
+func (s *PageStore) Manifest(name string) (*PageManifest, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.manifestPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var m PageManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// Missing returns the pages of a manifest that are not stored
+func (s *PageStore) Missing(m *PageManifest) []string {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, h := range m.Pages {
+		if !seen[h] && !s.hasPage(h) {
+			missing = append(missing, h)
+		}
+		seen[h] = true
+	}
+	return missing
+}
+
+// Get loads the filter stored under name. opts are the settings of the
+// loaded filter, as for ReadCuckoo.
+func (s *PageStore) Get(name string, opts ...Option) (*Cuckoo, error) {
+	m, err := s.Manifest(name)
+	if err != nil {
+		return nil, err
+	}
+	if m.M == 0 || m.M&(m.M-1) != 0 || m.B == 0 || m.F == 0 || m.F > maxFingerprintBytes || m.PageSize <= 0 || m.PageSize > maxPageBytes {
+		return nil, fmt.Errorf("manifest %s: invalid layout m=%d b=%d f=%d", name, m.M, m.B, m.F)
+	}
+	size := uint64(m.M) * uint64(m.B) * uint64(m.F)
+	if size > maxSnapshotBytes || uint64(len(m.Pages)) != (size+uint64(m.PageSize)-1)/uint64(m.PageSize) {
+		return nil, fmt.Errorf("manifest %s: %d pages of %d bytes for %d bytes of buckets", name, len(m.Pages), m.PageSize, size)
+	}
+	if len(m.Stash) > stashSize {
+		return nil, fmt.Errorf("manifest %s: %d stashed fingerprints, at most %d", name, len(m.Stash), stashSize)
+	}
+
+	c := &Cuckoo{m: m.M, mask: m.M - 1, b: m.B, f: m.F, n: m.N}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if m.Keyed != (c.key != nil) {
+		if m.Keyed {
+			return nil, fmt.Errorf("manifest of a keyed filter loaded without WithKey: %w", ErrIncompatibleParams)
+		}
+		return nil, fmt.Errorf("manifest of a plain filter loaded with WithKey: %w", ErrIncompatibleParams)
+	}
+	if err := c.adoptHash(m.Hash, m.Seed); err != nil {
+		return nil, err
+	}
+
+	if c.slots, err = c.tryAllocSlots(uint(size)); err != nil {
+		return nil, err
+	}
+	for p, h := range m.Pages {
+		page, err := s.readPage(h)
+		if err == nil && p*m.PageSize+len(page) != min((p+1)*m.PageSize, int(size)) {
+			err = fmt.Errorf("page %s has %d bytes", h, len(page))
+		}
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("manifest %s: %w", name, err)
+		}
+		copy(c.slots[p*m.PageSize:], page)
+	}
+	for _, e := range m.Stash {
+		fp, err := hex.DecodeString(e.Fingerprint)
+		if err != nil || uint(len(fp)) != m.F || e.Bucket >= m.M {
+			c.Close()
+			return nil, fmt.Errorf("manifest %s: invalid stash entry %+v", name, e)
+		}
+		c.victims = append(c.victims, stashEntry{i: e.Bucket, f: fp})
+	}
+
+	var stored uint
+	c.Range(func(uint, []byte) bool {
+		stored++
+		return true
+	})
+	if stored != m.Count {
+		c.Close()
+		return nil, fmt.Errorf("manifest %s: count %d, but %d fingerprints stored", name, m.Count, stored)
+	}
+	c.count = m.Count
+	return c, nil
+}
+
+// Handler serves the store to pulling replicas:
+//
+//	GET /manifests/<name>
+//	GET /pages/<sha256 hex>
+//
+// It does not authenticate requests: mount it behind the authentication of
+// the API.
+func (s *PageStore) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		kind, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		var path string
+		switch kind {
+		case "manifests":
+			if validName(id) == nil {
+				path = s.manifestPath(id)
+			}
+		case "pages":
+			if validHash(id) == nil {
+				path = s.pagePath(id)
+			}
+		}
+		if path == "" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, path)
+	})
+}
+
+// Pull copies the filter stored under name in the store served at baseURL
+// (see Handler), fetching only the pages missing here, and returns the
+// number of pages fetched. The manifest is stored last, so the filter is
+// only visible once every page is.
+func (s *PageStore) Pull(client *http.Client, baseURL, name string) (int, error) {
+	if err := validName(name); err != nil {
+		return 0, err
+	}
+	data, err := fetch(client, baseURL+"/manifests/"+url.PathEscape(name), maxManifestBytes)
+	if err != nil {
+		return 0, err
+	}
+	var m PageManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return 0, fmt.Errorf("manifest %s: %w", name, err)
+	}
+	if m.PageSize <= 0 || m.PageSize > maxPageBytes {
+		return 0, fmt.Errorf("manifest %s: invalid page size %d", name, m.PageSize)
+	}
+	for _, h := range m.Pages {
+		if err := validHash(h); err != nil {
+			return 0, fmt.Errorf("manifest %s: %w", name, err)
+		}
+	}
+
+	fetched := 0
+	for _, h := range s.Missing(&m) {
+		page, err := fetch(client, baseURL+"/pages/"+h, int64(m.PageSize))
+		if err != nil {
+			return fetched, err
+		}
+		if sum := sha256.Sum256(page); hex.EncodeToString(sum[:]) != h {
+			return fetched, fmt.Errorf("page %s: content does not match its hash", h)
+		}
+		if _, _, err := s.putPage(page); err != nil {
+			return fetched, err
+		}
+		fetched++
+	}
+	return fetched, writeFile(s.manifestPath(name), data)
+}
+
+// fetch returns the body of a successful GET, refusing a body over limit
+// bytes without reading more of it
+func fetch(client *http.Client, u string, limit int64) ([]byte, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("GET %s: body over %d bytes", u, limit)
+	}
+	return body, nil
+}
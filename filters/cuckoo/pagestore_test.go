@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPageStorePull(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	src, err := NewPageStore(t.TempDir(), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Put("sanctions", c); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(src.Handler())
+	defer srv.Close()
+
+	dst, err := NewPageStore(t.TempDir(), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := dst.Pull(srv.Client(), srv.URL, "sanctions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := src.Manifest("sanctions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n > len(m.Pages) {
+		t.Errorf("Pull fetched %d pages of %d", n, len(m.Pages))
+	}
+	got, err := dst.Get("sanctions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(c) {
+		t.Error("pulled filter differs")
+	}
+	if n, err := dst.Pull(srv.Client(), srv.URL, "sanctions"); err != nil || n != 0 {
+		t.Errorf("second Pull fetched %d pages (%v)", n, err)
+	}
+}
+
+func TestPageStorePullLimits(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	c.insert("a")
+	src, err := NewPageStore(t.TempDir(), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Put("sanctions", c); err != nil {
+		t.Fatal(err)
+	}
+	m, err := src.Manifest("sanctions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge := *m
+	huge.PageSize = maxPageBytes + 1
+	hugeManifest, err := json.Marshal(huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a peer serving more than a manifest or a page may hold is refused
+	for _, tc := range []struct {
+		name           string
+		manifest, page []byte
+		want           string // in the error
+	}{
+		{"oversized manifest", append(manifest, bytes.Repeat([]byte(" "), maxManifestBytes)...), nil, "body over"},
+		{"oversized page size", hugeManifest, nil, "invalid page size"},
+		{"oversized page", manifest, make([]byte, m.PageSize+1), "body over"},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/manifests/") {
+				w.Write(tc.manifest)
+				return
+			}
+			w.Write(tc.page)
+		}))
+		dst, err := NewPageStore(t.TempDir(), 512)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Pull(srv.Client(), srv.URL, "sanctions"); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Pull = %v, want %q", tc.name, err, tc.want)
+		}
+		if _, err := dst.Manifest("sanctions"); err == nil {
+			t.Errorf("%s: manifest stored", tc.name)
+		}
+		srv.Close()
+	}
+
+	if _, err := NewPageStore(t.TempDir(), maxPageBytes+1); err == nil {
+		t.Error("NewPageStore accepted a page size over the limit")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// Parallel batches.
+// Most of the cost of an operation is the SHA1 of the item (and, for lookups
+// of a large filter, the cache misses on its buckets). Batches larger than a
+// threshold are split into contiguous chunks, one per worker of a bounded
+// pool, so a batch of a million items starts GOMAXPROCS goroutines, not a
+// million. Small batches run on the calling goroutine: below the threshold,
+// starting workers costs more than it saves.
+
+const defaultBatchThreshold = 4096 // minimum items per worker
+
+// WithBatchParallelism sets the minimum number of items per worker of
+// lookupBatch and insertBatch (default 4096) and the maximum number of
+// workers (default GOMAXPROCS at the time of the batch).
+// A workers value of 1 disables parallelism.
+func WithBatchParallelism(threshold, workers int) Option {
+	return func(c *Cuckoo) {
+		c.batchThreshold = threshold
+		c.batchWorkers = workers
+	}
+}
+
+// parallel calls fn on contiguous chunks [lo, hi) covering [0, n),
+// concurrently if n is large enough, and returns once all calls returned.
+// The workers carry the pprof label cuckoo_batch=op: their stacks start in
+// parallel, not at the caller, so a profile could not tell the operations
+// apart otherwise.
+func (c *Cuckoo) parallel(op string, n int, fn func(lo, hi int)) {
+	threshold, workers := c.batchThreshold, c.batchWorkers
+	if threshold <= 0 {
+		threshold = defaultBatchThreshold
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	chunks := min(workers, n/threshold)
+	if chunks <= 1 {
+		fn(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for k := 0; k < chunks; k++ {
+		lo, hi := k*n/chunks, (k+1)*n/chunks
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pprof.Do(context.Background(), pprof.Labels("cuckoo_batch", op), func(context.Context) {
+				fn(lo, hi)
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// insertBatch inserts the items in order. The items are hashed in parallel
+// (see parallel), then stored one by one: placing a fingerprint may relocate
+// others anywhere in the filter, so stores cannot run concurrently.
+// On error, the items before the failing one are inserted and the others are
+// not; the error wraps the error of insert.
+func (c *Cuckoo) insertBatch(items []string) error {
+	if c.latency != nil {
+		// the batch is recorded as one insert per item
+		start := time.Now()
+		defer func() {
+			per := time.Since(start) / time.Duration(max(len(items), 1))
+			c.latency[OpInsert].observeN(per, uint64(len(items)))
+		}()
+	}
+
+	probes := make([]batchProbe, len(items))
+	c.parallel("insert", len(items), func(lo, hi int) {
+		for pos := lo; pos < hi; pos++ {
+			i1, i2, f := c.hashes(items[pos])
+			probes[pos] = batchProbe{i1: i1, i2: i2, f: f}
+		}
+	})
+
+	for pos, p := range probes {
+		// Refuse empty keys unless allowed with WithEmptyKeys, as insert does
+		if items[pos] == "" && !c.allowEmpty {
+			return fmt.Errorf("batch item %d: %w", pos, ErrEmptyKey)
+		}
+		if err := c.add(p.i1, p.i2, p.f); err != nil {
+			return fmt.Errorf("batch item %d: %w", pos, err)
+		}
+	}
+	return nil
+}
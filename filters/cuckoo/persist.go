@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File writing and fault injection.
+// Every file the filters write goes through writeAtomic: the contents go to
+// a temporary file in the same directory, which is synced and renamed over
+// the final name, so readers (and a restart after a crash) see the old file
+// or the new one, never a mix.
+// The allocation of bucket storage and the steps of writeAtomic call the
+// hooks of faults, which tests set to simulate allocation failures, ENOSPC,
+// short writes and torn pages (a write stopping midway, as on power loss),
+// and check that recovery never yields a filter missing items: the loaders
+// reject what they cannot verify (snapshot checksum, page hashes, counts),
+// and the previous file stays in place.
+
+// faultHooks are the injected faults; a nil hook injects nothing
+type faultHooks struct {
+	// alloc is called before bucket storage of size bytes is allocated;
+	// an error fails the allocation
+	alloc func(size uint) error
+	// write is called for every write to the file at path: it returns how
+	// many bytes of p are actually written, and the error of the write
+	write func(path string, p []byte) (int, error)
+	// sync is called before the file at path is synced; an error fails it
+	sync func(path string) error
+	// rename is called before a file is renamed; an error fails it
+	rename func(oldpath, newpath string) error
+}
+
+// faults are the faults injected by tests, none outside of them
+var faults faultHooks
+
+// tryAllocSlots returns size zeroed bytes of bucket storage, or the error
+// of a failed allocation
+func (c *Cuckoo) tryAllocSlots(size uint) ([]byte, error) {
+	if faults.alloc != nil {
+		if err := faults.alloc(size); err != nil {
+			return nil, err
+		}
+	}
+	if c.offHeap {
+		return mmapSlots(size)
+	}
+	return make([]byte, size), nil
+}
+
+// faultWriter applies the write hook to the writes to a file
+type faultWriter struct {
+	f *os.File
+}
+
+func (w faultWriter) Write(p []byte) (int, error) {
+	if faults.write == nil {
+		return w.f.Write(p)
+	}
+	n, ferr := faults.write(w.f.Name(), p)
+	n, err := w.f.Write(p[:min(max(n, 0), len(p))])
+	switch {
+	case err != nil:
+		return n, err
+	case ferr != nil:
+		return n, ferr
+	case n < len(p):
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// writeAtomic writes a file with fill and renames it to path once complete.
+// pattern names the temporary file, as for os.CreateTemp.
+func writeAtomic(path, pattern string, fill func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), pattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := fill(faultWriter{tmp}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if faults.sync != nil {
+		if err := faults.sync(tmp.Name()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if faults.rename != nil {
+		if err := faults.rename(tmp.Name(), path); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeFile writes data to the file at path with writeAtomic
+func writeFile(path string, data []byte) error {
+	return writeAtomic(path, ".tmp-*", func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+var errInjected = errors.New("injected fault")
+
+// withFaults injects the faults of hooks until the end of the test
+func withFaults(t *testing.T, hooks faultHooks) {
+	t.Helper()
+	faults = hooks
+	t.Cleanup(func() { faults = faultHooks{} })
+}
+
+// writeFaults are the write failures a file write must survive
+var writeFaults = []struct {
+	name  string
+	hooks faultHooks
+}{
+	{"ENOSPC", faultHooks{write: func(string, []byte) (int, error) { return 0, syscall.ENOSPC }}},
+	{"short write", faultHooks{write: func(_ string, p []byte) (int, error) { return len(p) / 2, nil }}},
+	{"torn page", faultHooks{write: func(_ string, p []byte) (int, error) { return len(p) / 2, syscall.EIO }}},
+	{"sync", faultHooks{sync: func(string) error { return errInjected }}},
+	{"rename", faultHooks{rename: func(string, string) error { return errInjected }}},
+}
+
+// checkNoTemp fails if a temporary file is left in dir
+func checkNoTemp(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if name := e.Name(); name[0] == '.' {
+			t.Errorf("temporary file %s left behind", name)
+		}
+	}
+}
+
+func TestWriteFileFaults(t *testing.T) {
+	for _, tc := range writeFaults {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file")
+			if err := writeFile(path, []byte("old contents")); err != nil {
+				t.Fatal(err)
+			}
+			withFaults(t, tc.hooks)
+			if err := writeFile(path, []byte("new contents, longer than the old")); err == nil {
+				t.Fatal("write succeeded despite the fault")
+			}
+			if got, err := os.ReadFile(path); err != nil || string(got) != "old contents" {
+				t.Errorf("file holds %q (%v), want the old contents", got, err)
+			}
+			checkNoTemp(t, dir)
+		})
+	}
+}
+
+func TestMigrateSnapshotFaults(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteVersion(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range writeFaults {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "snap")
+			if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			withFaults(t, tc.hooks)
+			if err := migrateSnapshot(path, SnapshotVersion); err == nil {
+				t.Fatal("migration succeeded despite the fault")
+			}
+			faults = faultHooks{}
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := ReadCuckoo(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("snapshot unreadable after a failed migration: %v", err)
+			}
+			if !d.Equal(c) {
+				t.Error("snapshot changed by a failed migration")
+			}
+			checkNoTemp(t, dir)
+		})
+	}
+}
+
+func TestPageStoreFaults(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	for _, tc := range writeFaults {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewPageStore(t.TempDir(), 512)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Put("sanctions", c); err != nil {
+				t.Fatal(err)
+			}
+			d := c.Clone()
+			for i := 500; i < 700; i++ {
+				d.insert(strconv.Itoa(i))
+			}
+			withFaults(t, tc.hooks)
+			if _, err := s.Put("sanctions", d); err == nil {
+				t.Fatal("Put succeeded despite the fault")
+			}
+			faults = faultHooks{}
+			got, err := s.Get("sanctions")
+			if err != nil {
+				t.Fatalf("store unreadable after a failed Put: %v", err)
+			}
+			if !got.Equal(c) {
+				t.Error("stored filter changed by a failed Put")
+			}
+		})
+	}
+}
+
+func TestAllocFaults(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewPageStore(t.TempDir(), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put("sanctions", c); err != nil {
+		t.Fatal(err)
+	}
+
+	withFaults(t, faultHooks{alloc: func(uint) error { return errInjected }})
+	if _, err := ReadCuckoo(bytes.NewReader(buf.Bytes())); !errors.Is(err, errInjected) {
+		t.Errorf("ReadCuckoo: got %v, want the allocation error", err)
+	}
+	if _, err := s.Get("sanctions"); !errors.Is(err, errInjected) {
+		t.Errorf("PageStore.Get: got %v, want the allocation error", err)
+	}
+	if _, err := c.Rekey([]byte("new key"), []string{"a"}); !errors.Is(err, errInjected) {
+		t.Errorf("Rekey: got %v, want the allocation error", err)
+	}
+}
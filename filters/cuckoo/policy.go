@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Policy is an ordered list of rules turning screening results into a decision,
+// so compliance can change the decision logic without changing code.
+// A policy is written one rule per line, "<action> if <expression>":
+//
+//	# comments and blank lines are ignored
+//	block if sanctioned && !allowlisted
+//	review if riskScore > 70
+//
+// Expressions combine variables and numbers with
+// || && ! < <= > >= == != and parentheses.
+// Every filter of the screening verdict is a variable worth 1 when it hit and
+// 0 otherwise; other variables (e.g., riskScore) are passed to Decide.
+// Any non-zero value is true.
+type Policy struct {
+	rules []rule
+}
+
+type rule struct {
+	action string
+	text   string
+	expr   expr
+}
+
+// DefaultAction is returned by Decide when no rule matches
+const DefaultAction = "allow"
+
+// ParsePolicy parses a policy, one rule per line
+func ParsePolicy(text string) (*Policy, error) {
+	p := &Policy{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		action, cond, ok := strings.Cut(s, " if ")
+		action = strings.TrimSpace(action)
+		if !ok || action == "" {
+			return nil, fmt.Errorf("policy line %d: expected \"<action> if <expression>\"", line)
+		}
+		e, err := parseExpr(cond)
+		if err != nil {
+			return nil, fmt.Errorf("policy line %d: %w", line, err)
+		}
+		p.rules = append(p.rules, rule{action: action, text: strings.TrimSpace(cond), expr: e})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Decide returns the action of the first rule whose expression is true for
+// the verdict, or DefaultAction. vars holds extra variables such as risk scores;
+// it may be nil. An expression referring to an unknown variable is an error
+// rather than false, so a typo cannot silently disable a blocking rule.
+// Likewise, a verdict with a screening error (see Verdict.Err) is returned as
+// an error: the failed filter is neither a hit nor a miss.
+func (p *Policy) Decide(v Verdict, vars map[string]float64) (string, error) {
+	if err := v.Err(); err != nil {
+		return "", err
+	}
+	env := make(map[string]float64, len(v.Results)+len(vars))
+	for _, r := range v.Results {
+		env[r.Filter] = boolValue(r.Hit)
+	}
+	for k, x := range vars {
+		env[k] = x
+	}
+
+	for _, r := range p.rules {
+		x, err := r.expr(env)
+		if err != nil {
+			return "", fmt.Errorf("rule %q: %w", r.action+" if "+r.text, err)
+		}
+		if x != 0 {
+			return r.action, nil
+		}
+	}
+	return DefaultAction, nil
+}
+
+// expr is a compiled expression evaluated against variables
+type expr func(env map[string]float64) (float64, error)
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseExpr compiles an expression with a recursive descent parser:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = primary [ ("<" | "<=" | ">" | ">=" | "==" | "!=") primary ]
+//	primary = number | identifier | "(" or ")"
+func parseExpr(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) or() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env map[string]float64) (float64, error) {
+			x, err := l(env)
+			if err != nil || x != 0 {
+				return x, err
+			}
+			return right(env)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) and() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env map[string]float64) (float64, error) {
+			x, err := l(env)
+			if err != nil || x == 0 {
+				return x, err
+			}
+			return right(env)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.peek() == "!" {
+		p.pos++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env map[string]float64) (float64, error) {
+			x, err := e(env)
+			return boolValue(x == 0), err
+		}, nil
+	}
+	return p.compare()
+}
+
+var comparisons = map[string]func(a, b float64) bool{
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+func (p *exprParser) compare() (expr, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	cmp, ok := comparisons[p.peek()]
+	if !ok {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env map[string]float64) (float64, error) {
+		a, err := left(env)
+		if err != nil {
+			return 0, err
+		}
+		b, err := right(env)
+		if err != nil {
+			return 0, err
+		}
+		return boolValue(cmp(a, b)), nil
+	}, nil
+}
+
+func (p *exprParser) primary() (expr, error) {
+	t := p.peek()
+	p.pos++
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		x, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+		return func(map[string]float64) (float64, error) { return x, nil }, nil
+	case isIdentRune(rune(t[0])):
+		return func(env map[string]float64) (float64, error) {
+			x, ok := env[t]
+			if !ok {
+				return 0, fmt.Errorf("unknown variable %q", t)
+			}
+			return x, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits an expression into numbers, identifiers, operators and parentheses
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case strings.ContainsRune("|&<>=!", r):
+			// two-character operators first, then single ones
+			if i+1 < len(s) {
+				if op := s[i : i+2]; op == "||" || op == "&&" || op == "<=" || op == ">=" || op == "==" || op == "!=" {
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if r == '|' || r == '&' || r == '=' {
+				return nil, fmt.Errorf("invalid operator %q", s[i:i+1])
+			}
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case isIdentRune(r):
+			j := i
+			for j < len(s) && isIdentRune(rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingSet is an exact set that is unreachable
+type failingSet struct{}
+
+var errUnreachable = errors.New("exact set unreachable")
+
+func (failingSet) Contains(string) (bool, error) {
+	return false, errUnreachable
+}
+
+func TestDecideScreeningError(t *testing.T) {
+	f := NewCuckooFilter(100, 0.01)
+	f.insert("bad")
+	s := NewScreener()
+	s.Add("sanctioned", f, 0.01, failingSet{})
+	p, err := ParsePolicy("block if sanctioned")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := s.Check("bad")
+	if v.Err() == nil {
+		t.Fatal("verdict has no error")
+	}
+	action, err := p.Decide(v, nil)
+	if !errors.Is(err, errUnreachable) {
+		t.Errorf("got %q, %v, want the exact set error", action, err)
+	}
+
+	// a miss never reaches the exact set
+	if action, err := p.Decide(s.Check("good"), nil); err != nil || action != DefaultAction {
+		t.Errorf("got %q, %v, want %q", action, err, DefaultAction)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	f := NewCuckooFilter(100, 0.01)
+	f.insert("bad")
+	s := NewScreener()
+	s.Add("sanctioned", f, 0.01, MapSet{"bad": {}})
+	p, err := ParsePolicy("block if sanctioned\nreview if riskScore > 70")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		item string
+		risk float64
+		want string
+	}{
+		{"bad", 0, "block"},
+		{"good", 80, "review"},
+		{"good", 10, DefaultAction},
+	} {
+		got, err := p.Decide(s.Check(tc.item), map[string]float64{"riskScore": tc.risk})
+		if err != nil || got != tc.want {
+			t.Errorf("%s, risk %v: got %q, %v, want %q", tc.item, tc.risk, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Pre-sign hooks.
+// Before the MPC nodes sign, the orchestrator runs the request through a
+// chain of hooks, each checking one thing (revoked keys, sanctions,
+// allowlist, nonce reuse, duplicate signing, policy rules). A chain is
+// declared as the ordered list of its hooks; the first hook that does not
+// allow the request decides, and
+// a hook that fails (e.g., its exact set is unreachable) blocks it: signing
+// fails closed.
+
+// SigningRequest is what the hooks know of a request to sign
+type SigningRequest struct {
+	WalletID     string
+	PublicKey    []byte   // public key of the wallet, signing the request
+	KeyShareIDs  []string // key shares taking part in the ceremony
+	Destinations []string // addresses paid by the transaction
+	Amount       uint64   // total paid, in the smallest unit
+	MessageHash  [32]byte // hash to sign
+	Nonce        []byte   // public nonce of the signature (e.g., ECDSA R), if known
+	ChangeClaims []ChangeClaim
+}
+
+// Decision is the outcome of a hook
+type Decision struct {
+	Action string // DefaultAction (allow), BlockAction, or another action such as "review"
+	Hook   string // hook that decided
+	Reason string
+}
+
+// Allowed returns true if the request may be signed
+func (d Decision) Allowed() bool {
+	return d.Action == DefaultAction
+}
+
+// PresignHook checks a signing request
+type PresignHook interface {
+	Name() string
+	Evaluate(ctx context.Context, req SigningRequest) Decision
+}
+
+// ReservingHook is a hook that reserves something for the requests it
+// allows (e.g., AlreadySignedHook reserves the hash to sign). Release gives
+// the reservation back when the request is not signed after all: the chain
+// releases the reservations of the hooks before a hook that blocks, and the
+// orchestrator must release them when signing fails.
+type ReservingHook interface {
+	PresignHook
+	Release(req SigningRequest)
+}
+
+func allow(hook string) Decision {
+	return Decision{Action: DefaultAction, Hook: hook}
+}
+
+func block(hook, reason string, args ...any) Decision {
+	return Decision{Action: BlockAction, Hook: hook, Reason: fmt.Sprintf(reason, args...)}
+}
+
+// PresignChain runs hooks in order
+type PresignChain struct {
+	hooks []PresignHook
+}
+
+// NewPresignChain returns the chain of hooks, run in the given order
+func NewPresignChain(hooks ...PresignHook) *PresignChain {
+	return &PresignChain{hooks: hooks}
+}
+
+// Evaluate returns the decision of the first hook that does not allow the
+// request, or allow if every hook does. A cancelled context blocks it.
+// When the request is blocked, the hooks that allowed it release it (see
+// ReservingHook).
+func (c *PresignChain) Evaluate(ctx context.Context, req SigningRequest) Decision {
+	for i, h := range c.hooks {
+		var d Decision
+		if err := ctx.Err(); err != nil {
+			d = block(h.Name(), "%v", err)
+		} else {
+			d = h.Evaluate(ctx, req)
+		}
+		if !d.Allowed() {
+			c.release(c.hooks[:i], req)
+			return d
+		}
+	}
+	return allow("")
+}
+
+// Release releases the reservations of the hooks of the chain for a request
+// that was allowed but could not be signed
+func (c *PresignChain) Release(req SigningRequest) {
+	c.release(c.hooks, req)
+}
+
+func (c *PresignChain) release(hooks []PresignHook, req SigningRequest) {
+	for _, h := range hooks {
+		if r, ok := h.(ReservingHook); ok {
+			r.Release(req)
+		}
+	}
+}
+
+// SanctionsHook blocks requests paying an address that hits one of the
+// deny-list filters of the screener (with the exact set confirmation of the
+// screener, if any). The other filters of the chain (allowlists, risk maps)
+// are reported in verdicts but never block here, nor do their errors; an
+// error of a deny-list filter blocks. A hook without deny-list blocks every
+// request rather than screen nothing.
+type SanctionsHook struct {
+	Screener *Screener
+	Deny     []string // names of the deny-list filters of the screener
+}
+
+func (h SanctionsHook) Name() string { return "sanctions" }
+
+func (h SanctionsHook) Evaluate(_ context.Context, req SigningRequest) Decision {
+	if len(h.Deny) == 0 {
+		return block(h.Name(), "no deny-list filter configured")
+	}
+	for _, dst := range req.Destinations {
+		v := h.Screener.Check(dst)
+		for _, r := range v.Results {
+			if !slices.Contains(h.Deny, r.Filter) {
+				continue
+			}
+			if r.Err != nil {
+				return block(h.Name(), "%s: filter %s: %v", dst, r.Filter, r.Err)
+			}
+			if r.Hit {
+				return block(h.Name(), "%s matched filter %s", dst, r.Filter)
+			}
+		}
+	}
+	return allow(h.Name())
+}
+
+// AllowlistHook blocks requests paying an address outside the allowlist.
+// A filter would let its false positives through, so the allowlist must be
+// exact: a Cascade over the known universe, or a filter confirmed by its
+// exact set.
+type AllowlistHook struct {
+	Allowlist ExactSet
+}
+
+func (h AllowlistHook) Name() string { return "allowlist" }
+
+func (h AllowlistHook) Evaluate(_ context.Context, req SigningRequest) Decision {
+	for _, dst := range req.Destinations {
+		ok, err := h.Allowlist.Contains(dst)
+		if err != nil {
+			return block(h.Name(), "%s: %v", dst, err)
+		}
+		if !ok {
+			return block(h.Name(), "%s is not allowlisted", dst)
+		}
+	}
+	return allow(h.Name())
+}
+
+// NonceReuseHook blocks a signature whose nonce was seen before: two ECDSA
+// signatures with the same nonce reveal the key. A nonce is recorded as soon
+// as it is evaluated, whether or not the request is signed, since it must
+// never be used again. A false positive blocks a fresh nonce, and the
+// signers run the nonce generation again.
+// It is safe for concurrent use.
+type NonceReuseHook struct {
+	mu     sync.Mutex
+	nonces Filter
+}
+
+// NewNonceReuseHook returns a hook remembering n nonces with the false
+// positive rate e
+func NewNonceReuseHook(n uint, e float64) *NonceReuseHook {
+	return &NonceReuseHook{nonces: NewBlockedBloomFilter(n, e)}
+}
+
+func (h *NonceReuseHook) Name() string { return "nonce-reuse" }
+
+func (h *NonceReuseHook) Evaluate(_ context.Context, req SigningRequest) Decision {
+	if len(req.Nonce) == 0 {
+		return allow(h.Name())
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	nonce := string(req.Nonce)
+	if h.nonces.lookup(nonce) {
+		return block(h.Name(), "nonce %s was used before", hex.EncodeToString(req.Nonce))
+	}
+	if err := h.nonces.insert(nonce); err != nil {
+		return block(h.Name(), "recording nonce: %v", err)
+	}
+	return allow(h.Name())
+}
+
+// AlreadySignedHook blocks a request for a message hash that the same wallet
+// signed already, e.g. a withdrawal replayed by a retrying client or by a
+// compromised upstream service. A request it allows reserves its hash, in
+// the same critical section as the check, so two identical requests
+// arriving together cannot both pass: the second is blocked until the first
+// is signed (MarkSigned), released (Release, when signing fails or a later
+// hook blocks), or its reservation times out after signReservation.
+// Signed hashes are kept for ttl: the check is exact (a map, no filter),
+// and its memory is bounded by the signatures of one ttl.
+// A replay older than ttl is not caught, so ttl must cover the retries of
+// the clients and the validity of the signed transactions.
+// A hook opened with OpenAlreadySignedHook also appends every signed hash to
+// a ledger file, synced before MarkSigned returns, and reloads the hashes
+// not yet expired when opened again: a restart of the orchestrator does not
+// forget what was signed.
+// It is safe for concurrent use.
+type AlreadySignedHook struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	expires map[signedKey]time.Time
+	order   []signedAt              // in the order of MarkSigned, so by expiry
+	pending map[signedKey]time.Time // hashes being signed, until their reservation times out
+	now     func() time.Time
+
+	path   string   // ledger file, "" for none
+	ledger *os.File // open for appending
+	logged int      // records in the ledger file
+	torn   bool     // an append failed: the ledger may end in a partial record
+}
+
+// signedKey is a message hash signed by a wallet
+type signedKey struct {
+	walletID string
+	hash     [32]byte
+}
+
+// signedAt is a signed hash and its expiry at the time it was marked
+type signedAt struct {
+	key     signedKey
+	expires time.Time
+}
+
+// signedRecord is a line of the ledger file
+type signedRecord struct {
+	Wallet  string    `json:"wallet"`
+	Hash    string    `json:"hash"` // hex
+	Expires time.Time `json:"expires"`
+}
+
+// NewAlreadySignedHook returns a hook remembering signed hashes for ttl,
+// in memory only
+func NewAlreadySignedHook(ttl time.Duration) *AlreadySignedHook {
+	return &AlreadySignedHook{
+		ttl:     ttl,
+		expires: make(map[signedKey]time.Time),
+		pending: make(map[signedKey]time.Time),
+		now:     time.Now,
+	}
+}
+
+// signReservation bounds how long a request allowed by AlreadySignedHook
+// blocks its duplicates without being signed or released: longer than a
+// signing ceremony
+const signReservation = 10 * time.Minute
+
+// OpenAlreadySignedHook returns a hook remembering signed hashes for ttl in
+// the ledger file at path, created if it does not exist. The ledger is
+// rewritten without its expired hashes.
+func OpenAlreadySignedHook(path string, ttl time.Duration) (*AlreadySignedHook, error) {
+	h := NewAlreadySignedHook(ttl)
+	h.path = path
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if err := h.compact(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// load reads the hashes of the ledger file not yet expired. A last line
+// without newline is a record torn by a crash during MarkSigned, whose
+// signature was never returned: it is dropped.
+func (h *AlreadySignedHook) load() error {
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	now := h.now()
+	for n := 1; len(data) > 0; n++ {
+		line, rest, complete := bytes.Cut(data, []byte{'\n'})
+		if !complete {
+			break
+		}
+		data = rest
+		var r signedRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("signed ledger %s line %d: %w", h.path, n, err)
+		}
+		k := signedKey{walletID: r.Wallet}
+		hb, err := hex.DecodeString(r.Hash)
+		if err != nil || len(hb) != len(k.hash) {
+			return fmt.Errorf("signed ledger %s line %d: invalid hash %q", h.path, n, r.Hash)
+		}
+		copy(k.hash[:], hb)
+		if !r.Expires.After(now) {
+			continue
+		}
+		h.expires[k] = r.Expires
+		h.order = append(h.order, signedAt{key: k, expires: r.Expires})
+	}
+	// the records are in the order of MarkSigned, but the ttl may have
+	// changed since they were written
+	slices.SortStableFunc(h.order, func(a, b signedAt) int { return a.expires.Compare(b.expires) })
+	return nil
+}
+
+// compact rewrites the ledger file with the hashes not yet expired, and
+// reopens it for appending
+func (h *AlreadySignedHook) compact() error {
+	err := writeAtomic(h.path, ".signed-*", func(w io.Writer) error {
+		for _, e := range h.order {
+			if !h.expires[e.key].Equal(e.expires) {
+				continue
+			}
+			if _, err := w.Write(e.key.record(e.expires)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("compacting signed ledger %s: %w", h.path, err)
+	}
+	if h.ledger != nil {
+		h.ledger.Close()
+	}
+	h.ledger, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	h.logged, h.torn = len(h.expires), false
+	return nil
+}
+
+// record returns the ledger line of a signed hash
+func (k signedKey) record(expires time.Time) []byte {
+	line, _ := json.Marshal(signedRecord{Wallet: k.walletID, Hash: hex.EncodeToString(k.hash[:]), Expires: expires})
+	return append(line, '\n')
+}
+
+// Close closes the ledger file, if any
+func (h *AlreadySignedHook) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ledger == nil {
+		return nil
+	}
+	err := h.ledger.Close()
+	h.ledger = nil
+	return err
+}
+
+func (h *AlreadySignedHook) Name() string { return "already-signed" }
+
+// MarkSigned records a message hash signed by a wallet, and ends its
+// reservation. With a ledger, it
+// returns once the hash is synced to the ledger file; on error the hash is
+// still remembered in memory, but would be forgotten by a restart.
+// The ledger is compacted when it holds more than twice the hashes not yet
+// expired, and after a failed append, which may have left a partial record.
+func (h *AlreadySignedHook) MarkSigned(walletID string, hash [32]byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	h.expire(now)
+	k := signedKey{walletID: walletID, hash: hash}
+	delete(h.pending, k)
+	h.expires[k] = now.Add(h.ttl)
+	h.order = append(h.order, signedAt{key: k, expires: now.Add(h.ttl)})
+	if h.path == "" {
+		return nil
+	}
+	if h.ledger == nil {
+		return fmt.Errorf("signed ledger %s: %w", h.path, os.ErrClosed)
+	}
+	if h.torn || h.logged >= 2*len(h.expires)+signedCompactSlack {
+		return h.compact()
+	}
+	if _, err := (faultWriter{h.ledger}).Write(k.record(now.Add(h.ttl))); err != nil {
+		h.torn = true
+		return fmt.Errorf("signed ledger %s: %w", h.path, err)
+	}
+	if err := h.ledger.Sync(); err != nil {
+		h.torn = true
+		return fmt.Errorf("signed ledger %s: %w", h.path, err)
+	}
+	h.logged++
+	return nil
+}
+
+// signedCompactSlack is the number of expired records a ledger may hold
+// beyond its live ones before it is compacted, so small ledgers are not
+// rewritten at every signature
+const signedCompactSlack = 1024
+
+// expire forgets the hashes signed more than ttl ago, and the reservations
+// timed out
+func (h *AlreadySignedHook) expire(now time.Time) {
+	for k, until := range h.pending {
+		if !until.After(now) {
+			delete(h.pending, k)
+		}
+	}
+	n := 0
+	for _, e := range h.order {
+		if e.expires.After(now) {
+			break
+		}
+		// a hash marked again since has a later expiry, kept
+		if h.expires[e.key].Equal(e.expires) {
+			delete(h.expires, e.key)
+		}
+		n++
+	}
+	h.order = h.order[n:]
+}
+
+// AlreadySigned returns true if the wallet signed the digest less than ttl ago
+func (h *AlreadySignedHook) AlreadySigned(walletID string, digest [32]byte) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	exp, ok := h.expires[signedKey{walletID: walletID, hash: digest}]
+	return ok && exp.After(h.now())
+}
+
+// Evaluate blocks a hash signed or being signed by the wallet, and reserves
+// the others
+func (h *AlreadySignedHook) Evaluate(_ context.Context, req SigningRequest) Decision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	k := signedKey{walletID: req.WalletID, hash: req.MessageHash}
+	if exp, ok := h.expires[k]; ok && exp.After(now) {
+		return block(h.Name(), "message %s was already signed by wallet %s", hex.EncodeToString(req.MessageHash[:]), req.WalletID)
+	}
+	if until, ok := h.pending[k]; ok && until.After(now) {
+		return block(h.Name(), "message %s is being signed by wallet %s", hex.EncodeToString(req.MessageHash[:]), req.WalletID)
+	}
+	h.pending[k] = now.Add(signReservation)
+	return allow(h.Name())
+}
+
+// Release ends the reservation of a request that was not signed
+func (h *AlreadySignedHook) Release(req SigningRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.pending, signedKey{walletID: req.WalletID, hash: req.MessageHash})
+}
+
+// PolicyHook decides with a policy on variables computed from the request
+// (e.g., ChangeVerifier.Vars). A variable source that fails blocks the request.
+type PolicyHook struct {
+	Policy *Policy
+	Vars   []func(ctx context.Context, req SigningRequest) (map[string]float64, error)
+}
+
+func (h PolicyHook) Name() string { return "policy" }
+
+func (h PolicyHook) Evaluate(ctx context.Context, req SigningRequest) Decision {
+	vars := make(map[string]float64)
+	for _, source := range h.Vars {
+		vs, err := source(ctx, req)
+		if err != nil {
+			return block(h.Name(), "%v", err)
+		}
+		for k, x := range vs {
+			vars[k] = x
+		}
+	}
+	action, err := h.Policy.Decide(Verdict{}, vars)
+	if err != nil {
+		return block(h.Name(), "%v", err)
+	}
+	return Decision{Action: action, Hook: h.Name()}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSanctionsHookDenyOnly(t *testing.T) {
+	sanctioned := NewCuckooFilter(100, 0.01)
+	sanctioned.insert("sanctioned-addr")
+	allowed := NewCuckooFilter(100, 0.01)
+	allowed.insert("exchange-addr")
+	allowed.insert("sanctioned-addr")
+
+	s := NewScreener()
+	s.Add("allowlist", allowed, 0.01, MapSet{"exchange-addr": {}, "sanctioned-addr": {}})
+	s.Add("ofac", sanctioned, 0.01, MapSet{"sanctioned-addr": {}})
+	failing := NewScreener()
+	failing.Add("allowlist", allowed, 0.01, failingSet{})
+	failing.Add("ofac", sanctioned, 0.01, failingSet{})
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		hook SanctionsHook
+		dst  string
+		want bool
+	}{
+		{"allowlisted", SanctionsHook{Screener: s, Deny: []string{"ofac"}}, "exchange-addr", true},
+		{"sanctioned", SanctionsHook{Screener: s, Deny: []string{"ofac"}}, "sanctioned-addr", false},
+		{"clean", SanctionsHook{Screener: s, Deny: []string{"ofac"}}, "other-addr", true},
+		{"allowlist error", SanctionsHook{Screener: failing, Deny: []string{"ofac"}}, "exchange-addr", true},
+		{"deny-list error", SanctionsHook{Screener: failing, Deny: []string{"ofac"}}, "sanctioned-addr", false},
+		{"no deny-list", SanctionsHook{Screener: s}, "other-addr", false},
+	} {
+		d := tc.hook.Evaluate(ctx, SigningRequest{Destinations: []string{tc.dst}})
+		if d.Allowed() != tc.want {
+			t.Errorf("%s: allowed %v (%s), want %v", tc.name, d.Allowed(), d.Reason, tc.want)
+		}
+	}
+}
+
+func TestAlreadySignedHook(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	h := NewAlreadySignedHook(time.Hour)
+	h.now = func() time.Time { return now }
+	ctx := context.Background()
+	hash := [32]byte{1}
+	req := func(wallet string) SigningRequest { return SigningRequest{WalletID: wallet, MessageHash: hash} }
+
+	h.MarkSigned("w1", hash)
+	if h.Evaluate(ctx, req("w1")).Allowed() {
+		t.Error("hash signed by the wallet allowed again")
+	}
+	if !h.Evaluate(ctx, req("w2")).Allowed() {
+		t.Error("hash signed by another wallet blocked")
+	}
+
+	now = now.Add(50 * time.Minute)
+	h.MarkSigned("w1", hash) // signed again: kept for another ttl
+	now = now.Add(30 * time.Minute)
+	h.MarkSigned("w3", [32]byte{3})
+	if h.Evaluate(ctx, req("w1")).Allowed() {
+		t.Error("hash signed again forgotten at the expiry of its first signature")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if !h.Evaluate(ctx, req("w1")).Allowed() {
+		t.Error("hash still blocked after its ttl")
+	}
+	h.MarkSigned("w4", [32]byte{4})
+	if len(h.expires) != 1 || len(h.order) != 1 {
+		t.Errorf("%d hashes and %d queued after expiry, want 1", len(h.expires), len(h.order))
+	}
+}
+
+func TestAlreadySignedLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signed.jsonl")
+	// far ahead of the real clock, see OpenAlreadySignedHook below
+	now := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	open := func() *AlreadySignedHook {
+		t.Helper()
+		// the clock is set before the ledger is loaded
+		h := NewAlreadySignedHook(time.Hour)
+		h.now = func() time.Time { return now }
+		h.path = path
+		if err := h.load(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.compact(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+
+	h := open()
+	for i := byte(1); i <= 3; i++ {
+		if err := h.MarkSigned("w1", [32]byte{i}); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(20 * time.Minute)
+	}
+	h.Close()
+
+	// a crash while appending leaves a partial last record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"wallet":"w1","hash":"04`)
+	f.Close()
+
+	h = open()
+	if h.AlreadySigned("w1", [32]byte{1}) {
+		t.Error("hash past its ttl reloaded")
+	}
+	if !h.AlreadySigned("w1", [32]byte{2}) || !h.AlreadySigned("w1", [32]byte{3}) {
+		t.Error("signed hashes forgotten by a restart")
+	}
+	if h.AlreadySigned("w2", [32]byte{2}) {
+		t.Error("hash signed by another wallet reported")
+	}
+	if h.logged != 2 {
+		t.Errorf("%d records after reopening, want 2", h.logged)
+	}
+
+	// a failed append is reported, and the next one rewrites the ledger
+	withFaults(t, faultHooks{write: func(_ string, p []byte) (int, error) { return len(p) / 2, nil }})
+	if err := h.MarkSigned("w2", [32]byte{5}); err == nil {
+		t.Error("failed append not reported")
+	}
+	faults = faultHooks{}
+	if err := h.MarkSigned("w2", [32]byte{6}); err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+	h = open()
+	for _, hash := range [][32]byte{{2}, {3}} {
+		if !h.AlreadySigned("w1", hash) {
+			t.Errorf("hash %x forgotten after a failed append", hash[0])
+		}
+	}
+	for _, hash := range [][32]byte{{5}, {6}} {
+		if !h.AlreadySigned("w2", hash) {
+			t.Errorf("hash %x forgotten after a failed append", hash[0])
+		}
+	}
+
+	// expired records are compacted away
+	for i := 0; i < 3*signedCompactSlack; i++ {
+		now = now.Add(2 * time.Hour)
+		if err := h.MarkSigned("w3", [32]byte{byte(i), byte(i >> 8)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.logged > signedCompactSlack+2 {
+		t.Errorf("%d records for %d live hashes", h.logged, len(h.expires))
+	}
+	h.Close()
+
+	// with the real clock, the last hash is still live
+	h, err = OpenAlreadySignedHook(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	last := 3*signedCompactSlack - 1
+	if !h.AlreadySigned("w3", [32]byte{byte(last), byte(last >> 8)}) {
+		t.Error("OpenAlreadySignedHook did not load the ledger")
+	}
+}
+
+// TestAlreadySignedConcurrent checks that of identical requests evaluated
+// together, one only is allowed until it is signed or released
+func TestAlreadySignedConcurrent(t *testing.T) {
+	h := NewAlreadySignedHook(time.Hour)
+	ctx := context.Background()
+	req := SigningRequest{WalletID: "w1", MessageHash: [32]byte{1}}
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if h.Evaluate(ctx, req).Allowed() {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 1 {
+		t.Fatalf("%d identical requests allowed, want 1", n)
+	}
+
+	// signing failed: a retry is allowed, and reserves the hash again
+	h.Release(req)
+	if !h.Evaluate(ctx, req).Allowed() {
+		t.Fatal("request blocked after its reservation was released")
+	}
+	if h.Evaluate(ctx, req).Allowed() {
+		t.Fatal("request allowed twice")
+	}
+	if err := h.MarkSigned(req.WalletID, req.MessageHash); err != nil {
+		t.Fatal(err)
+	}
+	if !h.AlreadySigned(req.WalletID, req.MessageHash) || h.Evaluate(ctx, req).Allowed() {
+		t.Error("signed request allowed again")
+	}
+
+	// a reservation that is never signed nor released times out
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	other := SigningRequest{WalletID: "w1", MessageHash: [32]byte{2}}
+	h.Evaluate(ctx, other)
+	now = now.Add(signReservation)
+	if !h.Evaluate(ctx, other).Allowed() {
+		t.Error("request blocked by a timed out reservation")
+	}
+}
+
+// blockingHook blocks every request
+type blockingHook struct{}
+
+func (blockingHook) Name() string { return "blocking" }
+
+func (blockingHook) Evaluate(context.Context, SigningRequest) Decision {
+	return block("blocking", "blocked")
+}
+
+func TestPresignChainRelease(t *testing.T) {
+	signed := NewAlreadySignedHook(time.Hour)
+	ctx := context.Background()
+	req := SigningRequest{WalletID: "w1", MessageHash: [32]byte{1}}
+
+	if NewPresignChain(signed, blockingHook{}).Evaluate(ctx, req).Allowed() {
+		t.Fatal("blocking chain allowed the request")
+	}
+	chain := NewPresignChain(signed)
+	if !chain.Evaluate(ctx, req).Allowed() {
+		t.Fatal("request reserved by a chain that blocked it")
+	}
+	chain.Release(req)
+	if !chain.Evaluate(ctx, req).Allowed() {
+		t.Error("request reserved after the chain released it")
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Push list updates.
+// A compliance vendor pushes list changes as mutation batches to an HTTP
+// endpoint, instead of waiting for the next full list download. A batch is
+// a JSON document signed by the vendor:
+//
+//	{"batch_id": "2026-10-15-0042", "seq": 42, "time": "2026-10-15T09:00:00Z",
+//	 "mutations": [
+//	  {"list": "ofac", "op": "add", "item": "bc1q..."},
+//	  {"list": "ofac", "op": "remove", "item": "1A1z..."}]}
+//
+// with the signature of the raw body in the X-Signature header, either
+// "hmac-sha256=<hex>" (shared secret) or "ed25519=<base64>" (vendor key).
+// Vendors retry a batch until they get a 2xx answer, so batches are
+// deduplicated by batch ID: the IDs of the batches applied are kept for
+// pushMaxAge, and a batch with a known ID is acknowledged without applying
+// it again. The ID set is exact, not a filter: a false positive would
+// acknowledge a new batch without applying it. Batches older than
+// pushMaxAge (or more than pushMaxSkew in the future) are refused, so a
+// forgotten ID can never be replayed.
+// The vendor also numbers its batches with consecutive sequence numbers, and
+// batches are applied in that order: a batch past the next one is refused
+// until the missing ones arrive, and a new batch reusing the sequence
+// number of an applied one is refused. The first batch of a receiver
+// without state sets the sequence.
+//
+// The receiver keeps its state in one file, rewritten atomically (see
+// writeAtomic) before a batch takes effect: the sequence number of the last
+// batch applied, the IDs of the recent batches, and the items of every list.
+// After a restart, AddList rebuilds each list from the file, so a replayed
+// batch that is acknowledged as applied is really in the lists. The file
+// holds the items, not the filters: a list can be registered again with
+// another size.
+// A batch is applied atomically: it is checked as a whole and written to the
+// state file, then applied to the lists under a write lock, so a lookup
+// never sees half a batch. A mutation that fails (a full filter) undoes the
+// mutations before it, and the previous state file is written back. Each
+// list keeps its exact set next to its filter, so a removal only deletes an
+// item that is in the list (deleting any other item from a cuckoo filter
+// could delete the fingerprint of a listed one).
+
+const (
+	// maxBatchBytes bounds the size of a pushed batch
+	maxBatchBytes = 16 << 20
+	// pushMaxAge is the age past which a batch is refused
+	pushMaxAge = 24 * time.Hour
+	// pushMaxSkew is how far in the future the time of a batch may be
+	pushMaxSkew = 5 * time.Minute
+)
+
+var (
+	// ErrBatchOutOfOrder is returned for a batch past the next sequence
+	// number, which must wait for the batches before it, and for a new batch
+	// reusing the sequence number of an applied one
+	ErrBatchOutOfOrder = errors.New("batch out of order")
+	// ErrBatchExpired is returned for a batch outside the accepted time window
+	ErrBatchExpired = errors.New("batch outside the accepted time window")
+)
+
+// Mutation is one change of a pushed batch
+type Mutation struct {
+	List string `json:"list"`
+	Op   string `json:"op"` // "add" or "remove"
+	Item string `json:"item"`
+}
+
+// MutationBatch is a pushed batch of list changes
+type MutationBatch struct {
+	BatchID   string     `json:"batch_id"`
+	Seq       uint64     `json:"seq"`  // sequence number, from 1
+	Time      time.Time  `json:"time"` // when the vendor issued the batch
+	Mutations []Mutation `json:"mutations"`
+}
+
+// PushedList is a list updated by pushed batches: a cuckoo filter and its
+// exact set. It is a Lookuper, so it can be added to a Screener.
+type PushedList struct {
+	p    *PushReceiver
+	name string
+}
+
+var _ Lookuper = (*PushedList)(nil)
+
+func (l *PushedList) lookup(item string) bool {
+	l.p.mu.RLock()
+	defer l.p.mu.RUnlock()
+	return l.p.lists[l.name].filter.lookup(item)
+}
+
+// Contains confirms a filter hit against the exact set (see ExactSet)
+func (l *PushedList) Contains(item string) (bool, error) {
+	l.p.mu.RLock()
+	defer l.p.mu.RUnlock()
+	_, ok := l.p.lists[l.name].exact[item]
+	return ok, nil
+}
+
+// pushedVersion is the contents of a list
+type pushedVersion struct {
+	filter *Cuckoo
+	exact  MapSet
+}
+
+// pushSnapshot is the state file of a receiver
+type pushSnapshot struct {
+	Seq     uint64               `json:"seq"`
+	BatchID string               `json:"batch_id"`
+	Applied time.Time            `json:"applied"`
+	Batches map[string]time.Time `json:"batches"` // IDs of the recent batches, by batch time
+	Lists   map[string][]string  `json:"lists"`   // items of every list, sorted
+}
+
+// PushReceiver applies signed mutation batches to named lists.
+// It is safe for concurrent use.
+type PushReceiver struct {
+	batchMu sync.Mutex   // serializes batches and list registrations
+	mu      sync.RWMutex // held for writing while lists change
+
+	lists     map[string]*pushedVersion
+	restored  map[string][]string  // lists of the state file not registered yet
+	batches   map[string]time.Time // IDs of the batches applied in the last pushMaxAge
+	seq       uint64               // sequence number of the last batch applied, 0 for none
+	last      pushSnapshot         // state written by the last batch, without the lists
+	hmacKey   []byte               // nil if HMAC signatures are not accepted
+	vendorKey ed25519.PublicKey    // nil if Ed25519 signatures are not accepted
+	statePath string
+	now       func() time.Time
+}
+
+// NewPushReceiver returns a receiver accepting batches signed with hmacKey
+// or vendorKey (either may be nil, not both), keeping its state in the file
+// at statePath, which is created by the first batch applied
+func NewPushReceiver(hmacKey []byte, vendorKey ed25519.PublicKey, statePath string) (*PushReceiver, error) {
+	if hmacKey == nil && vendorKey == nil {
+		return nil, errors.New("push receiver needs an HMAC key or a vendor public key")
+	}
+	p := &PushReceiver{
+		lists:     make(map[string]*pushedVersion),
+		restored:  make(map[string][]string),
+		batches:   make(map[string]time.Time),
+		hmacKey:   hmacKey,
+		vendorKey: vendorKey,
+		statePath: statePath,
+		now:       time.Now,
+	}
+
+	data, err := os.ReadFile(statePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var st pushSnapshot
+		if err := json.Unmarshal(data, &st); err != nil {
+			return nil, fmt.Errorf("push state %s: %w", statePath, err)
+		}
+		if st.Seq > 0 && st.Lists == nil {
+			// a mark without the lists would acknowledge replayed batches
+			// that are not in the (empty) lists
+			return nil, fmt.Errorf("push state %s has no lists: restore them or remove the file", statePath)
+		}
+		p.seq = st.Seq
+		p.last = pushSnapshot{Seq: st.Seq, BatchID: st.BatchID, Applied: st.Applied, Batches: st.Batches}
+		for id, at := range st.Batches {
+			p.batches[id] = at
+		}
+		for name, items := range st.Lists {
+			p.restored[name] = items
+		}
+	}
+	return p, nil
+}
+
+// AddList registers a list that batches may update, sized for n items
+// with the false positive rate e, and returns it. A list of the state file
+// is rebuilt with its items.
+func (p *PushReceiver) AddList(name string, n uint, e float64) (*PushedList, error) {
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+	l := &pushedVersion{filter: NewCuckooFilter(n, e), exact: make(MapSet)}
+	for _, item := range p.restored[name] {
+		if err := l.filter.insert(item); err != nil {
+			return nil, fmt.Errorf("restoring list %s: %w", name, err)
+		}
+		l.exact.Add(item)
+	}
+	p.mu.Lock()
+	delete(p.restored, name)
+	p.lists[name] = l
+	p.mu.Unlock()
+	return &PushedList{p: p, name: name}, nil
+}
+
+// verify checks the signature header of a body
+func (p *PushReceiver) verify(body []byte, header string) error {
+	scheme, sig, ok := strings.Cut(header, "=")
+	if !ok {
+		return errors.New("missing signature")
+	}
+	switch {
+	case scheme == "hmac-sha256" && p.hmacKey != nil:
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return errors.New("malformed signature")
+		}
+		mac := hmac.New(sha256.New, p.hmacKey)
+		mac.Write(body)
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case scheme == "ed25519" && p.vendorKey != nil:
+		got, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil {
+			return errors.New("malformed signature")
+		}
+		if !ed25519.Verify(p.vendorKey, body, got) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signature scheme %q not accepted", scheme)
+}
+
+// Apply applies a verified batch. It returns false, without error, if the
+// batch was applied before, and ErrBatchOutOfOrder if a batch before it was
+// not applied yet.
+func (p *PushReceiver) Apply(b MutationBatch) (bool, error) {
+	if b.BatchID == "" || b.Seq == 0 {
+		return false, errors.New("batch has no ID or sequence number")
+	}
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+	if _, ok := p.batches[b.BatchID]; ok {
+		return false, nil
+	}
+	now := p.now()
+	if b.Time.Before(now.Add(-pushMaxAge)) || b.Time.After(now.Add(pushMaxSkew)) {
+		return false, fmt.Errorf("batch %s issued at %s: %w", b.BatchID, b.Time.Format(time.RFC3339), ErrBatchExpired)
+	}
+	switch {
+	case b.Seq <= p.seq:
+		return false, fmt.Errorf("batch %s: sequence %d was applied already: %w", b.BatchID, b.Seq, ErrBatchOutOfOrder)
+	case p.seq != 0 && b.Seq != p.seq+1:
+		return false, fmt.Errorf("batch %s: sequence %d, expecting %d: %w", b.BatchID, b.Seq, p.seq+1, ErrBatchOutOfOrder)
+	}
+
+	// Only batches change the lists, and batchMu is held: the lists are
+	// read without mu until the batch is applied.
+	changes, err := p.changes(b.Mutations)
+	if err != nil {
+		return false, err
+	}
+	batches := make(map[string]time.Time, len(p.batches)+1)
+	for id, at := range p.batches {
+		if at.After(now.Add(-pushMaxAge)) {
+			batches[id] = at
+		}
+	}
+	batches[b.BatchID] = b.Time
+
+	// the state is written first: a batch acknowledged but lost by a
+	// restart would never be pushed again
+	next := pushSnapshot{Seq: b.Seq, BatchID: b.BatchID, Applied: now.UTC(), Batches: batches}
+	if err := p.writeState(next, changes); err != nil {
+		return false, fmt.Errorf("recording batch %s: %w", b.BatchID, err)
+	}
+
+	p.mu.Lock()
+	err = p.applyChanges(changes)
+	p.mu.Unlock()
+	if err != nil {
+		// the lists are unchanged: put the state back, or a restart would
+		// apply the batch from the file
+		if werr := p.writeState(p.last, nil); werr != nil {
+			err = errors.Join(err, fmt.Errorf("restoring push state: %w", werr))
+		}
+		return false, fmt.Errorf("batch %s: %w", b.BatchID, err)
+	}
+	p.seq, p.batches, p.last = b.Seq, batches, next
+	return true, nil
+}
+
+// change is a mutation that changes a list: the add of an item not in the
+// list, the remove of an item in it
+type change struct {
+	list *pushedVersion
+	m    Mutation
+}
+
+// changes checks the mutations of a batch and returns those changing a
+// list, in order
+func (p *PushReceiver) changes(ms []Mutation) ([]change, error) {
+	var cs []change
+	listed := make(map[Mutation]bool) // by list and item, with Op unset
+	for i, m := range ms {
+		l, ok := p.lists[m.List]
+		if !ok {
+			return nil, fmt.Errorf("mutation %d: unknown list %q", i, m.List)
+		}
+		key := Mutation{List: m.List, Item: m.Item}
+		in, seen := listed[key]
+		if !seen {
+			_, in = l.exact[m.Item]
+		}
+		switch m.Op {
+		case "add":
+			if !in {
+				cs = append(cs, change{list: l, m: m})
+			}
+			listed[key] = true
+		case "remove":
+			if in {
+				cs = append(cs, change{list: l, m: m})
+			}
+			listed[key] = false
+		default:
+			return nil, fmt.Errorf("mutation %d: unknown op %q", i, m.Op)
+		}
+	}
+	return cs, nil
+}
+
+// applyChanges applies changes in order. If one fails (a full filter), the
+// changes before it are undone, the last first, and the lists are as
+// before the call. mu must be held for writing.
+func (p *PushReceiver) applyChanges(cs []change) error {
+	for i, c := range cs {
+		if c.m.Op == "remove" {
+			c.list.filter.delete(c.m.Item)
+			delete(c.list.exact, c.m.Item)
+			continue
+		}
+		if err := c.list.filter.insert(c.m.Item); err != nil {
+			p.undo(cs[:i])
+			return fmt.Errorf("adding %q to list %s: %w", c.m.Item, c.m.List, err)
+		}
+		c.list.exact.Add(c.m.Item)
+	}
+	return nil
+}
+
+// undo reverts changes, the last first. An item removed is inserted back
+// into a filter holding fewer items than before the batch; should that
+// insert still fail, the item is dropped from the exact set too, so the
+// list stays consistent, and the state file (which has the item) restores
+// it at the next restart.
+func (p *PushReceiver) undo(cs []change) {
+	for _, c := range slices.Backward(cs) {
+		if c.m.Op == "add" {
+			c.list.filter.delete(c.m.Item)
+			delete(c.list.exact, c.m.Item)
+			continue
+		}
+		if c.list.filter.insert(c.m.Item) == nil {
+			c.list.exact.Add(c.m.Item)
+		}
+	}
+}
+
+// writeState writes the state file: st, with the items of the lists after
+// changes
+func (p *PushReceiver) writeState(st pushSnapshot, cs []change) error {
+	adds := make(map[string]MapSet)
+	removes := make(map[string]MapSet)
+	for _, c := range cs {
+		set := adds
+		if c.m.Op == "remove" {
+			set = removes
+		}
+		if set[c.m.List] == nil {
+			set[c.m.List] = make(MapSet)
+		}
+		set[c.m.List].Add(c.m.Item)
+		// an item added then removed by the batch, or the reverse
+		if c.m.Op == "remove" {
+			delete(adds[c.m.List], c.m.Item)
+		} else {
+			delete(removes[c.m.List], c.m.Item)
+		}
+	}
+
+	st.Lists = make(map[string][]string, len(p.lists)+len(p.restored))
+	for name, items := range p.restored {
+		st.Lists[name] = items
+	}
+	for name, l := range p.lists {
+		items := make([]string, 0, len(l.exact)+len(adds[name]))
+		for item := range l.exact {
+			if _, ok := removes[name][item]; !ok {
+				items = append(items, item)
+			}
+		}
+		for item := range adds[name] {
+			if _, ok := l.exact[item]; !ok {
+				items = append(items, item)
+			}
+		}
+		slices.Sort(items)
+		st.Lists[name] = items
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFile(p.statePath, data)
+}
+
+// ServeHTTP receives a signed batch (POST). It answers 204 once the batch
+// is applied or if it was applied before, 401 for a bad signature, 400 for a
+// malformed batch, 409 for a batch out of order (the vendor retries it after
+// the missing ones) and 422 for a batch that cannot be applied.
+func (p *PushReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxBatchBytes {
+		http.Error(w, "batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := p.verify(body, r.Header.Get("X-Signature")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var b MutationBatch
+	if err := json.Unmarshal(body, &b); err != nil {
+		http.Error(w, "malformed batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := p.Apply(b); err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, ErrBatchOutOfOrder) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var pushKey = []byte("push test key")
+
+func newPushReceiver(t *testing.T, statePath string) *PushReceiver {
+	t.Helper()
+	p, err := NewPushReceiver(pushKey, nil, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := p.AddList(name, 1000, 0.001); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func addBatch(seq uint64, lists ...string) MutationBatch {
+	b := MutationBatch{BatchID: "batch-" + strconv.FormatUint(seq, 10), Seq: seq, Time: time.Now()}
+	for _, l := range lists {
+		b.Mutations = append(b.Mutations, Mutation{List: l, Op: "add", Item: "item-" + strconv.FormatUint(seq, 10)})
+	}
+	return b
+}
+
+// listed returns true if the list holds item in its filter and exact set
+func listed(p *PushReceiver, list, item string) bool {
+	l := &PushedList{p: p, name: list}
+	ok, _ := l.Contains(item)
+	return l.lookup(item) && ok
+}
+
+func TestPushApply(t *testing.T) {
+	p := newPushReceiver(t, filepath.Join(t.TempDir(), "state"))
+	if ok, err := p.Apply(addBatch(7, "a", "b")); !ok || err != nil {
+		t.Fatalf("first batch: %v, %v", ok, err)
+	}
+	if !listed(p, "a", "item-7") || !listed(p, "b", "item-7") {
+		t.Error("batch not applied to both lists")
+	}
+	if ok, err := p.Apply(addBatch(7, "a")); ok || err != nil {
+		t.Errorf("duplicate batch: %v, %v, want it acknowledged and skipped", ok, err)
+	}
+	if _, err := p.Apply(addBatch(9, "a")); !errors.Is(err, ErrBatchOutOfOrder) {
+		t.Errorf("batch past the next: got %v, want ErrBatchOutOfOrder", err)
+	}
+	if ok, err := p.Apply(addBatch(8, "a")); !ok || err != nil {
+		t.Errorf("next batch: %v, %v", ok, err)
+	}
+}
+
+func TestPushReplayAfterRestart(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state")
+	p := newPushReceiver(t, state)
+	for seq := uint64(1); seq <= 3; seq++ {
+		if _, err := p.Apply(addBatch(seq, "a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove := addBatch(4)
+	remove.Mutations = []Mutation{{List: "a", Op: "remove", Item: "item-1"}}
+	if _, err := p.Apply(remove); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newPushReceiver(t, state)
+	for seq, want := range map[int]bool{1: false, 2: true, 3: true} {
+		if got := listed(restarted, "a", "item-"+strconv.Itoa(seq)); got != want {
+			t.Errorf("item-%d listed %v after a restart, want %v", seq, got, want)
+		}
+	}
+	if ok, err := restarted.Apply(addBatch(2, "a")); ok || err != nil {
+		t.Errorf("replayed batch: %v, %v, want it acknowledged and skipped", ok, err)
+	}
+	reused := addBatch(2, "b")
+	reused.BatchID = "other"
+	if _, err := restarted.Apply(reused); !errors.Is(err, ErrBatchOutOfOrder) {
+		t.Errorf("new batch reusing a sequence number: got %v, want ErrBatchOutOfOrder", err)
+	}
+	if ok, err := restarted.Apply(addBatch(5, "a", "b")); !ok || err != nil {
+		t.Errorf("next batch after a restart: %v, %v", ok, err)
+	}
+
+	// a list registered after a batch keeps its items in the state
+	partial, err := NewPushReceiver(pushKey, nil, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := partial.AddList("a", 1000, 0.001); err != nil {
+		t.Fatal(err)
+	}
+	b := addBatch(6, "a")
+	if _, err := partial.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	again := newPushReceiver(t, state)
+	if !listed(again, "a", "item-6") || !listed(again, "a", "item-5") {
+		t.Error("items of list a lost")
+	}
+	if !listed(again, "b", "item-5") {
+		t.Error("items of the list not registered lost")
+	}
+}
+
+func TestPushStateWithoutLists(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(state, []byte(`{"seq":3,"batch_id":"batch-3"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPushReceiver(pushKey, nil, state); err == nil {
+		t.Error("state with a mark and no lists accepted")
+	}
+}
+
+func TestPushWindow(t *testing.T) {
+	p := newPushReceiver(t, filepath.Join(t.TempDir(), "state"))
+	for _, at := range []time.Time{time.Now().Add(-pushMaxAge - time.Minute), time.Now().Add(2 * pushMaxSkew)} {
+		b := addBatch(1, "a")
+		b.Time = at
+		if _, err := p.Apply(b); !errors.Is(err, ErrBatchExpired) {
+			t.Errorf("batch issued at %s: got %v, want ErrBatchExpired", at, err)
+		}
+	}
+}
+
+func TestPushFailedBatch(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state")
+	p := newPushReceiver(t, state)
+	b := addBatch(1, "a")
+	b.Mutations = append(b.Mutations, Mutation{List: "b", Op: "rename", Item: "x"})
+	if _, err := p.Apply(b); err == nil {
+		t.Fatal("batch with an unknown op applied")
+	}
+	if listed(p, "a", "item-1") {
+		t.Error("failed batch partly applied")
+	}
+
+	withFaults(t, faultHooks{write: func(string, []byte) (int, error) { return 0, errInjected }})
+	if _, err := p.Apply(addBatch(1, "a")); !errors.Is(err, errInjected) {
+		t.Errorf("got %v, want the error writing the state", err)
+	}
+	if listed(p, "a", "item-1") {
+		t.Error("batch applied without its state")
+	}
+	faults = faultHooks{}
+
+	// a batch filling a list is undone, and the state written back
+	if _, err := p.Apply(addBatch(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AddList("small", 4, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	full := addBatch(2)
+	full.Mutations = []Mutation{{List: "a", Op: "remove", Item: "item-1"}}
+	for i := 0; i < 100; i++ {
+		full.Mutations = append(full.Mutations, Mutation{List: "small", Op: "add", Item: strconv.Itoa(i)})
+	}
+	if _, err := p.Apply(full); err == nil {
+		t.Fatal("batch overfilling a list applied")
+	}
+	if !listed(p, "a", "item-1") {
+		t.Error("removal of a failed batch not undone")
+	}
+	small := &PushedList{p: p, name: "small"}
+	if small.lookup("0") {
+		t.Error("adds of a failed batch not undone")
+	}
+	restarted := newPushReceiver(t, state)
+	if !listed(restarted, "a", "item-1") || restarted.seq != 1 {
+		t.Errorf("state of a failed batch kept (seq %d)", restarted.seq)
+	}
+}
+
+// TestPushAtomic checks that a reader never sees a batch applied to one
+// list and not the other
+func TestPushAtomic(t *testing.T) {
+	p := newPushReceiver(t, filepath.Join(t.TempDir(), "state"))
+	const batches = 200
+	applied := make(chan struct{})
+	go func() {
+		defer close(applied)
+		for seq := uint64(1); seq <= batches; seq++ {
+			if _, err := p.Apply(addBatch(seq, "a", "b")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-applied:
+			return
+		default:
+		}
+		p.mu.RLock()
+		a, b := len(p.lists["a"].exact), len(p.lists["b"].exact)
+		p.mu.RUnlock()
+		if a != b {
+			t.Fatalf("lists a and b hold %d and %d items", a, b)
+		}
+	}
+}
+
+func TestPushServeHTTP(t *testing.T) {
+	p := newPushReceiver(t, filepath.Join(t.TempDir(), "state"))
+	post := func(b MutationBatch, key []byte) int {
+		body, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body))
+		req.Header.Set("X-Signature", "hmac-sha256="+hex.EncodeToString(mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, tc := range []struct {
+		name  string
+		batch MutationBatch
+		key   []byte
+		want  int
+	}{
+		{"bad signature", addBatch(1, "a"), []byte("other key"), http.StatusUnauthorized},
+		{"applied", addBatch(1, "a"), pushKey, http.StatusNoContent},
+		{"duplicate", addBatch(1, "a"), pushKey, http.StatusNoContent},
+		{"out of order", addBatch(3, "a"), pushKey, http.StatusConflict},
+		{"unknown list", addBatch(2, "c"), pushKey, http.StatusUnprocessableEntity},
+	} {
+		if got := post(tc.batch, tc.key); got != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+// Range calls fn for every stored fingerprint with the index of its bucket,
+// in bucket order, then for the fingerprints of the victim stash (with the
+// bucket they were evicted from). Iteration stops when fn returns false.
+// External tools can use it to compute custom statistics, build commitments
+// or re-encode the filter without reaching into unexported fields.
+// fp is the filter's own storage: fn must not modify it, and must copy it
+// to keep it after returning. The filter must not be modified during Range.
+func (c *Cuckoo) Range(fn func(bucketIdx uint, fp []byte) bool) {
+	for i := uint(0); i < c.m; i++ {
+		for j := uint(0); j < c.b; j++ {
+			if f := c.entry(i, j); !isEmpty(f) && !fn(i, f) {
+				return
+			}
+		}
+	}
+	for _, e := range c.victims {
+		if !fn(e.i, e.f) {
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot retention.
+// Daily snapshots of a filter go to a PageStore under "<filter>@<label>"
+// (e.g., "ofac@2026-10-15"). GC removes the snapshots a filter's policy no
+// longer keeps, then the pages no remaining manifest references. A snapshot
+// is kept if it is one of the KeepLast newest of its filter or younger than
+// KeepFor; the snapshots of a filter without a policy are all kept. The
+// newest snapshot of a filter is always kept: GC refuses a policy with
+// KeepLast below 1, which a typo in a flag could turn into "remove all".
+// Pages written within gcGrace are never removed: they may belong to a Put
+// whose manifest is not written yet (Put touches the pages it reuses for
+// the same reason).
+// In-memory generations need no collection: Rotating drops expired ones.
+
+// gcGrace is the age under which unreferenced pages are kept
+const gcGrace = time.Hour
+
+// RetentionPolicy tells which snapshots of a filter to keep
+type RetentionPolicy struct {
+	KeepLast int           // the newest snapshots kept, at least 1
+	KeepFor  time.Duration // snapshots younger than this are kept; 0 for none
+}
+
+// GCReport lists what GC removed, or would remove in a dry run
+type GCReport struct {
+	Snapshots []string // snapshot names
+	Pages     int
+	Bytes     int64 // size of the pages
+}
+
+// Print writes the report in human-readable form
+func (r GCReport) Print(w io.Writer, dryRun bool) {
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, name := range r.Snapshots {
+		fmt.Fprintf(w, "%s snapshot %s\n", verb, name)
+	}
+	fmt.Fprintf(w, "%s %d snapshots, %d pages (%d bytes)\n", verb, len(r.Snapshots), r.Pages, r.Bytes)
+}
+
+// snapshotFilter returns the filter of a snapshot name
+func snapshotFilter(name string) string {
+	filter, _, _ := strings.Cut(name, "@")
+	return filter
+}
+
+// GC applies the retention policies, by filter name, to the store. With
+// dryRun, nothing is removed and the report lists what would be.
+func (s *PageStore) GC(policies map[string]RetentionPolicy, dryRun bool) (GCReport, error) {
+	var report GCReport
+	for filter, policy := range policies {
+		if policy.KeepLast < 1 {
+			return report, fmt.Errorf("retention policy of %s keeps %d snapshots, must keep at least 1", filter, policy.KeepLast)
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(s.dir, "manifests", "*.json"))
+	if err != nil {
+		return report, err
+	}
+
+	type snapshot struct {
+		name     string
+		manifest *PageManifest
+	}
+	byFilter := make(map[string][]snapshot)
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), ".json")
+		m, err := s.Manifest(name)
+		if err != nil {
+			return report, err
+		}
+		byFilter[snapshotFilter(name)] = append(byFilter[snapshotFilter(name)], snapshot{name, m})
+	}
+
+	referenced := make(map[string]bool)
+	now := time.Now()
+	for filter, snaps := range byFilter {
+		policy, ok := policies[filter]
+		sort.Slice(snaps, func(i, j int) bool {
+			return snaps[i].manifest.Created.After(snaps[j].manifest.Created)
+		})
+		for k, snap := range snaps {
+			if !ok || k < policy.KeepLast || now.Sub(snap.manifest.Created) < policy.KeepFor {
+				for _, h := range snap.manifest.Pages {
+					referenced[h] = true
+				}
+				continue
+			}
+			report.Snapshots = append(report.Snapshots, snap.name)
+			if !dryRun {
+				if err := os.Remove(s.manifestPath(snap.name)); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+	sort.Strings(report.Snapshots)
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, "pages"))
+	if err != nil {
+		return report, err
+	}
+	for _, e := range entries {
+		if referenced[e.Name()] || validHash(e.Name()) != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return report, err
+		}
+		if now.Sub(info.ModTime()) < gcGrace {
+			continue
+		}
+		report.Pages++
+		report.Bytes += info.Size()
+		if !dryRun {
+			if err := os.Remove(s.pagePath(e.Name())); err != nil && !os.IsNotExist(err) {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// gcStore runs GC on the store in dir with one policy for every filter,
+// and prints the report
+func gcStore(dir string, policy RetentionPolicy, dryRun bool) error {
+	if _, err := os.Stat(filepath.Join(dir, "manifests")); err != nil {
+		return fmt.Errorf("%s is not a page store: %w", dir, err)
+	}
+	s := &PageStore{dir: dir}
+	paths, err := filepath.Glob(filepath.Join(dir, "manifests", "*.json"))
+	if err != nil {
+		return err
+	}
+	policies := make(map[string]RetentionPolicy)
+	for _, p := range paths {
+		policies[snapshotFilter(strings.TrimSuffix(filepath.Base(p), ".json"))] = policy
+	}
+	report, err := s.GC(policies, dryRun)
+	report.Print(os.Stdout, dryRun)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPageStoreGC(t *testing.T) {
+	s, err := NewPageStore(t.TempDir(), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCuckooFilter(1000, 0.01)
+	for day := 1; day <= 3; day++ {
+		for i := 0; i < 100; i++ {
+			c.insert(strconv.Itoa(day*1000 + i))
+		}
+		if _, err := s.Put("ofac@2026-10-0"+strconv.Itoa(day), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Put("other@2026-10-01", NewCuckooFilter(100, 0.01)); err != nil {
+		t.Fatal(err)
+	}
+	// pages past the grace period
+	old := time.Now().Add(-2 * gcGrace)
+	pages, _ := filepath.Glob(filepath.Join(s.dir, "pages", "*"))
+	for _, p := range pages {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, keep := range []int{0, -1} {
+		if _, err := s.GC(map[string]RetentionPolicy{"ofac": {KeepLast: keep}}, false); err == nil {
+			t.Errorf("GC with KeepLast %d ran", keep)
+		}
+	}
+	policies := map[string]RetentionPolicy{"ofac": {KeepLast: 1}}
+	dry, err := s.GC(policies, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Snapshots) != 2 || dry.Pages == 0 {
+		t.Errorf("dry run: %+v", dry)
+	}
+	if after, _ := filepath.Glob(filepath.Join(s.dir, "pages", "*")); len(after) != len(pages) {
+		t.Error("dry run removed pages")
+	}
+
+	report, err := s.GC(policies, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pages != dry.Pages || report.Bytes != dry.Bytes {
+		t.Errorf("GC removed %+v, dry run reported %+v", report, dry)
+	}
+	want := []string{"ofac@2026-10-01", "ofac@2026-10-02"}
+	if len(report.Snapshots) != 2 || report.Snapshots[0] != want[0] || report.Snapshots[1] != want[1] {
+		t.Errorf("removed %v, want %v", report.Snapshots, want)
+	}
+	// the kept snapshots, and the filter without a policy, are whole
+	for _, name := range []string{"ofac@2026-10-03", "other@2026-10-01"} {
+		if _, err := s.Get(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := s.Get(want[0]); err == nil {
+		t.Errorf("%s still readable", want[0])
+	}
+	if again, err := s.GC(policies, false); err != nil || len(again.Snapshots) != 0 || again.Pages != 0 {
+		t.Errorf("second GC: %+v, %v", again, err)
+	}
+}
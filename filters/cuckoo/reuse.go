@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Address reuse warnings.
+// Every payment to the same receive address links the payers to each other
+// and to the wallet. The wallet records the receive addresses it handed out
+// or was paid to, and checks a requested payment address against them.
+// Addresses are never forgotten, so a Bloom filter holds them: a false
+// positive reports a fresh address as used, and the wallet derives the next
+// one, which costs nothing.
+
+// ErrAddressReused is returned by CheckReceive for an already used address
+// when reuse is refused
+var ErrAddressReused = errors.New("receive address was used before")
+
+// UsedAddresses is the filter of the wallet's used receive addresses.
+// It is safe for concurrent use.
+type UsedAddresses struct {
+	mu     sync.RWMutex
+	filter Filter
+	refuse bool
+}
+
+// NewUsedAddresses returns a filter for n addresses with the false positive
+// rate e. With refuse, CheckReceive rejects used addresses instead of only
+// reporting them.
+func NewUsedAddresses(n uint, e float64, refuse bool) *UsedAddresses {
+	return &UsedAddresses{filter: NewBlockedBloomFilter(n, e), refuse: refuse}
+}
+
+// MarkUsed records a receive address as used
+func (u *UsedAddresses) MarkUsed(address string) error {
+	if address == "" {
+		return ErrEmptyKey
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.filter.insert(address)
+}
+
+// WasUsedBefore returns true if the address was marked used (or, with the
+// filter's false positive rate, if it was not)
+func (u *UsedAddresses) WasUsedBefore(address string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.filter.lookup(address)
+}
+
+// CheckReceive checks an address a caller requests payment to. It returns
+// whether the address was used before, and ErrAddressReused if so and reuse
+// is refused.
+func (u *UsedAddresses) CheckReceive(address string) (bool, error) {
+	used := u.WasUsedBefore(address)
+	if used && u.refuse {
+		return true, ErrAddressReused
+	}
+	return used, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestUsedAddresses(t *testing.T) {
+	for _, refuse := range []bool{false, true} {
+		u := NewUsedAddresses(1000, 0.0001, refuse)
+		if err := u.MarkUsed("bc1qpaid"); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.MarkUsed(""); !errors.Is(err, ErrEmptyKey) {
+			t.Errorf("MarkUsed of the empty address = %v", err)
+		}
+
+		used, err := u.CheckReceive("bc1qpaid")
+		if !used {
+			t.Error("used address reported fresh")
+		}
+		if refuse && !errors.Is(err, ErrAddressReused) || !refuse && err != nil {
+			t.Errorf("refuse=%v: CheckReceive of a used address = %v", refuse, err)
+		}
+		if used, err := u.CheckReceive("bc1qfresh"); used || err != nil {
+			t.Errorf("refuse=%v: CheckReceive of a fresh address = %v, %v", refuse, used, err)
+		}
+	}
+}
+
+func TestUsedAddressesConcurrent(t *testing.T) {
+	u := NewUsedAddresses(10000, 0.001, true)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				addr := strconv.Itoa(w*1000 + i)
+				if err := u.MarkUsed(addr); err != nil {
+					t.Error(err)
+					return
+				}
+				if !u.WasUsedBefore(addr) {
+					t.Errorf("%s not used right after MarkUsed", addr)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Revoked keys.
+// A wallet key found compromised, or a key share whose node was lost, must
+// not take part in another ceremony. RevokedKeys holds the fingerprints of
+// the revoked public keys and key-share IDs in a blocked Bloom filter, and
+// the orchestrator checks every request with IsRevoked (RevokedKeyHook)
+// before the nodes start signing. Revocations are never undone, so a Bloom
+// filter fits: it has no false negatives, and a false positive refuses a
+// healthy key, which fails closed; size it for a rate low enough that this
+// does not happen in practice (e.g., 1e-9).
+//
+// The list is only updated by mutation envelopes signed by a quorum of the
+// security officers: a revocation takes effect once threshold of the
+// registered signers signed it, and no single compromised officer key can
+// revoke the keys of the wallets (which would stop all signing) on its own.
+// An envelope signs its body together with the type of the body and a
+// sequence number: an envelope signed for another list cannot be applied
+// as a revocation, and the numbers must increase, so a captured envelope
+// cannot be applied again.
+// The envelopes applied are kept in a file, written before they take
+// effect, and verified and applied again when the list is opened: a
+// restart forgets no revocation, and a file edited without the quorum's
+// keys is refused.
+
+var (
+	// ErrQuorumNotReached is returned for an envelope signed by fewer than
+	// threshold of the registered signers
+	ErrQuorumNotReached = errors.New("envelope quorum not reached")
+	// ErrEnvelopeReplayed is returned for an envelope whose sequence number
+	// is not past that of the last envelope applied
+	ErrEnvelopeReplayed = errors.New("envelope replayed")
+	// ErrEnvelopeType is returned for an envelope signed for another body type
+	ErrEnvelopeType = errors.New("wrong envelope type")
+)
+
+// envelopeDomain separates the envelope signatures from any other message
+// signed with the same keys
+const envelopeDomain = "mpc-wallet mutation envelope v1\x00"
+
+// RevocationType is the type of the envelopes of RevokedKeys
+const RevocationType = "revocation"
+
+// MutationEnvelope is a list mutation signed by a quorum
+type MutationEnvelope struct {
+	Type       string              `json:"type"` // what Body is, e.g. RevocationType
+	Seq        uint64              `json:"seq"`
+	Body       json.RawMessage     `json:"body"`
+	Signatures []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is the Ed25519 signature of one signer of an envelope
+type EnvelopeSignature struct {
+	Signer string `json:"signer"` // name the signer is registered under
+	Sig    []byte `json:"sig"`
+}
+
+// message returns the bytes the signers sign: the domain, the type (NUL
+// terminated), the sequence number and the body
+func (env MutationEnvelope) message() []byte {
+	msg := make([]byte, 0, len(envelopeDomain)+len(env.Type)+9+len(env.Body))
+	msg = append(msg, envelopeDomain...)
+	msg = append(msg, env.Type...)
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint64(msg, env.Seq)
+	return append(msg, env.Body...)
+}
+
+// Sign appends the signature of signer to the envelope
+func (env *MutationEnvelope) Sign(signer string, key ed25519.PrivateKey) {
+	env.Signatures = append(env.Signatures, EnvelopeSignature{Signer: signer, Sig: ed25519.Sign(key, env.message())})
+}
+
+// EnvelopeVerifier checks that envelopes are signed by threshold of its
+// signers
+type EnvelopeVerifier struct {
+	threshold int
+	signers   map[string]ed25519.PublicKey
+}
+
+// NewEnvelopeVerifier returns a verifier requiring threshold valid
+// signatures from distinct signers
+func NewEnvelopeVerifier(threshold int, signers map[string]ed25519.PublicKey) (*EnvelopeVerifier, error) {
+	if threshold < 1 || threshold > len(signers) {
+		return nil, fmt.Errorf("envelope threshold %d of %d signers", threshold, len(signers))
+	}
+	for name, pub := range signers {
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("signer %s: invalid public key", name)
+		}
+	}
+	return &EnvelopeVerifier{threshold: threshold, signers: signers}, nil
+}
+
+// Verify returns nil if threshold distinct registered signers signed the
+// envelope. Signatures of unknown signers, invalid signatures, and repeated
+// signatures of a signer do not count.
+func (v *EnvelopeVerifier) Verify(env MutationEnvelope) error {
+	msg := env.message()
+	valid := make(map[string]bool)
+	for _, s := range env.Signatures {
+		pub, ok := v.signers[s.Signer]
+		if !ok || valid[s.Signer] {
+			continue
+		}
+		if ed25519.Verify(pub, msg, s.Sig) {
+			valid[s.Signer] = true
+		}
+	}
+	if len(valid) < v.threshold {
+		return fmt.Errorf("%w: %d valid signatures of %d", ErrQuorumNotReached, len(valid), v.threshold)
+	}
+	return nil
+}
+
+// Revocation is the body of a revocation envelope
+type Revocation struct {
+	PublicKeys  []string `json:"public_keys"`   // hex
+	KeyShareIDs []string `json:"key_share_ids"` // as reported by the nodes
+}
+
+// RevokedKeys is the list of revoked public keys and key shares.
+// It is safe for concurrent use.
+type RevokedKeys struct {
+	mu        sync.RWMutex
+	filter    *BlockedBloom
+	verifier  *EnvelopeVerifier
+	path      string             // file of the envelopes applied
+	envelopes []MutationEnvelope // applied, in order
+	seq       uint64             // sequence number of the last envelope applied
+	count     uint               // revocations inserted
+	capacity  uint
+}
+
+// OpenRevokedKeys returns the list sized for n revocations with the false
+// positive rate e, updated by the envelopes verifier accepts, and kept in
+// the file at path. The envelopes of the file, if it exists, are verified
+// and applied again; one that is not accepted fails the call.
+func OpenRevokedKeys(path string, n uint, e float64, verifier *EnvelopeVerifier) (*RevokedKeys, error) {
+	r := &RevokedKeys{filter: NewBlockedBloomFilter(n, e), verifier: verifier, path: path, capacity: n}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var envelopes []MutationEnvelope
+	if err := json.Unmarshal(data, &envelopes); err != nil {
+		return nil, fmt.Errorf("revocations %s: %w", path, err)
+	}
+	for _, env := range envelopes {
+		items, err := r.check(env)
+		if err != nil {
+			return nil, fmt.Errorf("revocations %s: %w", path, err)
+		}
+		r.apply(env, items)
+	}
+	return r, nil
+}
+
+// the public keys and the share IDs are kept apart in the filter
+func revokedKeyItem(pubkey []byte) string { return "pubkey:" + string(pubkey) }
+func revokedShareItem(id string) string   { return "share:" + id }
+
+// IsRevoked returns true if the public key was revoked (or is a false
+// positive of the filter)
+func (r *RevokedKeys) IsRevoked(pubkey []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.filter.lookup(revokedKeyItem(pubkey))
+}
+
+// IsShareRevoked returns true if the key share was revoked (or is a false
+// positive of the filter)
+func (r *RevokedKeys) IsShareRevoked(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.filter.lookup(revokedShareItem(id))
+}
+
+// Apply verifies a revocation envelope and adds its revocations, once the
+// envelope is written to the file of the list.
+// An envelope that is not signed by the quorum, of another type, replayed,
+// malformed, empty, or taking the list above its capacity is refused as a
+// whole.
+func (r *RevokedKeys) Apply(env MutationEnvelope) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items, err := r.check(env)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(append(slices.Clip(r.envelopes), env))
+	if err != nil {
+		return err
+	}
+	if err := writeFile(r.path, data); err != nil {
+		return fmt.Errorf("recording revocation envelope %d: %w", env.Seq, err)
+	}
+	r.apply(env, items)
+	return nil
+}
+
+// check returns the filter items of an envelope that the list may apply.
+// r.mu must be held.
+func (r *RevokedKeys) check(env MutationEnvelope) ([]string, error) {
+	if env.Type != RevocationType {
+		return nil, fmt.Errorf("%w: %q, want %q", ErrEnvelopeType, env.Type, RevocationType)
+	}
+	if err := r.verifier.Verify(env); err != nil {
+		return nil, err
+	}
+	var rev Revocation
+	dec := json.NewDecoder(bytes.NewReader(env.Body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rev); err != nil {
+		return nil, fmt.Errorf("revocation envelope %d: %w", env.Seq, err)
+	}
+	items := make([]string, 0, len(rev.PublicKeys)+len(rev.KeyShareIDs))
+	for _, k := range rev.PublicKeys {
+		pub, err := hex.DecodeString(k)
+		if err != nil || len(pub) == 0 {
+			return nil, fmt.Errorf("revocation envelope %d: invalid public key %q", env.Seq, k)
+		}
+		items = append(items, revokedKeyItem(pub))
+	}
+	for _, id := range rev.KeyShareIDs {
+		if id == "" {
+			return nil, fmt.Errorf("revocation envelope %d: %w", env.Seq, ErrEmptyKey)
+		}
+		items = append(items, revokedShareItem(id))
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("revocation envelope %d revokes nothing", env.Seq)
+	}
+	if env.Seq <= r.seq {
+		return nil, fmt.Errorf("%w: sequence %d, last applied %d", ErrEnvelopeReplayed, env.Seq, r.seq)
+	}
+	if r.count+uint(len(items)) > r.capacity {
+		return nil, fmt.Errorf("revocation envelope %d: %w", env.Seq, ErrOverCapacity)
+	}
+	return items, nil
+}
+
+// apply adds the items of a checked envelope. r.mu must be held.
+func (r *RevokedKeys) apply(env MutationEnvelope, items []string) {
+	for _, item := range items {
+		// a Bloom filter insert never fails
+		r.filter.insert(item)
+	}
+	r.envelopes = append(r.envelopes, env)
+	r.count += uint(len(items))
+	r.seq = env.Seq
+}
+
+// RevokedKeyHook blocks requests signing with a revoked public key or
+// involving a revoked key share. A request without public key is blocked:
+// the hook cannot tell whether its key was revoked.
+type RevokedKeyHook struct {
+	Keys *RevokedKeys
+}
+
+func (h RevokedKeyHook) Name() string { return "revoked-key" }
+
+func (h RevokedKeyHook) Evaluate(_ context.Context, req SigningRequest) Decision {
+	if len(req.PublicKey) == 0 {
+		return block(h.Name(), "request of wallet %s has no public key", req.WalletID)
+	}
+	if h.Keys.IsRevoked(req.PublicKey) {
+		return block(h.Name(), "public key %s is revoked", hex.EncodeToString(req.PublicKey))
+	}
+	for _, id := range req.KeyShareIDs {
+		if h.Keys.IsShareRevoked(id) {
+			return block(h.Name(), "key share %s is revoked", id)
+		}
+	}
+	return allow(h.Name())
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testOfficers returns the keys of three signers and a 2-of-3 verifier
+func testOfficers(t *testing.T) (map[string]ed25519.PrivateKey, *EnvelopeVerifier) {
+	t.Helper()
+	keys := make(map[string]ed25519.PrivateKey)
+	pubs := make(map[string]ed25519.PublicKey)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name], pubs[name] = priv, pub
+	}
+	v, err := NewEnvelopeVerifier(2, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, v
+}
+
+func revocation(t *testing.T, seq uint64, rev Revocation) MutationEnvelope {
+	t.Helper()
+	body, err := json.Marshal(rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return MutationEnvelope{Type: RevocationType, Seq: seq, Body: body}
+}
+
+func TestEnvelopeQuorum(t *testing.T) {
+	keys, v := testOfficers(t)
+	_, stranger, _ := ed25519.GenerateKey(nil)
+
+	for _, tc := range []struct {
+		name string
+		sign func(env *MutationEnvelope)
+		ok   bool // the quorum is reached
+	}{
+		{"two signers", func(env *MutationEnvelope) {
+			env.Sign("alice", keys["alice"])
+			env.Sign("carol", keys["carol"])
+		}, true},
+		{"one signer", func(env *MutationEnvelope) {
+			env.Sign("alice", keys["alice"])
+		}, false},
+		{"one signer twice", func(env *MutationEnvelope) {
+			env.Sign("alice", keys["alice"])
+			env.Sign("alice", keys["alice"])
+		}, false},
+		{"unknown signer", func(env *MutationEnvelope) {
+			env.Sign("alice", keys["alice"])
+			env.Sign("mallory", stranger)
+		}, false},
+		{"wrong key", func(env *MutationEnvelope) {
+			env.Sign("alice", keys["alice"])
+			env.Sign("bob", stranger)
+		}, false},
+	} {
+		env := revocation(t, 1, Revocation{KeyShareIDs: []string{"node-3/share-1"}})
+		tc.sign(&env)
+		err := v.Verify(env)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: Verify = %v", tc.name, err)
+		}
+		if err != nil && !errors.Is(err, ErrQuorumNotReached) {
+			t.Errorf("%s: Verify = %v, want ErrQuorumNotReached", tc.name, err)
+		}
+	}
+
+	// the signatures cover the body, the type and the sequence number
+	env := revocation(t, 1, Revocation{KeyShareIDs: []string{"node-3/share-1"}})
+	env.Sign("alice", keys["alice"])
+	env.Sign("bob", keys["bob"])
+	tampered := env
+	tampered.Body = json.RawMessage(`{"key_share_ids":["node-1/share-1"]}`)
+	if err := v.Verify(tampered); !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("tampered body: Verify = %v", err)
+	}
+	tampered = env
+	tampered.Type = "allowlist"
+	if err := v.Verify(tampered); !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("tampered type: Verify = %v", err)
+	}
+	tampered = env
+	tampered.Seq = 2
+	if err := v.Verify(tampered); !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("tampered sequence: Verify = %v", err)
+	}
+
+	if _, err := NewEnvelopeVerifier(4, v.signers); err == nil {
+		t.Error("NewEnvelopeVerifier accepted a threshold above the signers")
+	}
+}
+
+func TestRevokedKeys(t *testing.T) {
+	keys, v := testOfficers(t)
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	r, err := OpenRevokedKeys(path, 100, 1e-9, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compromised := []byte{0x02, 0xaa, 0xbb}
+	healthy := []byte{0x02, 0xcc, 0xdd}
+
+	env := revocation(t, 1, Revocation{
+		PublicKeys:  []string{hex.EncodeToString(compromised)},
+		KeyShareIDs: []string{"node-3/share-1"},
+	})
+	env.Sign("alice", keys["alice"])
+	if err := r.Apply(env); !errors.Is(err, ErrQuorumNotReached) {
+		t.Fatalf("Apply with one signature = %v", err)
+	}
+	if r.IsRevoked(compromised) {
+		t.Fatal("a refused envelope revoked the key")
+	}
+	env.Sign("bob", keys["bob"])
+	if err := r.Apply(env); err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsRevoked(compromised) || !r.IsShareRevoked("node-3/share-1") {
+		t.Error("revocations not applied")
+	}
+	if r.IsRevoked(healthy) || r.IsShareRevoked("node-1/share-1") {
+		t.Error("a key that was not revoked is")
+	}
+	// a share ID is not a public key
+	if r.IsRevoked([]byte("node-3/share-1")) {
+		t.Error("share ID revoked as a public key")
+	}
+
+	if err := r.Apply(env); !errors.Is(err, ErrEnvelopeReplayed) {
+		t.Errorf("replayed envelope: Apply = %v", err)
+	}
+
+	big := Revocation{}
+	for i := 0; i < 100; i++ {
+		big.KeyShareIDs = append(big.KeyShareIDs, hex.EncodeToString([]byte{byte(i)}))
+	}
+	over := revocation(t, 2, big)
+	over.Sign("bob", keys["bob"])
+	over.Sign("carol", keys["carol"])
+	if err := r.Apply(over); !errors.Is(err, ErrOverCapacity) {
+		t.Errorf("envelope over capacity: Apply = %v", err)
+	}
+
+	// an envelope of another type, or whose body is not a revocation, is
+	// refused even with the quorum, and does not use up its sequence number
+	for _, tc := range []struct {
+		name string
+		env  MutationEnvelope
+		want error
+	}{
+		{"other type", MutationEnvelope{Type: "allowlist", Seq: 2, Body: json.RawMessage(`{"items":["bc1q"]}`)}, ErrEnvelopeType},
+		{"other body", MutationEnvelope{Type: RevocationType, Seq: 2, Body: json.RawMessage(`{"items":["bc1q"]}`)}, nil},
+		{"empty", revocation(t, 2, Revocation{}), nil},
+	} {
+		tc.env.Sign("alice", keys["alice"])
+		tc.env.Sign("bob", keys["bob"])
+		err := r.Apply(tc.env)
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("%s: Apply = %v", tc.name, err)
+		}
+	}
+	next := revocation(t, 2, Revocation{KeyShareIDs: []string{"node-2/share-9"}})
+	next.Sign("alice", keys["alice"])
+	next.Sign("carol", keys["carol"])
+	if err := r.Apply(next); err != nil {
+		t.Fatalf("next envelope after refused ones: %v", err)
+	}
+
+	// a restart keeps the revocations and the sequence
+	restarted, err := OpenRevokedKeys(path, 100, 1e-9, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.IsRevoked(compromised) || !restarted.IsShareRevoked("node-3/share-1") || !restarted.IsShareRevoked("node-2/share-9") {
+		t.Error("revocations forgotten by a restart")
+	}
+	if err := restarted.Apply(next); !errors.Is(err, ErrEnvelopeReplayed) {
+		t.Errorf("envelope replayed after a restart: Apply = %v", err)
+	}
+
+	// a file edited without the quorum is refused
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := bytes.Replace(data, []byte(`"seq":2`), []byte(`"seq":3`), 1)
+	if err := os.WriteFile(path, forged, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRevokedKeys(path, 100, 1e-9, v); !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("forged file: OpenRevokedKeys = %v", err)
+	}
+
+	ctx := context.Background()
+	hook := RevokedKeyHook{Keys: r}
+	for _, tc := range []struct {
+		name string
+		req  SigningRequest
+		want bool
+	}{
+		{"healthy", SigningRequest{PublicKey: healthy, KeyShareIDs: []string{"node-1/share-1", "node-2/share-1"}}, true},
+		{"revoked key", SigningRequest{PublicKey: compromised}, false},
+		{"revoked share", SigningRequest{PublicKey: healthy, KeyShareIDs: []string{"node-1/share-1", "node-3/share-1"}}, false},
+		{"no public key", SigningRequest{KeyShareIDs: []string{"node-1/share-1"}}, false},
+	} {
+		if d := hook.Evaluate(ctx, tc.req); d.Allowed() != tc.want {
+			t.Errorf("%s: allowed %v (%s), want %v", tc.name, d.Allowed(), d.Reason, tc.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Generation is the filter of one time bucket of a Rotating filter
+type Generation struct {
+	Start  time.Time // start of the time bucket
+	Filter Filter
+}
+
+// Rotating is a time-bucketed filter (e.g., hourly tx dedup, daily address
+// seen-set). Items go to the filter of the current period and lookups check
+// every generation still retained, so an item is remembered for between
+// retention-1 and retention periods. Rotation happens automatically on
+// access and, with Run, in the background, so callers never rotate themselves.
+// It is safe for concurrent use: lookups share a read lock, and only take
+// the write lock to rotate when the current period is over.
+//
+// Rotating is not a DeletableFilter: a generation matching an item may hold
+// it or be a false positive, and deleting from it would remove the
+// fingerprint of another item, which would then be missed.
+type Rotating struct {
+	mu        sync.RWMutex
+	period    time.Duration
+	retention int           // number of generations kept, including the current one
+	newFilter func() Filter // builds the filter of a new generation
+	gens      []Generation  // newest first
+	now       func() time.Time
+
+	// OnRetire, if set, is called with a generation that stopped receiving
+	// items, e.g. to persist it. It is called with the lock held, so it
+	// must not use the Rotating filter.
+	OnRetire func(Generation)
+}
+
+var _ Filter = (*Rotating)(nil)
+
+// NewRotating returns a filter rotating every period and keeping retention
+// generations (at least 1), each built with newFilter
+func NewRotating(period time.Duration, retention int, newFilter func() Filter) *Rotating {
+	if retention < 1 {
+		retention = 1
+	}
+	r := &Rotating{
+		period:    period,
+		retention: retention,
+		newFilter: newFilter,
+		now:       time.Now,
+	}
+	r.gens = []Generation{{Start: r.now().Truncate(period), Filter: newFilter()}}
+	return r
+}
+
+// due returns true if the current period is over. The lock must be held,
+// for reading at least.
+func (r *Rotating) due() bool {
+	return !r.now().Before(r.gens[0].Start.Add(r.period))
+}
+
+// rotate starts the generations of every period elapsed since the current one
+// started and prunes those beyond the retention. The lock must be held.
+func (r *Rotating) rotate() {
+	if !r.due() {
+		return
+	}
+	start := r.now().Truncate(r.period)
+	if r.OnRetire != nil {
+		r.OnRetire(r.gens[0])
+	}
+
+	// periods skipped during a pause get no generation: they received no items
+	r.gens = append([]Generation{{Start: start, Filter: r.newFilter()}}, r.gens...)
+
+	oldest := start.Add(-time.Duration(r.retention-1) * r.period)
+	for len(r.gens) > 1 && r.gens[len(r.gens)-1].Start.Before(oldest) {
+		r.gens[len(r.gens)-1] = Generation{}
+		r.gens = r.gens[:len(r.gens)-1]
+	}
+}
+
+// Rotate rotates the filter if the current period is over
+func (r *Rotating) Rotate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+}
+
+// Run rotates the filter at the start of every period until ctx is done
+func (r *Rotating) Run(ctx context.Context) {
+	for {
+		r.mu.RLock()
+		next := r.gens[0].Start.Add(r.period)
+		r.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+			r.Rotate()
+		}
+	}
+}
+
+// insert adds the item to the filter of the current period
+func (r *Rotating) insert(item string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+	return r.gens[0].Filter.insert(item)
+}
+
+// lookup checks every retained generation, newest first
+func (r *Rotating) lookup(item string) bool {
+	r.mu.RLock()
+	if r.due() {
+		r.mu.RUnlock()
+		r.Rotate()
+		r.mu.RLock()
+	}
+	defer r.mu.RUnlock()
+	for _, g := range r.gens {
+		if g.Filter.lookup(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Generations returns the retained generations, newest first
+func (r *Rotating) Generations() []Generation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+	return append([]Generation(nil), r.gens...)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotating(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	now := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		clock = clock.Add(d)
+		mu.Unlock()
+	}
+
+	r := NewRotating(time.Hour, 3, func() Filter { return NewCuckooFilter(1000, 0.01) })
+	r.now = now
+	r.gens[0].Start = clock
+	var retired []time.Time
+	r.OnRetire = func(g Generation) { retired = append(retired, g.Start) }
+
+	if err := r.insert("a"); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Hour)
+	if err := r.insert("b"); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Hour)
+	if !r.lookup("a") || !r.lookup("b") {
+		t.Fatal("item forgotten within the retention")
+	}
+	if n := len(r.Generations()); n != 3 {
+		t.Errorf("%d generations, want 3", n)
+	}
+	advance(time.Hour)
+	if r.lookup("a") || !r.lookup("b") {
+		t.Error("item of an expired generation still found, or a retained one lost")
+	}
+	// a pause skips the generations of the periods it spans
+	advance(10 * time.Hour)
+	if r.lookup("b") {
+		t.Error("item found after a pause longer than the retention")
+	}
+	if len(retired) != 4 {
+		t.Errorf("OnRetire called %d times, want 4", len(retired))
+	}
+
+	// lookups rotating concurrently with inserts
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				item := strconv.Itoa(w*1000 + i)
+				if err := r.insert(item); err != nil {
+					t.Error(err)
+					return
+				}
+				if !r.lookup(item) {
+					t.Errorf("item %s not found right after its insert", item)
+				}
+				if i%50 == 0 {
+					advance(20 * time.Minute)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
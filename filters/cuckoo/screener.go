@@ -0,0 +1,105 @@
+package main
+
+import "fmt"
+
+// Screener runs an item through an ordered chain of filters
+// (e.g., allowlist, blocklist, risk map) and explains the outcome,
+// so every blocking decision can be traced back to the filter that caused it
+type Screener struct {
+	stages []stage
+}
+
+type stage struct {
+	name      string
+	fpRate    float64
+	filter    Lookuper
+	confirmed *Confirmed // nil when the filter has no exact set
+	shadow    *shadow    // candidate filter observed alongside, nil if none
+}
+
+// Result is the outcome of one filter of the chain
+type Result struct {
+	Filter string  // name of the filter
+	FPRate float64 // false positive rate the filter was built for
+	Hit    bool    // the item matched the filter (and the exact set, if any)
+
+	// Confirmed is true when the filter hit was checked against an exact set
+	// and the item was found there. A filter without exact set never confirms,
+	// so a Hit without Confirmed may be a false positive (with probability FPRate).
+	Confirmed bool
+
+	// Err is the error returned by the exact set, if any.
+	// Hit is false in that case, so callers must decide how to treat it.
+	Err error
+}
+
+// Verdict is the outcome of screening an item through the whole chain
+type Verdict struct {
+	Item    string
+	Results []Result // one result per filter, in chain order
+	Matched *Result  // first filter of the chain that hit, nil if none did
+}
+
+// Err returns the error of the first filter whose exact set failed, or nil.
+// A failed filter reads as not hit: callers making blocking decisions must
+// check Err and fail closed.
+func (v Verdict) Err() error {
+	for _, r := range v.Results {
+		if r.Err != nil {
+			return fmt.Errorf("filter %s: %w", r.Filter, r.Err)
+		}
+	}
+	return nil
+}
+
+// NewScreener returns an empty screener
+func NewScreener() *Screener {
+	return &Screener{}
+}
+
+// Add appends a filter to the chain.
+// fpRate is the false positive rate the filter was built for, reported in verdicts.
+// exact may be nil; when set, filter hits are confirmed against it.
+func (s *Screener) Add(name string, filter Lookuper, fpRate float64, exact ExactSet) {
+	st := stage{name: name, fpRate: fpRate, filter: filter}
+	if exact != nil {
+		st.confirmed = NewConfirmed(filter, exact)
+	}
+	s.stages = append(s.stages, st)
+}
+
+// Check runs the item through every filter of the chain in order
+func (s *Screener) Check(item string) Verdict {
+	v := Verdict{Item: item, Results: make([]Result, len(s.stages))}
+
+	for i, st := range s.stages {
+		r := Result{Filter: st.name, FPRate: st.fpRate}
+		if st.confirmed != nil {
+			r.Hit, r.Err = st.confirmed.Contains(item)
+			r.Confirmed = r.Hit
+		} else {
+			r.Hit = st.filter.lookup(item)
+		}
+		v.Results[i] = r
+
+		if st.shadow != nil {
+			st.shadow.compare(st.name, item, r.Hit)
+		}
+
+		if r.Hit && v.Matched == nil {
+			v.Matched = &v.Results[i]
+		}
+	}
+	return v
+}
+
+// ConfirmationRate returns the confirmation rate of the named filter
+// (see Confirmed.ConfirmationRate), or false if it has no exact set
+func (s *Screener) ConfirmationRate(name string) (float64, bool) {
+	for _, st := range s.stages {
+		if st.name == name && st.confirmed != nil {
+			return st.confirmed.ConfirmationRate(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// Integrity scrubbing of frozen filters.
+// A frozen filter never changes after Freeze, so the checksum of every page
+// of its buckets, computed once, must hold for as long as it is served. A
+// page that no longer matches has been corrupted in memory (a bit flip, a
+// stray write through unsafe code or a bad mmap): its lookups may return
+// false negatives, which a screening filter must never do. The scrubber
+// re-verifies the pages in the background and reports corruption before it
+// surfaces as a wrong screening answer.
+
+// scrubPageSize is the number of bucket bytes covered by one checksum
+const scrubPageSize = 4096
+
+// pageChecksums returns the CRC-32C of every page of the slab
+func pageChecksums(slots []byte) []uint32 {
+	sums := make([]uint32, 0, (len(slots)+scrubPageSize-1)/scrubPageSize)
+	for off := 0; off < len(slots); off += scrubPageSize {
+		sums = append(sums, crc32.Checksum(slots[off:min(off+scrubPageSize, len(slots))], crc32c))
+	}
+	return sums
+}
+
+// stashChecksum returns the CRC-32C of the victim stash: the bucket index
+// and the fingerprint of every entry
+func stashChecksum(victims []stashEntry) uint32 {
+	var buf []byte
+	for _, e := range victims {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(e.i))
+		buf = append(buf, e.f...)
+	}
+	return crc32.Checksum(buf, crc32c)
+}
+
+// CorruptPageError reports a bucket page, or the victim stash, whose
+// checksum changed since Freeze
+type CorruptPageError struct {
+	Page   int    // index of the page, -1 for the victim stash
+	Offset uint64 // offset of the page in the bucket storage
+}
+
+func (e *CorruptPageError) Error() string {
+	if e.Page < 0 {
+		return "frozen filter corrupted: victim stash checksum mismatch"
+	}
+	return fmt.Sprintf("frozen filter corrupted: bucket page %d (offset %d) checksum mismatch", e.Page, e.Offset)
+}
+
+// Verify recomputes the checksums of the bucket pages and of the victim
+// stash, and returns a *CorruptPageError for the first that does not match.
+// A closed filter returns ErrClosed: Close waits for a Verify in progress,
+// so the buckets are never unmapped while they are read.
+func (fz *Frozen) Verify() error {
+	fz.mu.RLock()
+	defer fz.mu.RUnlock()
+	if fz.closed {
+		return ErrClosed
+	}
+	slots := fz.c.slots
+	for p := range fz.pages {
+		off := p * scrubPageSize
+		if crc32.Checksum(slots[off:min(off+scrubPageSize, len(slots))], crc32c) != fz.pages[p] {
+			return &CorruptPageError{Page: p, Offset: uint64(off)}
+		}
+	}
+	if stashChecksum(fz.c.victims) != fz.stash {
+		return &CorruptPageError{Page: -1}
+	}
+	return nil
+}
+
+// Scrubber periodically verifies a set of frozen filters.
+// It is safe for concurrent use.
+type Scrubber struct {
+	mu      sync.Mutex
+	filters map[string]*Frozen
+
+	// OnCorruption is called with the name of a corrupted filter and the
+	// error of Verify, e.g. to alert and reload the filter from its snapshot.
+	// It is called from the scrubbing goroutine.
+	OnCorruption func(name string, err error)
+}
+
+// NewScrubber returns a scrubber with no filters
+func NewScrubber(onCorruption func(name string, err error)) *Scrubber {
+	return &Scrubber{filters: make(map[string]*Frozen), OnCorruption: onCorruption}
+}
+
+// Watch adds or replaces the named filter
+func (s *Scrubber) Watch(name string, fz *Frozen) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters[name] = fz
+}
+
+// Unwatch removes the named filter, e.g. before it is closed
+func (s *Scrubber) Unwatch(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.filters, name)
+}
+
+// Scrub verifies every filter once and reports the corrupted ones
+func (s *Scrubber) Scrub() {
+	s.mu.Lock()
+	filters := make(map[string]*Frozen, len(s.filters))
+	for name, fz := range s.filters {
+		filters[name] = fz
+	}
+	s.mu.Unlock()
+
+	for name, fz := range filters {
+		// a filter closed since it was copied is not corrupted
+		if err := fz.Verify(); err != nil && !errors.Is(err, ErrClosed) && s.OnCorruption != nil {
+			s.OnCorruption(name, err)
+		}
+	}
+}
+
+// Run scrubs every interval until ctx is done. Scrubbing reads the whole
+// bucket storage, so the interval should leave the lookups most of the
+// memory bandwidth (e.g., minutes for multi-GB filters).
+func (s *Scrubber) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Scrub()
+		}
+	}
+}
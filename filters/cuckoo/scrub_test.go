@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrozenVerify(t *testing.T) {
+	c := NewCuckooFilter(10000, 0.01)
+	for i := 0; i < 5000; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	i1, _, f := c.hashes("stashed")
+	if !c.stash(i1, f) {
+		t.Fatal("stash full")
+	}
+	fz := c.Freeze()
+	if err := fz.Verify(); err != nil {
+		t.Fatalf("fresh filter: %v", err)
+	}
+
+	var corrupt *CorruptPageError
+	fz.c.slots[3*scrubPageSize+7] ^= 1
+	if err := fz.Verify(); !errors.As(err, &corrupt) || corrupt.Page != 3 || corrupt.Offset != 3*scrubPageSize {
+		t.Errorf("bit flip in page 3: Verify = %v", err)
+	}
+	fz.c.slots[3*scrubPageSize+7] ^= 1
+
+	fz.c.victims[0].f[0] ^= 1
+	if err := fz.Verify(); !errors.As(err, &corrupt) || corrupt.Page != -1 {
+		t.Errorf("bit flip in the stash: Verify = %v", err)
+	}
+	fz.c.victims[0].f[0] ^= 1
+	if err := fz.Verify(); err != nil {
+		t.Errorf("restored filter: %v", err)
+	}
+
+	fz.Close()
+	if err := fz.Verify(); !errors.Is(err, ErrClosed) {
+		t.Errorf("closed filter: Verify = %v, want ErrClosed", err)
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	good := NewCuckooFilter(1000, 0.01).Freeze()
+	bad := NewCuckooFilter(1000, 0.01).Freeze()
+	bad.c.slots[0] ^= 1
+
+	var corrupted []string
+	s := NewScrubber(func(name string, err error) { corrupted = append(corrupted, name) })
+	s.Watch("good", good)
+	s.Watch("bad", bad)
+	s.Scrub()
+	if len(corrupted) != 1 || corrupted[0] != "bad" {
+		t.Errorf("corrupted filters reported: %v, want [bad]", corrupted)
+	}
+
+	corrupted = nil
+	s.Unwatch("bad")
+	s.Scrub()
+	if len(corrupted) != 0 {
+		t.Errorf("unwatched filter reported: %v", corrupted)
+	}
+}
+
+// TestScrubberClose checks that closing an off-heap filter while it is
+// scrubbed waits for the scrub instead of unmapping the buckets under it
+func TestScrubberClose(t *testing.T) {
+	fz := NewCuckooFilter(1<<24, 0.01, WithOffHeap()).Freeze()
+	s := NewScrubber(func(name string, err error) { t.Errorf("%s reported: %v", name, err) })
+	s.Watch("list", fz)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				s.Scrub()
+				if errors.Is(fz.Verify(), ErrClosed) {
+					return
+				}
+			}
+		}()
+	}
+	// let the scrubs read the buckets
+	time.Sleep(5 * time.Millisecond)
+	s.Unwatch("list")
+	if err := fz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// shadowLogKey keys the item hashes of the divergence logs. It is drawn at
+// start: the lines of an item can be matched within a run, but a log reader
+// cannot hash candidate addresses to find which ones diverged.
+var shadowLogKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key) // never fails: crypto/rand crashes the program instead
+	return key
+}()
+
+// shadow is a candidate filter queried alongside an active filter of the
+// screener. Its answers are compared with the active filter but never
+// enforced, so a new list can be observed on live traffic before rollout.
+// Its counters are atomic, as concurrent screenings compare concurrently.
+type shadow struct {
+	filter    Lookuper
+	confirmed *Confirmed // nil when the candidate has no exact set
+
+	checks, onlyActive, onlyShadow atomic.Uint64 // see ShadowStats
+}
+
+// ShadowStats counts how a shadow filter compared with the active filter
+type ShadowStats struct {
+	Checks     uint64 // items screened by both filters
+	OnlyActive uint64 // items the active filter hit but the shadow did not
+	OnlyShadow uint64 // items the shadow hit but the active filter did not (would-be new hits)
+}
+
+// SetShadow attaches a candidate filter to the named filter of the chain,
+// replacing any previous candidate. exact may be nil, as in Add.
+func (s *Screener) SetShadow(name string, candidate Lookuper, exact ExactSet) error {
+	for i := range s.stages {
+		if s.stages[i].name != name {
+			continue
+		}
+		sh := &shadow{filter: candidate}
+		if exact != nil {
+			sh.confirmed = NewConfirmed(candidate, exact)
+		}
+		s.stages[i].shadow = sh
+		return nil
+	}
+	return fmt.Errorf("no filter named %q", name)
+}
+
+// RemoveShadow detaches the candidate filter of the named filter, if any
+func (s *Screener) RemoveShadow(name string) {
+	for i := range s.stages {
+		if s.stages[i].name == name {
+			s.stages[i].shadow = nil
+		}
+	}
+}
+
+// ShadowStats returns the divergence counters of the named filter's candidate,
+// or false if it has none
+func (s *Screener) ShadowStats(name string) (ShadowStats, bool) {
+	for _, st := range s.stages {
+		if st.name == name && st.shadow != nil {
+			return st.shadow.stats(), true
+		}
+	}
+	return ShadowStats{}, false
+}
+
+// stats returns the counters of the shadow
+func (sh *shadow) stats() ShadowStats {
+	return ShadowStats{
+		Checks:     sh.checks.Load(),
+		OnlyActive: sh.onlyActive.Load(),
+		OnlyShadow: sh.onlyShadow.Load(),
+	}
+}
+
+// compare screens the item with the candidate and records whether it agrees
+// with the active filter's answer. Divergences are logged with a keyed hash
+// of the item only (see shadowLogKey), so the log does not become a copy of
+// the list.
+func (sh *shadow) compare(name, item string, activeHit bool) {
+	var hit bool
+	if sh.confirmed != nil {
+		// an exact set error counts as a miss, as in Screener.Check
+		hit, _ = sh.confirmed.Contains(item)
+	} else {
+		hit = sh.filter.lookup(item)
+	}
+
+	sh.checks.Add(1)
+	if hit == activeHit {
+		return
+	}
+	if activeHit {
+		sh.onlyActive.Add(1)
+	} else {
+		sh.onlyShadow.Add(1)
+	}
+	mac := hmac.New(sha256.New, shadowLogKey)
+	mac.Write([]byte(item))
+	log.Printf("shadow %s: item %x active=%v shadow=%v", name, mac.Sum(nil), activeHit, hit)
+}
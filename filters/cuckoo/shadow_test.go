@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestShadowConcurrent counts the comparisons of concurrent screenings; run
+// with -race to check the counters
+func TestShadowConcurrent(t *testing.T) {
+	w := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(w)
+
+	active := NewBlockedBloomFilter(1000, 0.0001)
+	candidate := NewBlockedBloomFilter(1000, 0.0001)
+	for i := 0; i < 100; i++ {
+		active.insert(strconv.Itoa(i))
+		candidate.insert(strconv.Itoa(i + 50)) // 50..149
+	}
+	s := NewScreener()
+	s.Add("list", active, 0.0001, nil)
+	if err := s.SetShadow("list", candidate, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 150; i++ {
+				s.Check(strconv.Itoa(i))
+			}
+		}()
+	}
+	wg.Wait()
+	got, _ := s.ShadowStats("list")
+	want := ShadowStats{Checks: workers * 150, OnlyActive: workers * 50, OnlyShadow: workers * 50}
+	if got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "bytes"
+
+// Bucket storage of the cuckoo filter.
+// All the fingerprints live in one byte slice (a slab): entry j of bucket i is
+// the f bytes at offset (i*b + j) * f, and an all-zero entry is empty (nonZero
+// guarantees no stored fingerprint is all zeros).
+// A slice per bucket and per fingerprint would cost two slice headers and a
+// separate allocation for every entry: a 100M-entry filter would hold
+// hundreds of millions of pointers that the garbage collector scans on every
+// cycle, and use several times the memory of the fingerprints themselves.
+// The slab holds no pointer at all, so the collector never looks inside it,
+// and the fingerprints of a bucket are contiguous in memory.
+
+// entry returns entry j of bucket i. It is the filter's own storage:
+// writing to it changes the filter.
+func (c *Cuckoo) entry(i, j uint) fingerprint {
+	o := (i*c.b + j) * c.f
+	return fingerprint(c.slots[o : o+c.f : o+c.f])
+}
+
+// find returns the entry of bucket i holding the fingerprint f
+func (c *Cuckoo) find(i uint, f fingerprint) (uint, bool) {
+	for j := uint(0); j < c.b; j++ {
+		if bytes.Equal(c.entry(i, j), f) {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// isEmpty returns true for an empty entry (all zeros)
+func isEmpty(e fingerprint) bool {
+	for _, x := range e {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
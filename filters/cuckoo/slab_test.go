@@ -0,0 +1,71 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// TestSlabAllocations checks that stored fingerprints are not separate heap
+// objects: the live objects do not grow with the items inserted
+func TestSlabAllocations(t *testing.T) {
+	const items = 100000
+	c := NewCuckooFilter(items, 0.0001)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < items; i++ {
+		if err := c.insert(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(c)
+	if grown := int64(after.HeapObjects) - int64(before.HeapObjects); grown > items/100 {
+		t.Errorf("%d more live heap objects after inserting %d items", grown, items)
+	}
+}
+
+// bucketEntries is b, which the *testing.B of benchmarks shadows
+var bucketEntries = int(b)
+
+// benchmarkGC measures a full collection while the filter is live
+func benchmarkGC(b *testing.B, live any) {
+	runtime.GC()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.KeepAlive(live)
+}
+
+// BenchmarkGCSlab collects the heap holding a 100M-entry filter (about
+// 134 MB of buckets), filled with 1M items: the slab has no pointer, so the
+// collection does not depend on its size
+func BenchmarkGCSlab(b *testing.B) {
+	c := NewCuckooFilter(100_000_000, 0.0001)
+	for i := 0; i < 1_000_000; i++ {
+		if err := c.insert(strconv.Itoa(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	benchmarkGC(b, c)
+}
+
+// BenchmarkGCBuckets is the layout the slab replaced, for comparison: a
+// slice per bucket and a slice of its SHA1 sum per stored fingerprint. It
+// holds 5M fingerprints, as a 100M-entry filter in that layout does not fit
+// in the memory of most test machines.
+func BenchmarkGCBuckets(b *testing.B) {
+	const fingerprints = 5_000_000
+	buckets := make([][]fingerprint, fingerprints/bucketEntries)
+	for i := range buckets {
+		buckets[i] = make([]fingerprint, bucketEntries)
+		for j := range buckets[i] {
+			buckets[i][j] = fingerprint(hash([]byte(strconv.Itoa(i*bucketEntries + j)))[:2])
+		}
+	}
+	benchmarkGC(b, buckets)
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// Snapshot format of the cuckoo filter.
+// All integers are little-endian.
+//
+//	magic     4 bytes  "CKOO"
+//	version   uint32
+//	flags     uint32   (version >= 2) bit 0: keyed (see WithKey),
+//	                   bit 1: sparse buckets (version >= 3)
+//	hash      uint32   (version >= 4) hash family ID (see hashfamily.go)
+//	seed      uint64   (version >= 4) hash seed
+//	n         uint64   capacity
+//	m         uint64   number of buckets, a power of two
+//	b         uint64   entries per bucket
+//	f         uint64   fingerprint length in bytes
+//	count     uint64   stored fingerprints, including the stash
+//	victims   uint32   stashed fingerprints
+//	slots     m*b*f bytes, the bucket slab (see slab.go), or if sparse:
+//	          entries uint64, then entries times: slot uint64
+//	          (bucket*b + entry), fingerprint f bytes
+//	stash     victims times: bucket uint64, fingerprint f bytes
+//	crc       uint32   (version >= 2) CRC-32C of everything before it
+//
+// Version 1 has no flags and no checksum. Version 2 adds both, so a keyed
+// filter is never loaded without its key and a damaged file is detected.
+// Version 3 adds the sparse bucket encoding: a freshly created or rotated
+// filter is mostly empty entries, and listing its fingerprints with their
+// slot is smaller than the slab while the occupancy is below f/(8+f) (11%
+// for 1-byte fingerprints, 33% for 4-byte ones). Writers pick the smaller
+// encoding; readers rebuild the same slab from either. A sparse snapshot
+// must also list enough entries for its slab (see sparseSlabLimit), so a
+// small input cannot make a reader allocate a huge empty slab.
+// Version 4 records the hash family and seed; older versions imply SHA1, or
+// HMAC-SHA256 for keyed filters, without seed.
+// Readers accept every version up to SnapshotVersion, and writers can write
+// an older version for readers that are not upgraded yet (see
+// NegotiateVersion). The key and the runtime settings (load limit,
+// idempotent inserts, ...) are not stored: they are passed to ReadCuckoo.
+
+// SnapshotVersion is the newest snapshot version, written by default
+const SnapshotVersion = 4
+
+const (
+	snapshotMagic    = "CKOO"
+	snapshotKeyed    = 1 << 0
+	snapshotSparse   = 1 << 1
+	maxSnapshotBytes = 1 << 40 // refuse slabs over 1 TiB, rather than trying to allocate them
+
+	// maxFingerprintBytes is the width of the narrowest hash family digest
+	// (see RegisterHashFamily): fingerprints are cut from it
+	maxFingerprintBytes = 20
+
+	// slabChunk is the largest slab allocated before its bytes are read
+	slabChunk = 64 << 20
+	// sparseExpansion bounds the slab of a sparse snapshot by the size of
+	// its entries
+	sparseExpansion = 64
+)
+
+// sparseSlabLimit returns the largest slab a sparse snapshot of entries
+// fingerprints of f bytes may have
+func sparseSlabLimit(entries, f uint64) uint64 {
+	return max(slabChunk, entries*(8+f)*sparseExpansion)
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// NegotiateVersion returns the newest snapshot version supported both by
+// this code and by a peer supporting the versions peer
+func NegotiateVersion(peer []uint32) (uint32, error) {
+	var best uint32
+	for _, v := range peer {
+		if v >= 1 && v <= SnapshotVersion && v > best {
+			best = v
+		}
+	}
+	if best == 0 {
+		return 0, &ErrVersionMismatch{Got: maxVersion(peer), Want: SnapshotVersion}
+	}
+	return best, nil
+}
+
+func maxVersion(vs []uint32) uint32 {
+	var m uint32
+	for _, v := range vs {
+		m = max(m, v)
+	}
+	return m
+}
+
+// WriteTo writes the filter in the current snapshot version.
+// It implements io.WriterTo.
+func (c *Cuckoo) WriteTo(w io.Writer) (int64, error) {
+	return c.WriteVersion(w, SnapshotVersion)
+}
+
+// WriteVersion writes the filter in the given snapshot version
+func (c *Cuckoo) WriteVersion(w io.Writer, version uint32) (int64, error) {
+	if version < 1 || version > SnapshotVersion {
+		return 0, &ErrVersionMismatch{Got: version, Want: SnapshotVersion}
+	}
+	if version < 2 && c.key != nil {
+		return 0, fmt.Errorf("snapshot version %d cannot mark a keyed filter", version)
+	}
+	family, seed := c.HashFamily()
+	if version < 4 && (seed != 0 || (family != HashSHA1 && family != hashHMACSHA256)) {
+		return 0, fmt.Errorf("snapshot version %d cannot record hash family %s with seed %d", version, family.Name, seed)
+	}
+
+	// the entries in buckets, for the sparse encoding
+	var entries uint64
+	if version >= 3 {
+		c.Range(func(uint, []byte) bool {
+			entries++
+			return true
+		})
+		entries -= uint64(len(c.victims))
+	}
+	size := uint64(len(c.slots))
+	sparse := version >= 3 && 8+entries*(8+uint64(c.f)) < size && size <= sparseSlabLimit(entries, uint64(c.f))
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	sum := crc32.New(crc32c)
+	out := io.MultiWriter(cw, sum)
+	le := binary.LittleEndian
+
+	var hdr []byte
+	hdr = append(hdr, snapshotMagic...)
+	hdr = le.AppendUint32(hdr, version)
+	if version >= 2 {
+		var flags uint32
+		if c.key != nil {
+			flags |= snapshotKeyed
+		}
+		if sparse {
+			flags |= snapshotSparse
+		}
+		hdr = le.AppendUint32(hdr, flags)
+	}
+	if version >= 4 {
+		hdr = le.AppendUint32(hdr, family.ID)
+		hdr = le.AppendUint64(hdr, seed)
+	}
+	for _, v := range []uint{c.n, c.m, c.b, c.f, c.count} {
+		hdr = le.AppendUint64(hdr, uint64(v))
+	}
+	hdr = le.AppendUint32(hdr, uint32(len(c.victims)))
+	out.Write(hdr)
+	if sparse {
+		out.Write(le.AppendUint64(nil, entries))
+		for i := uint(0); i < c.m; i++ {
+			for j := uint(0); j < c.b; j++ {
+				if e := c.entry(i, j); !isEmpty(e) {
+					out.Write(le.AppendUint64(nil, uint64(i*c.b+j)))
+					out.Write(e)
+				}
+			}
+		}
+	} else {
+		out.Write(c.slots)
+	}
+	for _, e := range c.victims {
+		out.Write(le.AppendUint64(nil, uint64(e.i)))
+		out.Write(e.f)
+	}
+	if version >= 2 {
+		cw.Write(le.AppendUint32(nil, sum.Sum32()))
+	}
+
+	if cw.err == nil {
+		cw.err = bw.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// countingWriter counts the bytes written and keeps the first error,
+// so the encoder does not need to check every write
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return len(p), nil
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return len(p), nil
+}
+
+// snapshotReader decodes a snapshot, tracking the offset for errors
+// and feeding the checksum
+type snapshotReader struct {
+	r   io.Reader
+	off int64
+	sum hashWriter
+}
+
+type hashWriter interface {
+	io.Writer
+	Sum32() uint32
+}
+
+func (sr *snapshotReader) corrupt(reason string, args ...any) error {
+	return &ErrCorruptSnapshot{Offset: sr.off, Reason: fmt.Sprintf(reason, args...)}
+}
+
+// read fills p, reporting a truncated input as a corrupt snapshot
+func (sr *snapshotReader) read(p []byte, what string) error {
+	n, err := io.ReadFull(sr.r, p)
+	sr.sum.Write(p[:n])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return sr.corrupt("truncated %s", what)
+	}
+	if err != nil {
+		return err
+	}
+	sr.off += int64(n)
+	return nil
+}
+
+func (sr *snapshotReader) uint32(what string) (uint32, error) {
+	var b [4]byte
+	err := sr.read(b[:], what)
+	return binary.LittleEndian.Uint32(b[:]), err
+}
+
+func (sr *snapshotReader) uint64(what string) (uint64, error) {
+	var b [8]byte
+	err := sr.read(b[:], what)
+	return binary.LittleEndian.Uint64(b[:]), err
+}
+
+// ReadCuckoo loads a filter from a snapshot of any supported version,
+// compressed or not (see compress.go).
+// opts are the settings of the loaded filter, as for NewCuckooFilter; a keyed
+// snapshot must be loaded with WithKey, and a plain one without.
+// Decoding errors are *ErrCorruptSnapshot and unsupported versions
+// *ErrVersionMismatch.
+func ReadCuckoo(r io.Reader, opts ...Option) (*Cuckoo, error) {
+	return readCuckoo(r, false, opts...)
+}
+
+// readCuckoo is ReadCuckoo. With anyKey, a keyed snapshot loads without its
+// key: the filter is marked keyed with an empty key, so it can be written
+// back (e.g., migrated) but its lookups are meaningless.
+func readCuckoo(r io.Reader, anyKey bool, opts ...Option) (*Cuckoo, error) {
+	in, err := decompress(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	sr := &snapshotReader{r: in, sum: crc32.New(crc32c)}
+
+	magic := make([]byte, len(snapshotMagic))
+	if err := sr.read(magic, "magic"); err != nil {
+		return nil, err
+	}
+	if string(magic) != snapshotMagic {
+		return nil, &ErrCorruptSnapshot{Offset: 0, Reason: fmt.Sprintf("bad magic %q", magic)}
+	}
+	version, err := sr.uint32("version")
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > SnapshotVersion {
+		return nil, &ErrVersionMismatch{Got: version, Want: SnapshotVersion}
+	}
+	var flags uint32
+	if version >= 2 {
+		if flags, err = sr.uint32("flags"); err != nil {
+			return nil, err
+		}
+		known := uint32(snapshotKeyed)
+		if version >= 3 {
+			known |= snapshotSparse
+		}
+		if flags&^known != 0 {
+			return nil, sr.corrupt("unknown flags %#x", flags)
+		}
+	}
+
+	var hashID uint32 // 0 for the default of the keyed flag
+	var seed uint64
+	if version >= 4 {
+		if hashID, err = sr.uint32("hash family"); err != nil {
+			return nil, err
+		}
+		if seed, err = sr.uint64("seed"); err != nil {
+			return nil, err
+		}
+	}
+
+	var hdr [5]uint64 // n, m, b, f, count
+	for i, what := range []string{"n", "m", "b", "f", "count"} {
+		if hdr[i], err = sr.uint64(what); err != nil {
+			return nil, err
+		}
+	}
+	n, m, nb, f, count := hdr[0], hdr[1], hdr[2], hdr[3], hdr[4]
+	if m == 0 || m&(m-1) != 0 {
+		return nil, sr.corrupt("m = %d is not a power of two", m)
+	}
+	if nb == 0 || f == 0 || f > maxFingerprintBytes {
+		return nil, sr.corrupt("invalid bucket size %d or fingerprint length %d", nb, f)
+	}
+	hi, size := bits.Mul64(m*nb, f)
+	if hi != 0 || m*nb/nb != m || size > maxSnapshotBytes {
+		return nil, sr.corrupt("bucket storage of %d*%d*%d bytes is too large", m, nb, f)
+	}
+	victims, err := sr.uint32("victims")
+	if err != nil {
+		return nil, err
+	}
+	if victims > stashSize {
+		return nil, sr.corrupt("%d stashed fingerprints, at most %d", victims, stashSize)
+	}
+
+	c := &Cuckoo{m: uint(m), mask: uint(m - 1), b: uint(nb), f: uint(f), n: uint(n)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	keyed := flags&snapshotKeyed != 0
+	if keyed && anyKey && c.key == nil {
+		c.key = []byte{}
+	}
+	if keyed != (c.key != nil) {
+		if keyed {
+			return nil, fmt.Errorf("snapshot of a keyed filter loaded without WithKey: %w", ErrIncompatibleParams)
+		}
+		return nil, fmt.Errorf("snapshot of a plain filter loaded with WithKey: %w", ErrIncompatibleParams)
+	}
+	if err := c.adoptHash(hashID, seed); err != nil {
+		return nil, err
+	}
+
+	if flags&snapshotSparse != 0 {
+		err = c.readSparse(sr, size)
+	} else {
+		err = c.readSlab(sr, size)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	for k := uint32(0); k < victims; k++ {
+		i, err := sr.uint64("stash bucket")
+		if err == nil && i >= m {
+			err = sr.corrupt("stash bucket %d out of range", i)
+		}
+		fp := make(fingerprint, f)
+		if err == nil {
+			err = sr.read(fp, "stash fingerprint")
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.victims = append(c.victims, stashEntry{i: uint(i), f: fp})
+	}
+
+	if version >= 2 {
+		want, off := sr.sum.Sum32(), sr.off
+		got, err := sr.uint32("checksum")
+		if err == nil && got != want {
+			err = &ErrCorruptSnapshot{Offset: off, Reason: fmt.Sprintf("checksum %08x, want %08x", got, want)}
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	var stored uint64
+	c.Range(func(uint, []byte) bool {
+		stored++
+		return true
+	})
+	if stored != count {
+		c.Close()
+		return nil, sr.corrupt("count %d, but %d fingerprints stored", count, stored)
+	}
+	c.count = uint(count)
+	return c, nil
+}
+
+// readSlab allocates the slab of c and reads it. Its size comes from the
+// header, which an untrusted input sets at will: a slab over slabChunk is
+// read in chunks first, so memory is only committed for bytes the input
+// actually holds (a large slab transiently takes twice its size).
+func (c *Cuckoo) readSlab(sr *snapshotReader, size uint64) error {
+	if size <= slabChunk {
+		slots, err := c.tryAllocSlots(uint(size))
+		if err != nil {
+			return err
+		}
+		c.slots = slots
+		return sr.read(c.slots, "buckets")
+	}
+
+	var chunks [][]byte
+	for left := size; left > 0; {
+		chunk := make([]byte, min(left, slabChunk))
+		if err := sr.read(chunk, "buckets"); err != nil {
+			return err
+		}
+		chunks = append(chunks, chunk)
+		left -= uint64(len(chunk))
+	}
+	slots, err := c.tryAllocSlots(uint(size))
+	if err != nil {
+		return err
+	}
+	off := 0
+	for k, chunk := range chunks {
+		off += copy(slots[off:], chunk)
+		chunks[k] = nil
+	}
+	c.slots = slots
+	return nil
+}
+
+// readSparse reads sparse bucket entries and allocates and fills the slab
+// of size bytes of c. The entries are read before the slab is allocated,
+// and bound its size (see sparseSlabLimit).
+func (c *Cuckoo) readSparse(sr *snapshotReader, size uint64) error {
+	entries, err := sr.uint64("entries")
+	if err != nil {
+		return err
+	}
+	if entries > uint64(c.m*c.b) {
+		return sr.corrupt("%d entries, the buckets hold %d", entries, c.m*c.b)
+	}
+	if size > sparseSlabLimit(entries, uint64(c.f)) {
+		return sr.corrupt("%d entries for %d bytes of buckets", entries, size)
+	}
+
+	// slot and fingerprint of each entry, grown as entries are actually read
+	var slots []uint64
+	var fps []byte
+	var next uint64 // slots are in increasing order
+	fp := make(fingerprint, c.f)
+	for k := uint64(0); k < entries; k++ {
+		slot, err := sr.uint64("entry slot")
+		if err != nil {
+			return err
+		}
+		if slot < next || slot >= uint64(c.m*c.b) {
+			return sr.corrupt("entry slot %d out of order or range", slot)
+		}
+		if err := sr.read(fp, "entry fingerprint"); err != nil {
+			return err
+		}
+		if isEmpty(fp) {
+			return sr.corrupt("empty fingerprint in slot %d", slot)
+		}
+		slots = append(slots, slot)
+		fps = append(fps, fp...)
+		next = slot + 1
+	}
+
+	if c.slots, err = c.tryAllocSlots(uint(size)); err != nil {
+		return err
+	}
+	for k, slot := range slots {
+		copy(c.entry(uint(slot)/c.b, uint(slot)%c.b), fps[uint(k)*c.f:])
+	}
+	return nil
+}
+
+// migrateSnapshot rewrites the snapshot file at path in the given version,
+// in place: the new file replaces the old one only once fully written.
+// Keyed snapshots are migrated without their key: the slab is copied as is.
+func migrateSnapshot(path string, version uint32) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	c, err := readCuckoo(in, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer c.Close()
+
+	return writeAtomic(path, ".migrate-*", func(w io.Writer) error {
+		_, err := c.WriteVersion(w, version)
+		return err
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// sparseHeader is the size of the version 4 header, up to the entry count
+const sparseHeader = 4 + 4 + 4 + 4 + 8 + 5*8 + 4
+
+func TestSparseSnapshotBadSlot(t *testing.T) {
+	c := NewCuckooFilter(10000, 0.01)
+	c.insert("a")
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	if flags := binary.LittleEndian.Uint32(raw[8:]); flags&snapshotSparse == 0 {
+		t.Fatalf("flags %#x, want a sparse snapshot", flags)
+	}
+
+	for _, slot := range []uint64{1 << 40, uint64(c.m * c.b)} {
+		bad := bytes.Clone(raw)
+		binary.LittleEndian.PutUint64(bad[sparseHeader+8:], slot)
+		_, err := ReadCuckoo(bytes.NewReader(bad))
+		var corrupt *ErrCorruptSnapshot
+		if !errors.As(err, &corrupt) {
+			t.Errorf("slot %d: got %v, want *ErrCorruptSnapshot", slot, err)
+		}
+	}
+}
+
+func TestSparseSnapshotRoundTrip(t *testing.T) {
+	c := NewCuckooFilter(10000, 0.01)
+	for i := 0; i < 300; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	for i := 0; i < 300; i += 3 {
+		c.delete(strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= len(c.slots) {
+		t.Errorf("sparse snapshot of %d bytes, slab of %d", buf.Len(), len(c.slots))
+	}
+	d, err := ReadCuckoo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equal(c) {
+		t.Error("loaded filter differs")
+	}
+}
+
+func TestSnapshotVersions(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	for v := uint32(1); v <= SnapshotVersion; v++ {
+		var buf bytes.Buffer
+		if _, err := c.WriteVersion(&buf, v); err != nil {
+			t.Fatalf("version %d: %v", v, err)
+		}
+		if got := binary.LittleEndian.Uint32(buf.Bytes()[4:]); got != v {
+			t.Errorf("version %d written as %d", v, got)
+		}
+		d, err := ReadCuckoo(&buf)
+		if err != nil {
+			t.Fatalf("version %d: %v", v, err)
+		}
+		if !d.Equal(c) {
+			t.Errorf("version %d: loaded filter differs", v)
+		}
+	}
+}
+
+func TestSnapshotVersionMismatch(t *testing.T) {
+	c := NewCuckooFilter(100, 0.01)
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	raw := buf.Bytes()
+	binary.LittleEndian.PutUint32(raw[4:], SnapshotVersion+1)
+	_, err := ReadCuckoo(bytes.NewReader(raw))
+	var mismatch *ErrVersionMismatch
+	if !errors.As(err, &mismatch) || mismatch.Got != SnapshotVersion+1 {
+		t.Errorf("got %v, want *ErrVersionMismatch", err)
+	}
+	if _, err := c.WriteVersion(io.Discard, 0); !errors.As(err, &mismatch) {
+		t.Errorf("writing version 0: got %v, want *ErrVersionMismatch", err)
+	}
+	if _, err := NegotiateVersion([]uint32{SnapshotVersion + 1}); !errors.As(err, &mismatch) {
+		t.Errorf("negotiating: got %v, want *ErrVersionMismatch", err)
+	}
+	if v, err := NegotiateVersion([]uint32{1, 2, SnapshotVersion + 1}); err != nil || v != 2 {
+		t.Errorf("negotiated %d, %v, want 2", v, err)
+	}
+}
+
+func TestMigrateSnapshot(t *testing.T) {
+	c := NewCuckooFilter(1000, 0.01)
+	for i := 0; i < 500; i++ {
+		c.insert(strconv.Itoa(i))
+	}
+	path := filepath.Join(t.TempDir(), "snap")
+	var buf bytes.Buffer
+	c.WriteVersion(&buf, 1)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for v := uint32(2); v <= SnapshotVersion; v++ {
+		if err := migrateSnapshot(path, v); err != nil {
+			t.Fatalf("to version %d: %v", v, err)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.LittleEndian.Uint32(raw[4:]); got != v {
+			t.Errorf("migrated to %d, file has version %d", v, got)
+		}
+		d, err := ReadCuckoo(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.Equal(c) {
+			t.Errorf("version %d: migrated filter differs", v)
+		}
+	}
+}
+
+// header returns a dense version 4 header of a plain filter
+func header(m, b, f uint64) []byte {
+	le := binary.LittleEndian
+	h := []byte(snapshotMagic)
+	h = le.AppendUint32(h, SnapshotVersion)
+	h = le.AppendUint32(h, 0)
+	h = le.AppendUint32(h, HashSHA1.ID)
+	h = le.AppendUint64(h, 0)
+	for _, v := range []uint64{100, m, b, f, 0} {
+		h = le.AppendUint64(h, v)
+	}
+	return le.AppendUint32(h, 0)
+}
+
+func TestSnapshotHostileHeader(t *testing.T) {
+	var corrupt *ErrCorruptSnapshot
+	// a fingerprint wider than the hash
+	if _, err := ReadCuckoo(bytes.NewReader(header(1024, 4, maxFingerprintBytes+1))); !errors.As(err, &corrupt) {
+		t.Errorf("f = %d: got %v, want *ErrCorruptSnapshot", maxFingerprintBytes+1, err)
+	}
+	// 256 GiB of buckets announced, none present: rejected once the input
+	// ends, without allocating the slab
+	if _, err := ReadCuckoo(bytes.NewReader(header(1<<36, 4, 1))); !errors.As(err, &corrupt) {
+		t.Errorf("huge slab: got %v, want *ErrCorruptSnapshot", err)
+	}
+	// the same, sparse with no entries
+	sparse := header(1<<36, 4, 1)
+	binary.LittleEndian.PutUint32(sparse[8:], snapshotSparse)
+	sparse = binary.LittleEndian.AppendUint64(sparse, 0)
+	if _, err := ReadCuckoo(bytes.NewReader(sparse)); !errors.As(err, &corrupt) {
+		t.Errorf("huge sparse slab: got %v, want *ErrCorruptSnapshot", err)
+	}
+}
+
+func TestSnapshotKeyMismatch(t *testing.T) {
+	var plain, keyed bytes.Buffer
+	NewCuckooFilter(100, 0.01).WriteTo(&plain)
+	NewCuckooFilter(100, 0.01, WithKey([]byte("k"))).WriteTo(&keyed)
+	if _, err := ReadCuckoo(&plain, WithKey([]byte("k"))); !errors.Is(err, ErrIncompatibleParams) {
+		t.Errorf("plain with key: got %v, want ErrIncompatibleParams", err)
+	}
+	if _, err := ReadCuckoo(&keyed); !errors.Is(err, ErrIncompatibleParams) {
+		t.Errorf("keyed without key: got %v, want ErrIncompatibleParams", err)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// Stability policy.
+//
+// The cuckoo filter, the blocked Bloom filter and everything built on them
+// (screener, cascade, rotating and frozen filters) are stable: their
+// behavior, parameters and hashing only change with a migration path.
+//
+// The Morton, vacuum and adaptive filters are experimental. Their layout,
+// hashing and API may change or disappear in any version, and data built
+// with one version is not expected to be readable by the next. They are only
+// compiled with the experimental build tag:
+//
+//	go build -tags experimental
+//
+// so production builds cannot depend on them by accident. Code that should
+// work in both builds can check ExperimentalFilters.
@@ -0,0 +1,12 @@
+//go:build !experimental
+
+package main
+
+// ExperimentalFilters is false in default builds: the Morton, vacuum and
+// adaptive filters are left out (see stability.go)
+const ExperimentalFilters = false
+
+// estimateExperimental returns 0: the experimental filters are not built
+func estimateExperimental(params *Config) uint64 {
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import "bytes"
+
+// stashSize is the number of fingerprints the victim stash can hold
+const stashSize = 8
+
+// stashEntry is a fingerprint that could not be placed after the maximum
+// number of relocations, with one of its two candidate buckets
+// (the other one is altIndex(i, f))
+type stashEntry struct {
+	i uint
+	f fingerprint
+}
+
+// stash adds a homeless fingerprint to the victim stash.
+// Without the stash, the last relocated fingerprint is lost when an insertion
+// gives up, and a previously inserted item would become a false negative.
+// It returns false if the stash is full.
+func (c *Cuckoo) stash(i uint, f fingerprint) bool {
+	if len(c.victims) >= stashSize {
+		return false
+	}
+	c.victims = append(c.victims, stashEntry{i: i, f: f})
+	return true
+}
+
+// stashed returns the position in the stash of the fingerprint f of an item
+// whose candidate buckets are i1 and i2
+func (c *Cuckoo) stashed(i1, i2 uint, f fingerprint) (int, bool) {
+	for k, e := range c.victims {
+		if (e.i == i1 || e.i == i2) && bytes.Equal(e.f, f) {
+			return k, true
+		}
+	}
+	return -1, false
+}
+
+// unstash removes the k-th entry of the stash
+func (c *Cuckoo) unstash(k int) {
+	last := len(c.victims) - 1
+	c.victims[k] = c.victims[last]
+	c.victims[last] = stashEntry{}
+	c.victims = c.victims[:last]
+}
+
+// drainStash moves stashed fingerprints back into their buckets when a slot
+// became free (after a delete)
+func (c *Cuckoo) drainStash() {
+	for k := 0; k < len(c.victims); {
+		e := c.victims[k]
+		if c.place(e.i, e.f) || c.place(c.altIndex(e.i, e.f), e.f) {
+			c.unstash(k)
+			continue
+		}
+		k++
+	}
+}
+
+// place stores the fingerprint in an empty slot of bucket i, if there is one
+func (c *Cuckoo) place(i uint, f fingerprint) bool {
+	for j := uint(0); j < c.b; j++ {
+		if e := c.entry(i, j); isEmpty(e) {
+			copy(e, f)
+			return true
+		}
+	}
+	return false
+}
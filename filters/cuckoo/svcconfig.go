@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Service configuration.
+// A service lists the filters it builds in a JSON file:
+//
+//	{"filters": {
+//	  "sanctions": {"type": "cuckoo", "capacity": 1000000, "fprate": 0.0001},
+//	  "dust": {"type": "bloom", "capacity": 50000, "fprate": 0.01},
+//	  "utxos": {"type": "cuckoo", "capacity": 100000, "fprate": 0.001, "extractor": "outpoint"}}}
+//
+// The file is checked against ServiceConfigSchema: unknown fields are
+// rejected (a misspelled "fp_rate" must not silently leave the default), and
+// every error is reported with its path. Each field of a listed filter can
+// be overridden from the environment, as BLOOM_FILTERS_<NAME>_<FIELD> with
+// the name and the field upper-cased and '-' as '_' (e.g.,
+// BLOOM_FILTERS_SANCTIONS_FPRATE=0.0001); overrides are checked like the
+// file. "-config-validate file" prints the resulting configuration.
+
+// ServiceConfigSchema is the JSON Schema of the configuration file, for
+// editors and CI; LoadServiceConfig enforces the same rules, and also
+// refuses the types the binary does not build ("morton" and "vacuum" need
+// the experimental tag, see ExperimentalFilters)
+const ServiceConfigSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["filters"],
+  "additionalProperties": false,
+  "properties": {
+    "filters": {
+      "type": "object",
+      "minProperties": 1,
+      "additionalProperties": {
+        "type": "object",
+        "required": ["type", "capacity", "fprate"],
+        "additionalProperties": false,
+        "properties": {
+          "type": {"enum": ["cuckoo", "bloom", "morton", "vacuum"]},
+          "capacity": {"type": "integer", "minimum": 1},
+          "fprate": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+          "max_load": {"type": "number", "minimum": 0, "maximum": 1},
+          "snapshot": {"type": "string"},
+          "extractor": {"type": "string"}
+        }
+      }
+    }
+  }
+}`
+
+// ServiceConfig is the configuration of the filters of a service
+type ServiceConfig struct {
+	Filters map[string]FilterConfig `json:"filters"`
+}
+
+// FilterConfig is the configuration of one filter
+type FilterConfig struct {
+	Type      string  `json:"type"` // a FilterType name
+	Capacity  uint    `json:"capacity"`
+	FPRate    float64 `json:"fprate"`
+	MaxLoad   float64 `json:"max_load,omitempty"`  // see WithMaxLoadFactor, 0 for no limit
+	Snapshot  string  `json:"snapshot,omitempty"`  // snapshot to load, if any
+	Extractor string  `json:"extractor,omitempty"` // a KeyExtractor name, "raw" if empty
+}
+
+// filterTypes are the filter types a service can build, by name.
+// There is no xor filter in Go, and the Morton and vacuum filters are only
+// in experimental builds (see buildable).
+var filterTypes = map[string]FilterType{
+	CuckooType.String(): CuckooType,
+	BloomType.String():  BloomType,
+	MortonType.String(): MortonType,
+	VacuumType.String(): VacuumType,
+}
+
+// buildable reports whether this binary builds filters of type t
+func (t FilterType) buildable() bool {
+	switch t {
+	case CuckooType, BloomType:
+		return true
+	case MortonType, VacuumType:
+		return ExperimentalFilters
+	}
+	return false
+}
+
+// FilterType returns the type of the filter
+func (f FilterConfig) FilterType() FilterType {
+	return filterTypes[f.Type]
+}
+
+// LoadServiceConfig reads a configuration, applies the overrides of the
+// environment (looked up with lookupEnv, e.g. os.LookupEnv) and checks it.
+// The error lists every problem found.
+func LoadServiceConfig(r io.Reader, lookupEnv func(string) (string, bool)) (*ServiceConfig, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var cfg ServiceConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	var errs []error
+	for _, name := range cfg.names() {
+		f := cfg.Filters[name]
+		if err := f.override(name, lookupEnv); err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, f.check("filters."+name)...)
+		cfg.Filters[name] = f
+	}
+	if len(cfg.Filters) == 0 {
+		errs = append(errs, errors.New("filters: no filter configured"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &cfg, nil
+}
+
+// names returns the filter names in order
+func (cfg *ServiceConfig) names() []string {
+	names := make([]string, 0, len(cfg.Filters))
+	for name := range cfg.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envName returns the environment variable overriding a field of a filter
+func envName(filter, field string) string {
+	return strings.ToUpper(strings.ReplaceAll("BLOOM_FILTERS_"+filter+"_"+field, "-", "_"))
+}
+
+// override applies the environment overrides of the filter name
+func (f *FilterConfig) override(name string, lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	set := func(field string, parse func(string) error) {
+		v, ok := lookupEnv(envName(name, field))
+		if !ok {
+			return
+		}
+		if err := parse(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envName(name, field), err))
+		}
+	}
+	set("type", func(v string) error {
+		f.Type = v
+		return nil
+	})
+	set("capacity", func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 0)
+		if err == nil {
+			f.Capacity = uint(n)
+		}
+		return err
+	})
+	set("fprate", func(v string) error {
+		r, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			f.FPRate = r
+		}
+		return err
+	})
+	set("max_load", func(v string) error {
+		lf, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			f.MaxLoad = lf
+		}
+		return err
+	})
+	set("snapshot", func(v string) error {
+		f.Snapshot = v
+		return nil
+	})
+	set("extractor", func(v string) error {
+		f.Extractor = v
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+// check returns the violations of the schema, prefixed with path
+func (f *FilterConfig) check(path string) []error {
+	var errs []error
+	if t, ok := filterTypes[f.Type]; !ok {
+		errs = append(errs, fmt.Errorf("%s.type: unknown filter type %q", path, f.Type))
+	} else if !t.buildable() {
+		errs = append(errs, fmt.Errorf("%s.type: %q filters need a build with the experimental tag", path, f.Type))
+	}
+	if f.Capacity < 1 {
+		errs = append(errs, fmt.Errorf("%s.capacity: must be at least 1", path))
+	}
+	if !(f.FPRate > 0 && f.FPRate < 1) {
+		errs = append(errs, fmt.Errorf("%s.fprate: %v is not in (0, 1)", path, f.FPRate))
+	}
+	if !(f.MaxLoad >= 0 && f.MaxLoad <= 1) {
+		errs = append(errs, fmt.Errorf("%s.max_load: %v is not in [0, 1]", path, f.MaxLoad))
+	}
+	if _, err := lookupKeyExtractor(f.Extractor); err != nil {
+		errs = append(errs, fmt.Errorf("%s.extractor: %w", path, err))
+	}
+	return errs
+}
+
+// validateConfig loads the configuration file at path with the overrides
+// of the environment, and prints the effective configuration
+func validateConfig(path string, w io.Writer) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	cfg, err := LoadServiceConfig(in, os.LookupEnv)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
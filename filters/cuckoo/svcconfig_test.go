@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadServiceConfig(t *testing.T) {
+	in := `{"filters": {
+	  "sanctions": {"type": "cuckoo", "capacity": 1000000, "fprate": 0.001, "max_load": 0.9},
+	  "dust": {"type": "bloom", "capacity": 50000, "fprate": 0.01}}}`
+	env := map[string]string{
+		"BLOOM_FILTERS_SANCTIONS_FPRATE": "0.0001",
+		"BLOOM_FILTERS_DUST_SNAPSHOT":    "/var/lib/dust.snap",
+		"BLOOM_FILTERS_OTHER_FPRATE":     "garbage", // not a configured filter
+	}
+	lookupEnv := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	cfg, err := LoadServiceConfig(strings.NewReader(in), lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Filters["sanctions"]
+	if s.FPRate != 0.0001 || s.Capacity != 1000000 || s.MaxLoad != 0.9 || s.FilterType() != CuckooType {
+		t.Errorf("sanctions = %+v", s)
+	}
+	if d := cfg.Filters["dust"]; d.Snapshot != "/var/lib/dust.snap" || d.FilterType() != BloomType {
+		t.Errorf("dust = %+v", d)
+	}
+}
+
+func TestLoadServiceConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in, env string
+		want    []string // in the error
+	}{
+		{in: `{"filters": {}}`, want: []string{"no filter"}},
+		{in: `{"filters": {"a": {"type": "cuckoo", "capacity": 1, "fp_rate": 0.01}}}`, want: []string{"fp_rate"}},
+		{in: `{"filters": {"a": {"type": "xor", "capacity": 1, "fprate": 0.01}}}`, want: []string{"filters.a.type"}},
+		{
+			in:   `{"filters": {"a": {"type": "cuckoo", "capacity": 0, "fprate": 1, "max_load": 2}}}`,
+			want: []string{"filters.a.capacity", "filters.a.fprate", "filters.a.max_load"},
+		},
+		{
+			in:   `{"filters": {"a": {"type": "cuckoo", "capacity": 1, "fprate": 0.01}}}`,
+			env:  "BLOOM_FILTERS_A_CAPACITY",
+			want: []string{"BLOOM_FILTERS_A_CAPACITY"},
+		},
+	} {
+		lookupEnv := func(k string) (string, bool) { return "-1", k == tc.env }
+		_, err := LoadServiceConfig(strings.NewReader(tc.in), lookupEnv)
+		if err == nil {
+			t.Errorf("%s: loaded", tc.in)
+			continue
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(err.Error(), w) {
+				t.Errorf("%s: error %q lacks %q", tc.in, err, w)
+			}
+		}
+	}
+}
+
+func TestServiceConfigTypes(t *testing.T) {
+	for name, want := range map[string]bool{
+		"cuckoo": true,
+		"bloom":  true,
+		"morton": ExperimentalFilters,
+		"vacuum": ExperimentalFilters,
+		"xor":    false,
+	} {
+		in := `{"filters": {"a": {"type": "` + name + `", "capacity": 1000, "fprate": 0.01}}}`
+		_, err := LoadServiceConfig(strings.NewReader(in), noEnv)
+		if got := err == nil; got != want {
+			t.Errorf("%s filter: loaded %v, want %v (%v)", name, got, want, err)
+		}
+		if !want && name != "xor" && !strings.Contains(err.Error(), "experimental") {
+			t.Errorf("%s filter: %v", name, err)
+		}
+	}
+	// every known type is in the schema
+	for name := range filterTypes {
+		if !strings.Contains(ServiceConfigSchema, `"`+name+`"`) {
+			t.Errorf("%s missing from the schema", name)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filters.json")
+	if err := os.WriteFile(path, []byte(`{"filters": {"a": {"type": "cuckoo", "capacity": 10, "fprate": 0.5}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := validateConfig(path, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"fprate": 0.5`) {
+		t.Errorf("effective configuration:\n%s", out.String())
+	}
+	if err := validateConfig(filepath.Join(t.TempDir(), "missing.json"), &out); err == nil {
+		t.Error("missing file validated")
+	}
+}
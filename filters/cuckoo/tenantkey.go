@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Per-tenant keyed fingerprints.
+// With the plain hash, the same address has the same fingerprint and buckets
+// in every filter, so anyone holding two tenants' snapshots can tell which
+// entries they share. With a key, the fingerprint and the first bucket come
+// from HMAC-SHA256(key, item), a pseudo-random function: without the key,
+// the fingerprints of one tenant cannot be matched against another's, nor
+// against a list of candidate addresses.
+
+// DeriveTenantKey derives the fingerprint key of a tenant from a master key,
+// so only the master key has to be stored
+func DeriveTenantKey(master []byte, tenant string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("cuckoo fingerprint key\x00"))
+	mac.Write([]byte(tenant))
+	return mac.Sum(nil)
+}
+
+// WithKey hashes the items with HMAC-SHA256 under key instead of SHA1.
+// Filters built with different keys do not share fingerprints for the same
+// items, and a filter can only be queried with its own key.
+func WithKey(key []byte) Option {
+	return func(c *Cuckoo) {
+		c.key = append([]byte(nil), key...)
+	}
+}
+
+// Rekey returns a filter with the same parameters and settings as c, keyed
+// with key and holding items. Fingerprints cannot be converted from one key
+// to another, so key rotation re-encodes the filter from its source items.
+// Rekey only reads c: it can run in the background while c keeps serving,
+// and the caller swaps the filters once it returns. A failed allocation of
+// the buckets is returned as an error.
+func (c *Cuckoo) Rekey(key []byte, items []string) (*Cuckoo, error) {
+	fresh := *c
+	fresh.key = append([]byte(nil), key...)
+	fresh.count = 0
+	fresh.victims = nil
+	if c.latency != nil {
+		fresh.latency = new(latencyStats)
+	}
+	var err error
+	if fresh.slots, err = fresh.tryAllocSlots(uint(len(c.slots))); err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if err := fresh.insert(item); err != nil {
+			fresh.Close()
+			return nil, err
+		}
+	}
+	return &fresh, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+)
+
+// Token transfer screening.
+// An EVM token transfer is a call to the token contract, so screening the
+// destination of the transaction only screens the contract: the parties are
+// in the calldata (transfer, transferFrom, safeTransferFrom) or, once mined,
+// in the topics of the Transfer event log. Both ERC-20 and ERC-721 emit
+//
+//	Transfer(address indexed from, address indexed to, uint256 value)
+//
+// with the value (amount) in the data for ERC-20, and as a fourth topic
+// (token ID) for ERC-721.
+// ScreenTransfer screens the token contract, the sender and the recipient
+// separately, as lowercase "0x" hex strings (the form of public sanction
+// lists), and returns a verdict for each.
+
+// TokenStandard is the token standard of a transfer
+type TokenStandard uint8
+
+const (
+	StandardUnknown TokenStandard = iota // transferFrom calldata is the same for both
+	StandardERC20
+	StandardERC721
+)
+
+func (s TokenStandard) String() string {
+	switch s {
+	case StandardERC20:
+		return "erc20"
+	case StandardERC721:
+		return "erc721"
+	}
+	return "unknown"
+}
+
+// Function selectors, the first 4 bytes of the calldata
+var (
+	selectorTransfer             = []byte{0xa9, 0x05, 0x9c, 0xbb} // transfer(address,uint256)
+	selectorTransferFrom         = []byte{0x23, 0xb8, 0x72, 0xdd} // transferFrom(address,address,uint256)
+	selectorSafeTransferFrom     = []byte{0x42, 0x84, 0x2e, 0x0e} // safeTransferFrom(address,address,uint256)
+	selectorSafeTransferFromData = []byte{0xb8, 0x8d, 0x4f, 0xde} // safeTransferFrom(address,address,uint256,bytes)
+
+	// topicTransfer is keccak256("Transfer(address,address,uint256)")
+	topicTransfer, _ = hex.DecodeString("ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
+
+// TokenTransfer is a decoded token transfer
+type TokenTransfer struct {
+	Standard TokenStandard
+	Token    [20]byte // token contract
+	From     [20]byte
+	To       [20]byte
+	Value    *big.Int // amount (ERC-20) or token ID (ERC-721)
+}
+
+// abiAddress decodes an address from a 32-byte ABI word, in which it is
+// left-padded with zeros
+func abiAddress(word []byte) (a [20]byte, ok bool) {
+	if len(word) != 32 {
+		return a, false
+	}
+	for _, b := range word[:12] {
+		if b != 0 {
+			return a, false
+		}
+	}
+	copy(a[:], word[12:])
+	return a, true
+}
+
+// DecodeTokenCall decodes a call to the token contract. sender is the caller
+// (the transaction sender), which is the source of the tokens of a transfer.
+// ok is false for any other call, and for calldata that is not a valid ABI
+// encoding of one.
+func DecodeTokenCall(token, sender [20]byte, calldata []byte) (t TokenTransfer, ok bool) {
+	t.Token = token
+	args := calldata[min(4, len(calldata)):]
+	var words int // number of static argument words
+	switch {
+	case bytes.HasPrefix(calldata, selectorTransfer) && len(args) == 2*32:
+		t.Standard, t.From, words = StandardERC20, sender, 2
+		if t.To, ok = abiAddress(args[:32]); !ok {
+			return t, false
+		}
+	case bytes.HasPrefix(calldata, selectorTransferFrom) && len(args) == 3*32:
+		t.Standard, words = StandardUnknown, 3
+	case bytes.HasPrefix(calldata, selectorSafeTransferFrom) && len(args) == 3*32,
+		// the bytes argument is dynamic: its offset and data follow the static words
+		bytes.HasPrefix(calldata, selectorSafeTransferFromData) && len(args) >= 4*32:
+		t.Standard, words = StandardERC721, 3
+	default:
+		return t, false
+	}
+	if words == 3 {
+		var okFrom, okTo bool
+		t.From, okFrom = abiAddress(args[:32])
+		t.To, okTo = abiAddress(args[32:64])
+		if !okFrom || !okTo {
+			return t, false
+		}
+	}
+	t.Value = new(big.Int).SetBytes(args[(words-1)*32 : words*32])
+	return t, true
+}
+
+// DecodeTransferLog decodes a Transfer event log emitted by the token
+// contract at address. ok is false for any other log.
+func DecodeTransferLog(address [20]byte, topics [][]byte, data []byte) (t TokenTransfer, ok bool) {
+	if len(topics) < 3 || !bytes.Equal(topics[0], topicTransfer) {
+		return t, false
+	}
+	t.Token = address
+	var okFrom, okTo bool
+	t.From, okFrom = abiAddress(topics[1])
+	t.To, okTo = abiAddress(topics[2])
+	if !okFrom || !okTo {
+		return t, false
+	}
+	switch {
+	case len(topics) == 3 && len(data) == 32:
+		t.Standard, t.Value = StandardERC20, new(big.Int).SetBytes(data)
+	case len(topics) == 4 && len(topics[3]) == 32 && len(data) == 0:
+		t.Standard, t.Value = StandardERC721, new(big.Int).SetBytes(topics[3])
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+// PartyVerdict is the screening verdict of one party of a transfer
+type PartyVerdict struct {
+	Party   string // "token", "from" or "to"
+	Address [20]byte
+	Verdict Verdict
+}
+
+// TransferVerdict is the outcome of screening a token transfer
+type TransferVerdict struct {
+	Transfer TokenTransfer
+	Parties  []PartyVerdict // token, from, to
+}
+
+// Matched returns the parties that hit a filter of the chain, and those
+// that could not be screened (their Verdict.Err is not nil), so a transfer
+// is never cleared on a failed lookup
+func (v TransferVerdict) Matched() []PartyVerdict {
+	var hits []PartyVerdict
+	for _, p := range v.Parties {
+		if p.Verdict.Matched != nil || p.Verdict.Err() != nil {
+			hits = append(hits, p)
+		}
+	}
+	return hits
+}
+
+// ScreenTransfer screens the token contract, the sender and the recipient of
+// a transfer through the screener
+func ScreenTransfer(s *Screener, t TokenTransfer) TransferVerdict {
+	v := TransferVerdict{Transfer: t}
+	for _, p := range []struct {
+		name string
+		addr [20]byte
+	}{{"token", t.Token}, {"from", t.From}, {"to", t.To}} {
+		v.Parties = append(v.Parties, PartyVerdict{
+			Party:   p.name,
+			Address: p.addr,
+			Verdict: s.Check(evmAddress(p.addr)),
+		})
+	}
+	return v
+}
+
+// evmAddress formats an address as it is screened: lowercase "0x" hex
+func evmAddress(a [20]byte) string {
+	return "0x" + hex.EncodeToString(a[:])
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestTransferVerdictMatched(t *testing.T) {
+	transfer := TokenTransfer{Token: [20]byte{1}, From: [20]byte{2}, To: [20]byte{3}, Value: big.NewInt(1)}
+	f := NewCuckooFilter(100, 0.01)
+	f.insert(evmAddress(transfer.To))
+
+	ok := NewScreener()
+	ok.Add("sanctions", f, 0.01, MapSet{evmAddress(transfer.To): {}})
+	hits := ScreenTransfer(ok, transfer).Matched()
+	if len(hits) != 1 || hits[0].Party != "to" {
+		t.Errorf("matched %+v, want the recipient", hits)
+	}
+
+	failing := NewScreener()
+	failing.Add("sanctions", f, 0.01, failingSet{})
+	hits = ScreenTransfer(failing, transfer).Matched()
+	if len(hits) != 1 || hits[0].Party != "to" || hits[0].Verdict.Err() == nil {
+		t.Errorf("matched %+v, want the recipient that failed screening", hits)
+	}
+}
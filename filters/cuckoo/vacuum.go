@@ -0,0 +1,151 @@
+//go:build experimental
+
+package main
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+)
+
+// Vacuum filter based on https://www.vldb.org/pvldb/vol13/p197-wang.pdf
+// (M. Wang, M. Zhou, S. Shi, C. Qian, "Vacuum Filters: More Space-Efficient and
+// Faster Replacement for Bloom and Cuckoo Filters").
+//
+// The cuckoo filter needs a power-of-two number of buckets so that
+// i ^ hash(f) stays in the table, and nextPower can nearly double the memory
+// when n is just above a power of two. The vacuum filter splits the table into
+// chunks of L buckets (L a power of two) and keeps the alternate bucket in the
+// chunk of the first one: only the low log2(L) bits are XORed. The table then
+// only has to be a multiple of L, so at most L-1 buckets are wasted.
+// The buckets are stored in one slab of fingerprints, as in the cuckoo
+// filter (see slab.go), so the filter uses m*b*f bytes.
+
+// vacuumChunk is the largest chunk size (alternate range) in buckets.
+// Smaller tables use a single chunk of nextPower(m) buckets.
+const vacuumChunk = 1 << 12
+
+// vacuumLoad is the target load factor of a vacuum filter
+const vacuumLoad = 0.95
+
+// Vacuum is a vacuum filter
+type Vacuum struct {
+	slots []byte // m buckets of b entries of f bytes, all zeros when empty
+	m     uint   // number of buckets, a multiple of the chunk size
+	l     uint   // chunk size (alternate range), a power of two
+	b     uint   // number of entries per bucket
+	f     uint   // fingerprint length in b_size-bit units
+}
+
+var _ DeletableFilter = (*Vacuum)(nil)
+
+// vacuumParams returns the number of buckets (m), the chunk size (l) and
+// the fingerprint length (f) of a vacuum filter for n items and a false positive rate e
+func vacuumParams(n uint, e float64) (uint, uint, uint) {
+	f := fingerprintLength(b, e)
+	m := uint(float64(n)/(float64(b)*vacuumLoad)) + 1
+
+	l := uint(vacuumChunk)
+	if m < l {
+		l = nextPower(m)
+	}
+	// round m up to a multiple of the chunk size
+	m = (m + l - 1) / l * l
+	return m, l, f
+}
+
+// NewVacuumFilter creates a vacuum filter for n items with the false positive rate e
+// (e.g., 0.01), with the same bucket size and fingerprint length as NewCuckooFilter
+func NewVacuumFilter(n uint, e float64) *Vacuum {
+	m, l, f := vacuumParams(n, e)
+	return &Vacuum{slots: make([]byte, m*b*f), m: m, l: l, b: b, f: f}
+}
+
+// entry returns entry j of bucket i, in the filter's storage
+func (v *Vacuum) entry(i, j uint) fingerprint {
+	o := (i*v.b + j) * v.f
+	return fingerprint(v.slots[o : o+v.f : o+v.f])
+}
+
+// find returns the entry of bucket i holding the fingerprint f
+func (v *Vacuum) find(i uint, f fingerprint) (uint, bool) {
+	for j := uint(0); j < v.b; j++ {
+		if bytes.Equal(v.entry(i, j), f) {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// place stores f in a free entry of bucket i, if it has one
+func (v *Vacuum) place(i uint, f fingerprint) bool {
+	for j := uint(0); j < v.b; j++ {
+		if e := v.entry(i, j); isEmpty(e) {
+			copy(e, f)
+			return true
+		}
+	}
+	return false
+}
+
+// hashes returns the two candidate buckets and the fingerprint of an item
+func (v *Vacuum) hashes(data string) (uint, uint, fingerprint) {
+	h := hash([]byte(data))
+
+	// m is not a power of two: reduce the hash with Lemire's fastrange
+	// (https://lemire.me/blog/2016/06/27/a-fast-alternative-to-the-modulo-reduction/),
+	// the high 64 bits of the 128-bit product h * m
+	hi, _ := bits.Mul64(hashIndex(h), uint64(v.m))
+	i1 := uint(hi)
+
+	f := fingerprint(h[0:v.f])
+	f = nonZero(f)
+	return i1, v.altIndex(i1, f), f
+}
+
+// altIndex returns the alternate bucket of a fingerprint stored in bucket i,
+// in the same chunk of l buckets as i
+func (v *Vacuum) altIndex(i uint, f fingerprint) uint {
+	return i ^ uint(hashIndex(hash(f)))&(v.l-1)
+}
+
+// insert adds an item to the vacuum filter, relocating entries like the cuckoo filter
+func (v *Vacuum) insert(input string) error {
+	i1, i2, f := v.hashes(input)
+	if v.place(i1, f) || v.place(i2, f) {
+		return nil
+	}
+
+	i := i1
+	for r := 0; r < retries; r++ {
+		// swap, byte by byte: f is the hash of this insert, not filter storage
+		e := v.entry(i, uint(rand.Intn(int(v.b))))
+		for k := range f {
+			f[k], e[k] = e[k], f[k]
+		}
+		i = v.altIndex(i, f)
+		if v.place(i, f) {
+			return nil
+		}
+	}
+	return ErrFull
+}
+
+// lookup needle in the vacuum filter
+func (v *Vacuum) lookup(needle string) bool {
+	i1, i2, f := v.hashes(needle)
+	_, b1 := v.find(i1, f)
+	_, b2 := v.find(i2, f)
+	return b1 || b2
+}
+
+// delete the fingerprint from the vacuum filter
+func (v *Vacuum) delete(needle string) {
+	i1, i2, f := v.hashes(needle)
+	for _, i := range []uint{i1, i2} {
+		if j, ok := v.find(i, f); ok {
+			clear(v.entry(i, j))
+			return
+		}
+	}
+}
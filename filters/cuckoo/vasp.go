@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Travel-rule VASP directory.
+// A withdrawal to an address controlled by another VASP (exchange,
+// custodian) must carry originator and beneficiary information (FATF
+// recommendation 16, the "travel rule"). Partners publish feeds of their
+// deposit addresses; the directory holds them in a filter, for the fast
+// negative answer on the many withdrawals to private wallets, and in a
+// payload map from address to the VASP and its risk score, which confirms
+// the filter hits and carries what the travel-rule workflow needs.
+//
+// A feed is CSV with a header line and the columns address, vasp, risk:
+//
+//	address,vasp,risk
+//	bc1q...,exchange-a,20
+//
+// When feeds disagree on an address, the last feed added names the VASP and
+// the highest risk score is kept.
+
+// VASPRecord is the directory entry of a deposit address
+type VASPRecord struct {
+	Address string
+	VASP    string  // identifier of the VASP in the directory
+	Risk    float64 // risk score of the VASP, as published by the feed
+	Source  string  // feed the record comes from
+}
+
+// VASPDirectory is a filter of VASP deposit addresses with their records.
+// It is safe for concurrent use, so feeds can be refreshed while the
+// withdrawal pipeline queries it.
+type VASPDirectory struct {
+	mu      sync.RWMutex
+	filter  Filter
+	records map[string]VASPRecord
+}
+
+var _ Lookuper = (*VASPDirectory)(nil)
+
+// NewVASPDirectory returns a directory sized for n addresses, with a filter
+// false positive rate of e
+func NewVASPDirectory(n uint, e float64) *VASPDirectory {
+	return &VASPDirectory{filter: NewCuckooFilter(n, e), records: make(map[string]VASPRecord)}
+}
+
+// Add adds or updates the records.
+// It returns ErrEmptyKey, and adds nothing, if a record has no address.
+func (d *VASPDirectory) Add(records ...VASPRecord) error {
+	for _, r := range records {
+		if r.Address == "" {
+			return ErrEmptyKey
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range records {
+		old, ok := d.records[r.Address]
+		if ok {
+			r.Risk = max(r.Risk, old.Risk)
+		} else if err := d.filter.insert(r.Address); err != nil {
+			return fmt.Errorf("vasp directory: %w", err)
+		}
+		d.records[r.Address] = r
+	}
+	return nil
+}
+
+// LoadFeed reads a partner feed (see above) and adds its records under the
+// name source. Nothing is added if the feed is malformed.
+func (d *VASPDirectory) LoadFeed(r io.Reader, source string) (int, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return 0, fmt.Errorf("feed %s: %w", source, err)
+	}
+	if strings.Join(header, ",") != "address,vasp,risk" {
+		return 0, fmt.Errorf("feed %s: header %q, want \"address,vasp,risk\"", source, strings.Join(header, ","))
+	}
+
+	var records []VASPRecord
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("feed %s: %w", source, err)
+		}
+		if row[0] == "" {
+			line, _ := cr.FieldPos(0)
+			return 0, fmt.Errorf("feed %s line %d: %w", source, line, ErrEmptyKey)
+		}
+		risk, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			line, _ := cr.FieldPos(2)
+			return 0, fmt.Errorf("feed %s line %d: invalid risk %q", source, line, row[2])
+		}
+		records = append(records, VASPRecord{Address: row[0], VASP: row[1], Risk: risk, Source: source})
+	}
+	return len(records), d.Add(records...)
+}
+
+// Lookup returns the record of a VASP deposit address
+func (d *VASPDirectory) Lookup(address string) (VASPRecord, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if !d.filter.lookup(address) {
+		return VASPRecord{}, false
+	}
+	r, ok := d.records[address]
+	return r, ok
+}
+
+// lookup returns true if the address belongs to a VASP of the directory.
+// The payload map confirms filter hits, so the answer is exact.
+func (d *VASPDirectory) lookup(address string) bool {
+	_, ok := d.Lookup(address)
+	return ok
+}
+
+// Vars returns the policy variables of a withdrawal address (see Policy.Decide):
+// vasp is 1 for a VASP deposit address, and vaspRisk is its risk score, so a
+// policy can route them to the travel-rule workflow:
+//
+//	travel-rule if vasp && vaspRisk <= 70
+//	review if vasp && vaspRisk > 70
+func (d *VASPDirectory) Vars(address string) map[string]float64 {
+	r, ok := d.Lookup(address)
+	return map[string]float64{"vasp": boolValue(ok), "vaspRisk": r.Risk}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVASPDirectory(t *testing.T) {
+	d := NewVASPDirectory(1000, 0.01)
+	n, err := d.LoadFeed(strings.NewReader("address,vasp,risk\nbc1qa,exchange-a,20\nbc1qb,exchange-a,80\n"), "partner-a")
+	if err != nil || n != 2 {
+		t.Fatalf("LoadFeed = %d, %v", n, err)
+	}
+	// a later feed names the VASP, the highest risk is kept
+	if _, err := d.LoadFeed(strings.NewReader("address,vasp,risk\nbc1qa,custodian-b,10\n"), "partner-b"); err != nil {
+		t.Fatal(err)
+	}
+	r, ok := d.Lookup("bc1qa")
+	if !ok || r.VASP != "custodian-b" || r.Risk != 20 || r.Source != "partner-b" {
+		t.Errorf("Lookup(bc1qa) = %+v, %v", r, ok)
+	}
+	if _, ok := d.Lookup("bc1qprivate"); ok || d.lookup("bc1qprivate") {
+		t.Error("private wallet found")
+	}
+
+	for _, tc := range []struct {
+		address string
+		want    map[string]float64
+	}{
+		{"bc1qb", map[string]float64{"vasp": 1, "vaspRisk": 80}},
+		{"bc1qprivate", map[string]float64{"vasp": 0, "vaspRisk": 0}},
+	} {
+		got := d.Vars(tc.address)
+		if got["vasp"] != tc.want["vasp"] || got["vaspRisk"] != tc.want["vaspRisk"] {
+			t.Errorf("Vars(%s) = %v, want %v", tc.address, got, tc.want)
+		}
+	}
+}
+
+func TestVASPFeedErrors(t *testing.T) {
+	for _, feed := range []string{
+		"",
+		"address,vasp\nbc1qc,exchange-c\n",
+		"address,vasp,risk\nbc1qc,exchange-c,high\n",
+		"address,vasp,risk\nbc1qc,exchange-c\n",
+		// an empty address after a valid row
+		"address,vasp,risk\nbc1qc,exchange-c,5\n,exchange-c,5\n",
+	} {
+		d := NewVASPDirectory(100, 0.01)
+		if _, err := d.LoadFeed(strings.NewReader(feed), "bad"); err == nil {
+			t.Errorf("feed %q loaded", feed)
+		}
+		if d.lookup("bc1qc") {
+			t.Errorf("feed %q: rows added despite the error", feed)
+		}
+	}
+
+	d := NewVASPDirectory(100, 0.01)
+	err := d.Add(VASPRecord{Address: "bc1qd"}, VASPRecord{})
+	if !errors.Is(err, ErrEmptyKey) || d.lookup("bc1qd") {
+		t.Errorf("Add with an empty address = %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Test vectors for reimplementations of the filters in other languages.
+// `go run . -vectors > testdata/vectors.json` writes, for a few filter
+// parameters and a fixed list of keys, everything a compatible
+// implementation must compute identically before it can share filters with
+// the Go code: the sizing (m, f, number of blocks, k), the fingerprint and
+// both buckets of every key in a cuckoo filter, plain, keyed and with a
+// seeded hash family, the two hash halves and the block and bit positions
+// of every key in a blocked Bloom filter, and snapshots of a filter holding
+// the keys in every snapshot version.
+// The snapshot filters are large enough for every key to go to one of its
+// buckets without relocation: relocations pick their victims at random, so
+// the buckets of a fuller filter would differ from run to run.
+// testdata/vectors.json is committed, and TestVectorsFixture fails when the
+// code no longer produces it: a change of the vectors breaks the other
+// implementations, and must be deliberate.
+
+// vectorKeys covers the edge cases of the hashing: the empty key, short and
+// long keys, non-ASCII bytes, and typical txids and addresses
+var vectorKeys = []string{
+	"",
+	"a",
+	"hello",
+	"world",
+	"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq",
+	"0x742d35Cc6634C0532925a3b844Bc454e4438f44e",
+	"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+	"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b:0",
+	"café ☃ \x00", // keys stay valid UTF-8, JSON cannot carry other bytes
+	"a long key, longer than a SHA1 block of 64 bytes, to cover multi-block hashing",
+}
+
+// snapshotVectorN is the capacity of the snapshot filters: a few hundred
+// buckets, so the keys never have to relocate
+const snapshotVectorN = 1000
+
+// vectorParams are the (n, false positive rate) pairs of the vectors
+var vectorParams = []struct {
+	n uint
+	e float64
+}{
+	{10, 0.1},
+	{1000, 0.01},
+	{1000000, 0.0001},
+}
+
+type cuckooVector struct {
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"` // hex
+	I1          uint   `json:"i1"`
+	I2          uint   `json:"i2"`
+}
+
+type cuckooVectorSet struct {
+	N       uint           `json:"n"`
+	FPRate  float64        `json:"fp_rate"`
+	M       uint           `json:"m"`
+	B       uint           `json:"b"`
+	F       uint           `json:"f"`                  // fingerprint length in bytes
+	Hash    string         `json:"hash"`               // hash family name, see WithHashFamily
+	Seed    uint64         `json:"seed,omitempty"`     // hash seed, hashed before the key
+	HMACKey string         `json:"hmac_key,omitempty"` // hex, see WithKey
+	Vectors []cuckooVector `json:"vectors"`
+}
+
+type bloomVector struct {
+	Key   string `json:"key"`
+	H1    string `json:"h1"` // hex, first half of bloomHash
+	H2    string `json:"h2"` // hex, second half of bloomHash
+	Block uint   `json:"block"`
+	Bits  []uint `json:"bits"` // positions in the 512-bit block, in probe order
+}
+
+// snapshotVector is a snapshot of a cuckoo filter holding every key of
+// vectorKeys (inserted WithEmptyKeys, in order)
+type snapshotVector struct {
+	N        uint    `json:"n"`
+	FPRate   float64 `json:"fp_rate"`
+	Hash     string  `json:"hash"`
+	Seed     uint64  `json:"seed,omitempty"`
+	HMACKey  string  `json:"hmac_key,omitempty"`
+	Version  uint32  `json:"version"`
+	Snapshot string  `json:"snapshot"` // hex
+}
+
+type bloomVectorSet struct {
+	N       uint          `json:"n"`
+	FPRate  float64       `json:"fp_rate"`
+	Blocks  uint          `json:"blocks"`
+	K       uint          `json:"k"`
+	Vectors []bloomVector `json:"vectors"`
+}
+
+type testVectors struct {
+	Cuckoo    []cuckooVectorSet `json:"cuckoo"`
+	Bloom     []bloomVectorSet  `json:"bloom"`
+	Snapshots []snapshotVector  `json:"snapshots"`
+}
+
+// vectorHash is a hashing setting of the cuckoo vectors
+type vectorHash struct {
+	family *HashFamily
+	seed   uint64
+	key    []byte
+}
+
+// vectorHashes are the hashing settings of the cuckoo vectors: the default,
+// a seeded family and a keyed filter
+func vectorHashes() []vectorHash {
+	return []vectorHash{
+		{family: HashSHA1},
+		{family: HashSHA256, seed: 20240601},
+		{family: hashHMACSHA256, key: DeriveTenantKey([]byte("test vectors master key"), "tenant-a")},
+	}
+}
+
+// options returns the options building a filter with the setting
+func (h vectorHash) options() []Option {
+	if h.key != nil {
+		return []Option{WithKey(h.key)}
+	}
+	return []Option{WithHashFamily(h.family, h.seed)}
+}
+
+// writeVectors writes the test vectors as indented JSON
+func writeVectors(w io.Writer) error {
+	var tv testVectors
+
+	for _, p := range vectorParams {
+		for _, h := range vectorHashes() {
+			c := NewCuckooFilter(p.n, p.e, h.options()...)
+			set := cuckooVectorSet{N: p.n, FPRate: p.e, M: c.m, B: c.b, F: c.f, Hash: h.family.Name, Seed: h.seed, HMACKey: hex.EncodeToString(h.key)}
+			for _, k := range vectorKeys {
+				i1, i2, f := c.hashes(k)
+				set.Vectors = append(set.Vectors, cuckooVector{Key: k, Fingerprint: hex.EncodeToString(f), I1: i1, I2: i2})
+			}
+			tv.Cuckoo = append(tv.Cuckoo, set)
+		}
+
+		bf := NewBlockedBloomFilter(p.n, p.e)
+		set := bloomVectorSet{N: p.n, FPRate: p.e, Blocks: uint(len(bf.blocks)), K: bf.k}
+		for _, k := range vectorKeys {
+			h1, h2 := bloomHash(k)
+			v := bloomVector{Key: k, H1: strconv.FormatUint(h1, 16), H2: strconv.FormatUint(h2, 16)}
+			bf.probes(k, func(blk *[bloomBlockWords]uint64, bit uint) bool {
+				v.Block = bf.blockIndex(blk)
+				v.Bits = append(v.Bits, bit)
+				return true
+			})
+			set.Vectors = append(set.Vectors, v)
+		}
+		tv.Bloom = append(tv.Bloom, set)
+	}
+
+	for _, h := range vectorHashes() {
+		c := NewCuckooFilter(snapshotVectorN, 0.01, append(h.options(), WithEmptyKeys())...)
+		for _, k := range vectorKeys {
+			if err := c.insert(k); err != nil {
+				return fmt.Errorf("test vector snapshot: %w", err)
+			}
+		}
+		for version := uint32(1); version <= SnapshotVersion; version++ {
+			var buf bytes.Buffer
+			if _, err := c.WriteVersion(&buf, version); err != nil {
+				// the older versions cannot record every hash setting
+				continue
+			}
+			tv.Snapshots = append(tv.Snapshots, snapshotVector{
+				N: snapshotVectorN, FPRate: 0.01, Hash: h.family.Name, Seed: h.seed, HMACKey: hex.EncodeToString(h.key),
+				Version: version, Snapshot: hex.EncodeToString(buf.Bytes()),
+			})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(tv); err != nil {
+		return fmt.Errorf("writing test vectors: %w", err)
+	}
+	return nil
+}
+
+// blockIndex returns the index of a block of the filter
+func (bf *BlockedBloom) blockIndex(blk *[bloomBlockWords]uint64) uint {
+	for i := range bf.blocks {
+		if &bf.blocks[i] == blk {
+			return uint(i)
+		}
+	}
+	panic("block not in filter")
+}
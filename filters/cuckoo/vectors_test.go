@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+const vectorsFixture = "testdata/vectors.json"
+
+// TestVectorsFixture regenerates the test vectors and compares them with the
+// committed fixture. A difference means the hashing, the sizing or the
+// snapshot format changed for the other implementations too: if that is
+// deliberate, regenerate the fixture with go run . -vectors.
+func TestVectorsFixture(t *testing.T) {
+	want, err := os.ReadFile(vectorsFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := writeVectors(&got); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got.Bytes(), want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(got.String(), "\n"), strings.Split(string(want), "\n")
+	for i := range min(len(gotLines), len(wantLines)) {
+		if gotLines[i] != wantLines[i] {
+			t.Fatalf("%s line %d:\n got %s\nwant %s", vectorsFixture, i+1, gotLines[i], wantLines[i])
+		}
+	}
+	t.Fatalf("%s: %d lines generated, %d in the fixture", vectorsFixture, len(gotLines), len(wantLines))
+}
+
+// TestVectorSnapshots loads the snapshots of the fixture and finds every key
+// in them
+func TestVectorSnapshots(t *testing.T) {
+	data, err := os.ReadFile(vectorsFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tv testVectors
+	if err := json.Unmarshal(data, &tv); err != nil {
+		t.Fatal(err)
+	}
+	if len(tv.Snapshots) == 0 {
+		t.Fatal("no snapshot in the fixture")
+	}
+	for _, sv := range tv.Snapshots {
+		snapshot, err := hex.DecodeString(sv.Snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := []Option{WithEmptyKeys()}
+		if sv.HMACKey != "" {
+			key, err := hex.DecodeString(sv.HMACKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts = append(opts, WithKey(key))
+		}
+		c, err := ReadCuckoo(bytes.NewReader(snapshot), opts...)
+		if err != nil {
+			t.Fatalf("%s version %d: %v", sv.Hash, sv.Version, err)
+		}
+		if family, seed := c.HashFamily(); family.Name != sv.Hash || seed != sv.Seed {
+			t.Errorf("%s version %d: loaded with hash %s seed %d", sv.Hash, sv.Version, family.Name, seed)
+		}
+		for _, k := range vectorKeys {
+			if !c.lookup(k) {
+				t.Errorf("%s version %d: key %q not found", sv.Hash, sv.Version, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Velocity limits.
+// A compromised client or insider drains a wallet by many withdrawals that
+// are each within limits, so the number and the value of the withdrawals
+// are also limited per wallet and per destination over sliding windows
+// (e.g., 1h and 24h). Velocity tracks them in count-min sketches, so the
+// memory does not grow with the number of destinations: each window is
+// split into slots, every slot has a count and a sum sketch, and a window
+// total adds the slots it covers. The window slides by one slot at a time,
+// so a total covers between window - slot and window of history.
+// A sketch overestimates, which only blocks a withdrawal early: never late.
+//
+// The destinations of a request are each charged its whole amount, since
+// the request does not split it among them.
+// VelocityHook charges a withdrawal in the same critical section as it
+// checks it, so withdrawals submitted at once are each checked against
+// the ones before: N concurrent requests cannot all pass a limit of N-1.
+// The charge of a request that is not signed after all is refunded.
+// Totals saturate at the maximum uint64 instead of wrapping, so a huge
+// amount can never wrap back under a limit.
+
+// velocitySlots is the number of slots of a window
+const velocitySlots = 12
+
+// VelocityLimit limits the withdrawals within a window.
+// A zero maximum is no limit.
+type VelocityLimit struct {
+	Window   time.Duration
+	MaxCount uint64 // number of withdrawals
+	MaxValue uint64 // total value, in the smallest unit
+}
+
+// VelocityPolicy holds the limits per wallet and per destination address
+type VelocityPolicy struct {
+	PerWallet      []VelocityLimit
+	PerDestination []VelocityLimit
+}
+
+// Velocity tracks the withdrawals per wallet and per destination.
+// It is safe for concurrent use.
+type Velocity struct {
+	mu      sync.Mutex
+	windows map[time.Duration]*slidingSketch
+	now     func() time.Time
+}
+
+// slidingSketch is the count and sum sketches of the slots of one window
+type slidingSketch struct {
+	slot   time.Duration
+	starts [velocitySlots]time.Time // start of the period of each slot
+	counts [velocitySlots]*CountMinSketch
+	sums   [velocitySlots]*CountMinSketch
+}
+
+// NewVelocity tracks withdrawals over the given windows (at least one).
+// eps is the error of the sketches, relative to the total of a slot.
+func NewVelocity(eps float64, windows ...time.Duration) *Velocity {
+	v := &Velocity{windows: make(map[time.Duration]*slidingSketch), now: time.Now}
+	for _, w := range windows {
+		s := &slidingSketch{slot: w / velocitySlots}
+		for i := range s.counts {
+			s.counts[i] = NewCountMinSketch(eps, 0.001)
+			s.sums[i] = NewCountMinSketch(eps, 0.001)
+		}
+		v.windows[w] = s
+	}
+	return v
+}
+
+// current returns the slot of now, emptied if it held an older period
+func (s *slidingSketch) current(now time.Time) int {
+	start := now.Truncate(s.slot)
+	i := int(start.UnixNano() / int64(s.slot) % velocitySlots)
+	if !s.starts[i].Equal(start) {
+		s.starts[i] = start
+		s.counts[i].Reset()
+		s.sums[i].Reset()
+	}
+	return i
+}
+
+// totals returns the count and the sum of the key over the window
+func (s *slidingSketch) totals(key string, now time.Time) (count, sum uint64) {
+	oldest := now.Truncate(s.slot).Add(-time.Duration(velocitySlots-1) * s.slot)
+	for i := range s.starts {
+		if s.starts[i].Before(oldest) {
+			continue
+		}
+		count = satAdd(count, s.counts[i].Count(key))
+		sum = satAdd(sum, s.sums[i].Count(key))
+	}
+	return count, sum
+}
+
+func walletKey(walletID string) string { return "wallet\x00" + walletID }
+func destKey(address string) string    { return "dest\x00" + address }
+
+func withdrawalKeys(walletID string, destinations []string) []string {
+	keys := []string{walletKey(walletID)}
+	for _, d := range destinations {
+		keys = append(keys, destKey(d))
+	}
+	return keys
+}
+
+// Record adds a signed withdrawal to the windows. Withdrawals allowed by a
+// VelocityHook are charged already, and must not be recorded again.
+func (v *Velocity) Record(walletID string, destinations []string, amount uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.record(withdrawalKeys(walletID, destinations), amount, v.now())
+}
+
+func (v *Velocity) record(keys []string, amount uint64, now time.Time) {
+	for _, s := range v.windows {
+		i := s.current(now)
+		for _, k := range keys {
+			s.counts[i].Add(k, 1)
+			s.sums[i].Add(k, amount)
+		}
+	}
+}
+
+// VelocityCharge is a withdrawal charged by Charge, which Refund takes back
+type VelocityCharge struct {
+	keys   []string
+	amount uint64
+	at     time.Time
+}
+
+// Charge checks a withdrawal like ExceedsVelocity and, if it exceeds no
+// limit, records it, atomically: of concurrent withdrawals, each is checked
+// against those charged before it
+func (v *Velocity) Charge(p VelocityPolicy, walletID string, destinations []string, amount uint64) (VelocityCharge, string, bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	reason, exceeded, err := v.exceeds(p, walletID, destinations, amount, now)
+	if exceeded || err != nil {
+		return VelocityCharge{}, reason, exceeded, err
+	}
+	c := VelocityCharge{keys: withdrawalKeys(walletID, destinations), amount: amount, at: now}
+	v.record(c.keys, amount, now)
+	return c, "", false, nil
+}
+
+// Refund takes back a charge of a withdrawal that was not signed. A charge
+// whose slot has since been reused is gone already.
+func (v *Velocity) Refund(c VelocityCharge) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, s := range v.windows {
+		start := c.at.Truncate(s.slot)
+		i := int(start.UnixNano() / int64(s.slot) % velocitySlots)
+		if !s.starts[i].Equal(start) {
+			continue
+		}
+		for _, k := range c.keys {
+			s.counts[i].subtract(k, 1)
+			s.sums[i].subtract(k, c.amount)
+		}
+	}
+}
+
+// ExceedsVelocity returns the first limit of the policy a withdrawal of amount
+// to destinations would exceed, with the recorded withdrawals. A limit on a
+// window that is not tracked is an error, rather than no limit.
+func (v *Velocity) ExceedsVelocity(p VelocityPolicy, walletID string, destinations []string, amount uint64) (string, bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.exceeds(p, walletID, destinations, amount, v.now())
+}
+
+func (v *Velocity) exceeds(p VelocityPolicy, walletID string, destinations []string, amount uint64, now time.Time) (string, bool, error) {
+	check := func(what, key string, limits []VelocityLimit) (string, bool, error) {
+		for _, l := range limits {
+			s, ok := v.windows[l.Window]
+			if !ok {
+				return "", false, fmt.Errorf("velocity window %v is not tracked", l.Window)
+			}
+			count, sum := s.totals(key, now)
+			if l.MaxCount > 0 && satAdd(count, 1) > l.MaxCount {
+				return fmt.Sprintf("%s: %d withdrawals in %v, limit %d", what, satAdd(count, 1), l.Window, l.MaxCount), true, nil
+			}
+			if l.MaxValue > 0 && satAdd(sum, amount) > l.MaxValue {
+				return fmt.Sprintf("%s: value %d in %v, limit %d", what, satAdd(sum, amount), l.Window, l.MaxValue), true, nil
+			}
+		}
+		return "", false, nil
+	}
+
+	if reason, ok, err := check("wallet "+walletID, walletKey(walletID), p.PerWallet); ok || err != nil {
+		return reason, ok, err
+	}
+	for _, d := range destinations {
+		if reason, ok, err := check("destination "+d, destKey(d), p.PerDestination); ok || err != nil {
+			return reason, ok, err
+		}
+	}
+	return "", false, nil
+}
+
+// VelocityHook blocks signing requests exceeding the velocity policy, and
+// charges the others (see Charge). It is a ReservingHook: the charge of a
+// request released, because a later hook blocked it or signing failed, is
+// refunded. Charges are kept for refunds for signReservation, the longest a
+// ceremony takes.
+// It is safe for concurrent use.
+type VelocityHook struct {
+	velocity *Velocity
+	policy   VelocityPolicy
+
+	mu      sync.Mutex
+	charges map[signedKey]VelocityCharge // by wallet and message hash
+	order   []signedAt                   // in the order of the charges
+}
+
+// NewVelocityHook returns a hook charging withdrawals to v under policy p
+func NewVelocityHook(v *Velocity, p VelocityPolicy) *VelocityHook {
+	return &VelocityHook{velocity: v, policy: p, charges: make(map[signedKey]VelocityCharge)}
+}
+
+func (h *VelocityHook) Name() string { return "velocity" }
+
+func (h *VelocityHook) Evaluate(_ context.Context, req SigningRequest) Decision {
+	c, reason, exceeded, err := h.velocity.Charge(h.policy, req.WalletID, req.Destinations, req.Amount)
+	if err != nil {
+		return block(h.Name(), "%v", err)
+	}
+	if exceeded {
+		return block(h.Name(), "%s", reason)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, e := range h.order {
+		if e.expires.After(c.at) {
+			break
+		}
+		if h.charges[e.key].at.Add(signReservation).Equal(e.expires) {
+			delete(h.charges, e.key)
+		}
+		n++
+	}
+	h.order = h.order[n:]
+	k := signedKey{walletID: req.WalletID, hash: req.MessageHash}
+	h.charges[k] = c
+	h.order = append(h.order, signedAt{key: k, expires: c.at.Add(signReservation)})
+	return allow(h.Name())
+}
+
+// Release refunds the charge of a request that was not signed
+func (h *VelocityHook) Release(req SigningRequest) {
+	k := signedKey{walletID: req.WalletID, hash: req.MessageHash}
+	h.mu.Lock()
+	c, ok := h.charges[k]
+	delete(h.charges, k)
+	h.mu.Unlock()
+	if ok {
+		h.velocity.Refund(c)
+	}
+}
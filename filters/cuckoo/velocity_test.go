@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVelocityLimits(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	v := NewVelocity(0.001, time.Hour)
+	v.now = func() time.Time { return now }
+	p := VelocityPolicy{
+		PerWallet:      []VelocityLimit{{Window: time.Hour, MaxCount: 3, MaxValue: 1000}},
+		PerDestination: []VelocityLimit{{Window: time.Hour, MaxValue: 600}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		dst    string
+		amount uint64
+		want   bool // exceeded
+	}{
+		{"first", "d1", 400, false},
+		{"destination value", "d1", 300, true},
+		{"other destination", "d2", 300, false},
+		{"wallet value", "d3", 400, true},
+		{"third", "d3", 200, false},
+		{"wallet count", "d4", 1, true},
+	} {
+		_, reason, exceeded, err := v.Charge(p, "w1", []string{tc.dst}, tc.amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exceeded != tc.want {
+			t.Errorf("%s: exceeded %v (%s), want %v", tc.name, exceeded, reason, tc.want)
+		}
+	}
+	if _, exceeded, _ := v.ExceedsVelocity(p, "w2", []string{"d4"}, 1); exceeded {
+		t.Error("limit of a wallet applied to another")
+	}
+
+	// the window slides past the withdrawals
+	now = now.Add(time.Hour + time.Hour/velocitySlots)
+	if _, exceeded, _ := v.ExceedsVelocity(p, "w1", []string{"d1"}, 600); exceeded {
+		t.Error("withdrawals counted past their window")
+	}
+
+	if _, _, err := v.ExceedsVelocity(VelocityPolicy{PerWallet: []VelocityLimit{{Window: 24 * time.Hour, MaxCount: 1}}}, "w1", nil, 1); err == nil {
+		t.Error("limit on an untracked window accepted")
+	}
+}
+
+func TestVelocitySaturation(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	v := NewVelocity(0.001, time.Hour)
+	v.now = func() time.Time { return now }
+	p := VelocityPolicy{PerWallet: []VelocityLimit{{Window: time.Hour, MaxValue: 1000}}}
+
+	v.Record("w1", nil, 500)
+	if _, exceeded, _ := v.ExceedsVelocity(p, "w1", nil, math.MaxUint64-100); !exceeded {
+		t.Error("amount wrapping the total under the limit allowed")
+	}
+
+	// totals of several slots saturate too
+	big := VelocityPolicy{PerWallet: []VelocityLimit{{Window: time.Hour, MaxValue: math.MaxUint64 - 1}}}
+	v.Record("w2", nil, math.MaxUint64/2+1)
+	now = now.Add(time.Hour / velocitySlots)
+	v.Record("w2", nil, math.MaxUint64/2+1)
+	if _, exceeded, _ := v.ExceedsVelocity(big, "w2", nil, 1); !exceeded {
+		t.Error("total of two slots wrapped under the limit")
+	}
+}
+
+// TestVelocityHookConcurrent checks that withdrawals submitted at once are
+// each charged before the next is checked
+func TestVelocityHookConcurrent(t *testing.T) {
+	v := NewVelocity(0.001, time.Hour)
+	h := NewVelocityHook(v, VelocityPolicy{PerWallet: []VelocityLimit{{Window: time.Hour, MaxCount: 3}}})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := SigningRequest{WalletID: "w1", Destinations: []string{"d1"}, Amount: 1, MessageHash: [32]byte{byte(i)}}
+			if h.Evaluate(ctx, req).Allowed() {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 3 {
+		t.Fatalf("%d withdrawals allowed at once, limit 3", n)
+	}
+
+	// a withdrawal released is refunded
+	var released SigningRequest
+	for k := range h.charges {
+		released = SigningRequest{WalletID: k.walletID, Destinations: []string{"d1"}, Amount: 1, MessageHash: k.hash}
+		break
+	}
+	h.Release(released)
+	next := SigningRequest{WalletID: "w1", Destinations: []string{"d1"}, Amount: 1, MessageHash: [32]byte{0xff}}
+	if !h.Evaluate(ctx, next).Allowed() {
+		t.Error("withdrawal blocked after a refund")
+	}
+	if h.Evaluate(ctx, SigningRequest{WalletID: "w1", Amount: 1, MessageHash: [32]byte{0xfe}}).Allowed() {
+		t.Error("withdrawal above the limit allowed after a refund")
+	}
+
+	// a blocking chain refunds the charge
+	chain := NewPresignChain(h, blockingHook{})
+	other := SigningRequest{WalletID: "w2", Amount: 1, MessageHash: [32]byte{1}}
+	for i := 0; i < 5; i++ {
+		chain.Evaluate(ctx, other)
+	}
+	if _, exceeded, _ := v.ExceedsVelocity(h.policy, "w2", nil, 1); exceeded {
+		t.Error("requests blocked by the chain charged")
+	}
+}